@@ -0,0 +1,102 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"net/http/pprof"
+)
+
+const METRICS_NAMESPACE = "tricorder_agent"
+
+// Metrics describe what the agent itself costs the node it runs on.
+type Metrics struct {
+	registry        *prometheus.Registry
+	packets         prometheus.Counter
+	bytes           prometheus.Counter
+	parseErrors     prometheus.Counter
+	streams         prometheus.Gauge
+	pendingRequests prometheus.Gauge
+	serverPushes    *prometheus.CounterVec
+	notMyVbuckets   *prometheus.CounterVec
+}
+
+func newMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		packets: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "packets_total",
+			Help:      "Packets read from the capture.",
+		}),
+		bytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "bytes_total",
+			Help:      "TCP payload bytes read from the capture.",
+		}),
+		parseErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "parse_errors_total",
+			Help:      "Packets that could not be parsed, the stream resynchronises on the next packet.",
+		}),
+		streams: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "streams",
+			Help:      "TCP streams tracked in the current capture.",
+		}),
+		pendingRequests: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "pending_requests",
+			Help:      "Requests waiting for their response.",
+		}),
+		serverPushes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "server_pushes_total",
+			Help:      "Server-initiated requests on duplex connections and the client responses to them.",
+		}, []string{"opcode"}),
+		notMyVbuckets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "not_my_vbucket_total",
+			Help:      "Operations answered NOT_MY_VBUCKET, sent on a stale cluster map.",
+		}, []string{"bucket"}),
+	}
+	m.registry.MustRegister(m.packets, m.bytes, m.parseErrors, m.streams, m.pendingRequests, m.serverPushes,
+		m.notMyVbuckets,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return m
+}
+
+// startAdminServer serves the agent metrics and pprof on the admin address. It is only started
+// when an admin address is configured, and should be bound to an address only operators can reach.
+func (agent *Agent) startAdminServer() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(agent.metrics.registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	agent.logger.Info("Starting the admin server at %v", agent.config.Admin)
+	if err := http.ListenAndServe(agent.config.Admin, mux); err != nil {
+		agent.logger.Error("Admin server stopped %v", err)
+	}
+}
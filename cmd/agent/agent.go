@@ -20,24 +20,42 @@ import (
 	"../../logger"
 	pb "../../rpc"
 	"./sniffers"
+	"encoding/json"
 	"fmt"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/pcap"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
 	"io"
+	"net"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Agent struct {
-	mutex         *sync.Mutex
-	packetSource  *gopacket.PacketSource
-	config        *Config
+	mutex        *sync.Mutex
+	packetSource *gopacket.PacketSource
+	config       *Config
+	// packetMutex is held while a packet is handled, along with isHandleAlive which it guards
+	packetMutex   *sync.Mutex
 	isHandleAlive bool
 	filter        string
+	ports         map[int]AnalyserConfig
 	streams       map[uint64]*Stream
-	logger        *logger.Logger
+	captureStats  func() (*sniffers.CaptureStats, error)
+	lastStats     *sniffers.CaptureStats
+	hotKeys       *HotKeys
+	keyPolicy     *KeyPolicy
+	// statementPolicy applies to the N1QL and analytics statements of the HTTP services
+	statementPolicy *StatementPolicy
+	keyLog          *KeyLog
+	dialOption      grpc.DialOption
+	metrics         *Metrics
+	logger          *logger.Logger
 }
 
 func afpacketComputeSize(targetSizeMb int, snaplen int, pageSize int) (
@@ -58,9 +76,32 @@ func afpacketComputeSize(targetSizeMb int, snaplen int, pageSize int) (
 	return frameSize, blockSize, numBlocks, nil
 }
 
+// serverPorts are the captured ports, in order.
+func (agent *Agent) serverPorts() []int {
+	var ports []int
+	for port := range agent.ports {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// captureFilter selects the server ports.
+func (agent *Agent) captureFilter() string {
+	ports := agent.serverPorts()
+	if len(ports) == 1 {
+		return fmt.Sprint("tcp and port ", ports[0])
+	}
+	filter := make([]string, len(ports))
+	for i, port := range ports {
+		filter[i] = fmt.Sprint("port ", port)
+	}
+	return "tcp and (" + strings.Join(filter, " or ") + ")"
+}
+
 func (agent *Agent) Initialize() {
 	snaplen := 1600
-	filter := fmt.Sprint("tcp and port ", agent.config.InterfaceConfig.Port)
+	filter := agent.captureFilter()
 	if agent.config.InterfaceConfig.CaptureType == AF_PACKET {
 		var afpacketHandle *sniffers.AfpacketHandle
 		_, blockSize, numBlocks, err := afpacketComputeSize(agent.config.InterfaceConfig.AfPacketTragetSizeInMB,
@@ -75,6 +116,7 @@ func (agent *Agent) Initialize() {
 			os.Exit(1)
 		} else {
 			agent.packetSource = afpacketHandle.GetPacketSource()
+			agent.captureStats = afpacketHandle.Stats
 		}
 	} else if agent.config.InterfaceConfig.CaptureType == PF_RING {
 		var pfringHandle *sniffers.PfringHandle
@@ -89,6 +131,7 @@ func (agent *Agent) Initialize() {
 			os.Exit(1)
 		} else {
 			agent.packetSource = pfringHandle.GetPacketSource()
+			agent.captureStats = pfringHandle.Stats
 		}
 	} else {
 		var handle *pcap.Handle
@@ -102,58 +145,205 @@ func (agent *Agent) Initialize() {
 			os.Exit(1)
 		} else {
 			agent.packetSource = gopacket.NewPacketSource(handle, handle.LinkType())
+			agent.captureStats = func() (*sniffers.CaptureStats, error) {
+				stats, err := handle.Stats()
+				if err != nil {
+					return nil, err
+				}
+				return &sniffers.CaptureStats{
+					Received: uint64(stats.PacketsReceived),
+					Dropped:  uint64(stats.PacketsDropped + stats.PacketsIfDropped),
+				}, nil
+			}
 		}
 	}
 
 	agent.packetSource.DecodeOptions.NoCopy = true
 }
 
+func (agent *Agent) isServerPort(port string) bool {
+	number, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+	_, ok := agent.ports[number]
+	return ok
+}
+
+// endpoints returns the client and server address of the connection the packet belongs to, the
+// server being the side on the captured port.
+func (agent *Agent) endpoints(packet gopacket.Packet) (string, string) {
+	transportFlow := packet.TransportLayer().TransportFlow()
+	srcHost, dstHost := "", ""
+	if network := packet.NetworkLayer(); network != nil {
+		srcHost = network.NetworkFlow().Src().String()
+		dstHost = network.NetworkFlow().Dst().String()
+	}
+	src := net.JoinHostPort(srcHost, transportFlow.Src().String())
+	dst := net.JoinHostPort(dstHost, transportFlow.Dst().String())
+	if agent.isServerPort(transportFlow.Src().String()) {
+		return dst, src
+	}
+	return src, dst
+}
+
 func (agent *Agent) handlePacket(packet gopacket.Packet) {
 	transport := packet.TransportLayer()
+	if transport == nil {
+		return
+	}
+	agent.metrics.packets.Inc()
+	agent.metrics.bytes.Add(float64(len(transport.LayerPayload())))
 	streamKey := transport.TransportFlow().FastHash()
 	if agent.streams[streamKey] == nil {
-		agent.streams[streamKey] = &Stream{
-			currentRequests:  make(map[uint32]*Command),
-			currentResponses: make(map[uint32]*Command),
-			src:              transport.TransportFlow().Src().String(),
-			dst:              transport.TransportFlow().Dst().String(),
-			mutex:            &sync.Mutex{},
+		client, server := agent.endpoints(packet)
+		stream := &Stream{
+			src:     transport.TransportFlow().Src().String(),
+			dst:     transport.TransportFlow().Dst().String(),
+			client:  client,
+			server:  server,
+			mutex:   &sync.Mutex{},
+			metrics: agent.metrics,
+		}
+		_, port, _ := net.SplitHostPort(server)
+		number, _ := strconv.Atoi(port)
+		analyser := agent.ports[number]
+		if analyser.TLS {
+			stream.tls = NewTLSSession(agent.keyLog)
 		}
+		if analyser.Analyser == AUTO_ANALYSER {
+			stream.detect = true
+		} else if analyserType := analyserType(analyser.Analyser); analyserType != nil {
+			stream.analyser = analyserType.New(analyser.Option, agent.metrics)
+		}
+		agent.streams[streamKey] = stream
+		agent.metrics.streams.Set(float64(len(agent.streams)))
+	}
+	// offline captures are timed by when the packets were captured rather than when they are read
+	timestamp := packet.Metadata().Timestamp.UnixNano()
+	if packet.Metadata().Timestamp.IsZero() {
+		timestamp = time.Now().UnixNano()
 	}
-	agent.streams[streamKey].HandlePacket(transport.LayerPayload())
+	fromClient := !agent.isServerPort(transport.TransportFlow().Src().String())
+	agent.streams[streamKey].HandlePacket(transport.LayerPayload(), fromClient, timestamp)
 }
 
 func (agent *Agent) startCapture() {
 	agent.mutex.Lock() //only one capture can proceed at any point
+	agent.packetMutex.Lock()
 	agent.isHandleAlive = true
 	agent.streams = make(map[uint64]*Stream)
+	agent.hotKeys = NewHotKeys(&agent.config.HotKeys)
+	agent.packetMutex.Unlock()
+	agent.metrics.streams.Set(0)
+	agent.metrics.pendingRequests.Set(0)
 
-	for agent.isHandleAlive {
+	for {
 		packet, err := agent.packetSource.NextPacket()
 
-		if err == io.EOF {
+		agent.packetMutex.Lock()
+		// a packet that arrives once the capture is stopped belongs to no window
+		if !agent.isHandleAlive {
+			agent.packetMutex.Unlock()
+			break
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
 			agent.isHandleAlive = false
+			agent.packetMutex.Unlock()
 			agent.logger.Info("Handle is no longer alive")
 			break
 		}
-		agent.handlePacket(packet)
+		if err == nil {
+			agent.handlePacket(packet)
+		}
+		agent.packetMutex.Unlock()
 	}
 	agent.mutex.Unlock()
 }
 
-func (agent *Agent) GetResults() map[string]*pb.AgentResultsResponse_CaptureInfo {
-	responseStats := make(map[string]*pb.AgentResultsResponse_CaptureInfo)
+// windowStats returns the packets received and dropped by the kernel since the previous call.
+func (agent *Agent) windowStats() *sniffers.CaptureStats {
+	window := &sniffers.CaptureStats{}
+	if agent.captureStats == nil {
+		return window
+	}
+	stats, err := agent.captureStats()
+	if err != nil {
+		agent.logger.Error("Unable to read the capture stats %v", err)
+		return window
+	}
+	if agent.lastStats != nil && stats.Received >= agent.lastStats.Received && stats.Dropped >= agent.lastStats.Dropped {
+		window.Received = stats.Received - agent.lastStats.Received
+		window.Dropped = stats.Dropped - agent.lastStats.Dropped
+	} else {
+		window.Received = stats.Received
+		window.Dropped = stats.Dropped
+	}
+	agent.lastStats = stats
+	return window
+}
 
-	for streamkey, stream := range agent.streams {
-		for _, row := range stream.latencyInfo {
-			responseStats[strconv.Itoa(int(row.Opaque))+strconv.FormatUint(streamkey, 10)] = &pb.AgentResultsResponse_CaptureInfo{
-				Opaque:    strconv.Itoa(int(row.Opaque)),
-				Oplatency: fmt.Sprintf("%v", row.Latency/1000),
-				Key:       row.Key,
-			}
+func (agent *Agent) GetHotKeys() []*pb.AgentResultsResponse_HotKey {
+	var hotKeys []*pb.AgentResultsResponse_HotKey
+	// with redacted keys every hot key would look the same
+	if agent.hotKeys == nil || agent.keyPolicy.redacts() {
+		return hotKeys
+	}
+	for _, hotKey := range agent.hotKeys.Top() {
+		hotKeys = append(hotKeys, &pb.AgentResultsResponse_HotKey{
+			Bucket:  hotKey.Bucket,
+			Key:     hotKey.Key,
+			Count:   hotKey.Count,
+			Latency: hotKey.Latency,
+		})
+	}
+	return hotKeys
+}
+
+func (agent *Agent) GetTLSConnections() []*pb.AgentResultsResponse_TlsConnection {
+	var connections []*pb.AgentResultsResponse_TlsConnection
+	for _, stream := range agent.streams {
+		if stream.tls == nil {
+			continue
+		}
+		stats := stream.tls.Stats()
+		connections = append(connections, &pb.AgentResultsResponse_TlsConnection{
+			Client:            stream.client,
+			Server:            stream.server,
+			Version:           stats.Version,
+			CipherSuite:       stats.CipherSuite,
+			Decryption:        stats.Decryption,
+			Records:           stats.Records,
+			Exchanges:         stats.Exchanges,
+			Latency:           stats.Latency,
+			MaxLatency:        stats.MaxLatency,
+			HandshakeDuration: stats.HandshakeDuration,
+			Resumed:           stats.Resumed,
+			Alerts:            stats.Alerts,
+			LastAlert:         stats.LastAlert,
+			ExchangeLatencies: stats.ExchangeLatencies,
+		})
+	}
+	return connections
+}
+
+// Results is the response to the coordinator for a capture window, which records fill in as they
+// report themselves along with the stream they were captured on.
+type Results struct {
+	*pb.AgentResultsResponse
+	agent     *Agent
+	stream    *Stream
+	streamKey uint64
+}
+
+// report has the records of every stream report themselves into results.
+func (agent *Agent) report(results *Results) {
+	for streamKey, stream := range agent.streams {
+		results.stream, results.streamKey = stream, streamKey
+		for _, record := range stream.records {
+			record.report(results)
 		}
 	}
-	return responseStats
 }
 
 func (agent *Agent) CaptureSignal(context.Context, *pb.CoordinatorCaptureRequest) (*pb.AgentCaptureResponse, error) {
@@ -161,8 +351,12 @@ func (agent *Agent) CaptureSignal(context.Context, *pb.CoordinatorCaptureRequest
 	return &pb.AgentCaptureResponse{Status: "success"}, nil
 }
 
+// stopCapture ends the capture window. Once it returns no packet is being handled, so the streams
+// of the window can be read even while the capture loop still waits for its next packet.
 func (agent *Agent) stopCapture() {
+	agent.packetMutex.Lock()
 	agent.isHandleAlive = false
+	agent.packetMutex.Unlock()
 }
 
 func (agent *Agent) shutdown() {
@@ -179,9 +373,43 @@ func (agent *Agent) GoodByeSignal(context.Context, *pb.CoordinatorGoodByeRequest
 
 func (agent *Agent) AgentResults(context.Context, *pb.CoordinatorResultsRequest) (*pb.AgentResultsResponse, error) {
 	agent.stopCapture()
-	captureMap := agent.GetResults()
-	return &pb.AgentResultsResponse{
-		Status:     "success",
-		CaptureMap: captureMap,
-	}, nil
+	stats := agent.windowStats()
+	results := &Results{
+		AgentResultsResponse: &pb.AgentResultsResponse{
+			Status:          "success",
+			CaptureMap:      make(map[string]*pb.AgentResultsResponse_CaptureInfo),
+			PacketsReceived: stats.Received,
+			PacketsDropped:  stats.Dropped,
+			KeyPolicy:       agent.keyPolicy.String(),
+			TlsConnections:  agent.GetTLSConnections(),
+			StatementPolicy: agent.statementPolicy.String(),
+		},
+		agent: agent,
+	}
+	agent.report(results)
+	// operations track their keys as they report
+	results.HotKeys = agent.GetHotKeys()
+	return results.AgentResultsResponse, nil
+}
+
+// AnalyseFile runs a single capture over a pcap file instead of the live interface and writes the
+// results as json, decrypting TLS connections with the key log when one is configured.
+func (agent *Agent) AnalyseFile(file string, out io.Writer) error {
+	handle, err := pcap.OpenOffline(file)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+	if err := handle.SetBPFFilter(agent.captureFilter()); err != nil {
+		return err
+	}
+	agent.packetSource = gopacket.NewPacketSource(handle, handle.LinkType())
+	agent.startCapture()
+	results, err := agent.AgentResults(context.Background(), &pb.CoordinatorResultsRequest{})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
 }
@@ -0,0 +1,133 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"../../logger"
+	pb "../../rpc"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// channelSource hands out the frames sent on it and blocks in between, like a quiet interface.
+type channelSource chan []byte
+
+func (c channelSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ok := <-c
+	if !ok {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	return data, gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}, nil
+}
+
+// tcpFrame wraps payload in an ethernet frame between two local ports.
+func tcpFrame(t *testing.T, srcPort int, dstPort int, payload []byte) []byte {
+	ethernet := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolTCP,
+		SrcIP: net.IP{127, 0, 0, 1}, DstIP: net.IP{127, 0, 0, 1}}
+	tcp := &layers.TCP{SrcPort: layers.TCPPort(srcPort), DstPort: layers.TCPPort(dstPort), PSH: true, ACK: true}
+	tcp.SetNetworkLayerForChecksum(ip)
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, options, ethernet, ip, tcp, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func newTestAgent() *Agent {
+	agent := &Agent{
+		config:      &Config{},
+		mutex:       &sync.Mutex{},
+		packetMutex: &sync.Mutex{},
+		ports:       map[int]AnalyserConfig{11210: {Analyser: MEMCACHED_ANALYSER}},
+		metrics:     newMetrics(),
+		logger:      &logger.Logger{},
+	}
+	agent.logger.Init("", logger.ERRORLEVEL)
+	agent.config.setDefaults()
+	agent.keyPolicy, _ = NewKeyPolicy(&agent.config.Keys)
+	agent.statementPolicy, _ = NewStatementPolicy(&agent.config.Statements)
+	return agent
+}
+
+func TestAgentResultsWhileCaptureWaits(t *testing.T) {
+	agent := newTestAgent()
+	packets := make(channelSource)
+	agent.packetSource = gopacket.NewPacketSource(packets, layers.LayerTypeEthernet)
+	done := make(chan bool)
+	go func() {
+		agent.startCapture()
+		close(done)
+	}()
+
+	packets <- tcpFrame(t, 50000, 11210, packet(MAGIC_REQUEST, 0x00, "k1", nil, nil, 1, 0))
+	packets <- tcpFrame(t, 11210, 50000, packet(MAGIC_RESPONSE, 0x00, "", make([]byte, 4), []byte("v"), 1, 0))
+	// once the next frame is taken the response has been handled, and the loop waits again
+	packets <- tcpFrame(t, 50000, 11210, nil)
+
+	results, err := agent.AgentResults(context.Background(), &pb.CoordinatorResultsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.CaptureMap) != 1 {
+		t.Errorf("expected one operation, got %+v", results.CaptureMap)
+	}
+	// a request captured after the window ended is left out of it
+	packets <- tcpFrame(t, 50000, 11210, packet(MAGIC_REQUEST, 0x00, "k2", nil, nil, 2, 0))
+	<-done
+	for _, stream := range agent.streams {
+		if session := stream.analyser.(*MemcachedSession); len(session.currentRequests) != 0 {
+			t.Errorf("a request was handled after the capture stopped %+v", session.currentRequests)
+		}
+	}
+	close(packets)
+}
+
+func TestHotKeysFollowKeyPolicy(t *testing.T) {
+	agent := newTestAgent()
+	agent.config.Keys.Policy = "prefix:5"
+	agent.keyPolicy, _ = NewKeyPolicy(&agent.config.Keys)
+	agent.hotKeys = NewHotKeys(&agent.config.HotKeys)
+	results := &Results{AgentResultsResponse: &pb.AgentResultsResponse{CaptureMap: map[string]*pb.AgentResultsResponse_CaptureInfo{}},
+		agent: agent, stream: &Stream{}}
+	for i, key := range []string{"user:1", "user:2", "user:3", "order:1"} {
+		operation := &Operation{LatencyInfo: LatencyInfo{Opaque: uint32(i), Bucket: "default", Key: key, Latency: 10}}
+		operation.report(results)
+	}
+
+	hotKeys := agent.GetHotKeys()
+	if len(hotKeys) != 2 {
+		t.Fatalf("expected one hot key per prefix, got %+v", hotKeys)
+	}
+	for _, hotKey := range hotKeys {
+		if hotKey.Key == "user:" && hotKey.Count != 3 || hotKey.Key == "order" && hotKey.Count != 1 ||
+			hotKey.Key != "user:" && hotKey.Key != "order" {
+			t.Errorf("unexpected hot key %+v", hotKey)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	MEMCACHED_ANALYSER = "memcached"
+	TEXT_ANALYSER      = "memcached_text"
+	HTTP_ANALYSER      = "http"
+	// AUTO_ANALYSER picks the analyser of each connection from the first data it sees
+	AUTO_ANALYSER = "auto"
+)
+
+// Analyser follows one protocol over a connection. It is handed each direction as a stream of
+// bytes, in the order they were captured at timestamp in nanoseconds, and returns the transactions
+// the data completed as records for the stream to report.
+type Analyser interface {
+	Read(data []byte, fromClient bool, timestamp int64) ([]Record, error)
+}
+
+// Record is a transaction an analyser completed, or the stats of a connection that it keeps
+// updating once returned. Records report themselves into the results of the capture window, so
+// streams and the agent never need to know the protocol they come from.
+type Record interface {
+	report(results *Results)
+}
+
+// AnalyserType creates the analyser of each connection to a port it is enabled on, option being
+// the one the port was configured with. Detect tells from the first data of a connection whether
+// it speaks the protocol, it is nil for analysers that have to be enabled by port.
+type AnalyserType struct {
+	Name   string
+	New    func(option string, metrics *Metrics) Analyser
+	Detect func(data []byte, fromClient bool) bool
+}
+
+// analyserTypes are the analysers ports can enable, auto detection tries them in this order.
+var analyserTypes = []*AnalyserType{
+	{
+		Name: MEMCACHED_ANALYSER,
+		New: func(option string, metrics *Metrics) Analyser {
+			return NewMemcachedSession(metrics)
+		},
+		Detect: looksLikeMemcached,
+	},
+	{
+		Name: TEXT_ANALYSER,
+		New: func(option string, metrics *Metrics) Analyser {
+			return NewTextSession(option, metrics)
+		},
+		Detect: looksLikeText,
+	},
+	{
+		Name: HTTP_ANALYSER,
+		New: func(option string, metrics *Metrics) Analyser {
+			if option == "" {
+				option = HTTP_ANALYSER
+			}
+			return NewHTTPSession(option)
+		},
+		Detect: looksLikeHTTP,
+	},
+	{
+		Name: CLUSTER_MANAGER_SERVICE,
+		New: func(option string, metrics *Metrics) Analyser {
+			return NewClusterManagerSession()
+		},
+	},
+}
+
+func analyserType(name string) *AnalyserType {
+	for _, analyser := range analyserTypes {
+		if analyser.Name == name {
+			return analyser
+		}
+	}
+	return nil
+}
+
+// detectAnalyser returns the analyser of the first type that recognises data, nil when none does
+// and the next packet should be tried instead.
+func detectAnalyser(data []byte, fromClient bool, metrics *Metrics) Analyser {
+	for _, analyser := range analyserTypes {
+		if analyser.Detect != nil && analyser.Detect(data, fromClient) {
+			return analyser.New("", metrics)
+		}
+	}
+	return nil
+}
+
+func looksLikeMemcached(data []byte, fromClient bool) bool {
+	if len(data) < HEADER_LENGTH {
+		return false
+	}
+	switch data[0] {
+	case MAGIC_REQUEST, MAGIC_ALT_REQUEST, MAGIC_SERVER_RESPONSE:
+		return fromClient
+	case MAGIC_RESPONSE, MAGIC_ALT_RESPONSE, MAGIC_SERVER_REQUEST:
+		return !fromClient
+	}
+	return false
+}
+
+// looksLikeText only recognises requests, the responses of the text protocol are too generic.
+func looksLikeText(data []byte, fromClient bool) bool {
+	if !fromClient {
+		return false
+	}
+	fields := bytes.Fields(firstLine(data))
+	if len(fields) == 0 {
+		return false
+	}
+	_, ok := textCommands[string(fields[0])]
+	return ok || textAdminCommands[string(fields[0])]
+}
+
+func looksLikeHTTP(data []byte, fromClient bool) bool {
+	line := firstLine(data)
+	if !fromClient {
+		return bytes.HasPrefix(line, []byte("HTTP/1."))
+	}
+	fields := bytes.Fields(line)
+	return len(fields) == 3 && httpMethods[string(fields[0])] && bytes.HasPrefix(fields[2], []byte("HTTP/1."))
+}
+
+func firstLine(data []byte) []byte {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[:i]
+	}
+	return bytes.TrimSuffix(data, []byte("\r"))
+}
+
+// portAnalysers returns the analyser of every captured port: those of the analysers section along
+// with the memcached, TLS, text protocol, HTTP and cluster manager ports configured on their own.
+func (config *InterfaceConfig) portAnalysers() (map[int]AnalyserConfig, error) {
+	ports := map[int]AnalyserConfig{
+		config.Port: {Analyser: MEMCACHED_ANALYSER},
+	}
+	if config.TLSPort != 0 {
+		ports[config.TLSPort] = AnalyserConfig{Analyser: MEMCACHED_ANALYSER, TLS: true}
+	}
+	for port, bucket := range config.TextPorts {
+		ports[port] = AnalyserConfig{Analyser: TEXT_ANALYSER, Option: bucket}
+	}
+	for port, service := range config.HTTPPorts {
+		ports[port] = AnalyserConfig{Analyser: HTTP_ANALYSER, Option: service}
+	}
+	if config.ClusterManagerPort != 0 {
+		ports[config.ClusterManagerPort] = AnalyserConfig{Analyser: CLUSTER_MANAGER_SERVICE}
+	}
+	for port, analyser := range config.Analysers {
+		if analyser.Analyser != AUTO_ANALYSER && analyserType(analyser.Analyser) == nil {
+			return nil, fmt.Errorf("unknown analyser %v on port %v", analyser.Analyser, port)
+		}
+		ports[port] = analyser
+	}
+	return ports, nil
+}
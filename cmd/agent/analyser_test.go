@@ -0,0 +1,98 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestPortAnalysers(t *testing.T) {
+	config := &InterfaceConfig{Port: 11210, TLSPort: 11207, TextPorts: map[int]string{11211: "default"},
+		HTTPPorts: map[int]string{8093: "query"}, ClusterManagerPort: 8091,
+		Analysers: map[int]AnalyserConfig{9000: {Analyser: AUTO_ANALYSER}, 8093: {Analyser: HTTP_ANALYSER, Option: "n1ql"}}}
+	ports, err := config.portAnalysers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the analysers section overrides the port of a service
+	expected := map[int]AnalyserConfig{
+		11210: {Analyser: MEMCACHED_ANALYSER},
+		11207: {Analyser: MEMCACHED_ANALYSER, TLS: true},
+		11211: {Analyser: TEXT_ANALYSER, Option: "default"},
+		8093:  {Analyser: HTTP_ANALYSER, Option: "n1ql"},
+		8091:  {Analyser: CLUSTER_MANAGER_SERVICE},
+		9000:  {Analyser: AUTO_ANALYSER},
+	}
+	if !reflect.DeepEqual(ports, expected) {
+		t.Errorf("expected %+v, got %+v", expected, ports)
+	}
+	agent := &Agent{ports: ports}
+	if filter := agent.captureFilter(); filter != "tcp and (port 8091 or port 8093 or port 9000 or port 11207 or port 11210 or port 11211)" {
+		t.Errorf("unexpected capture filter %v", filter)
+	}
+
+	config.Analysers[1] = AnalyserConfig{Analyser: "unknown"}
+	if _, err := config.portAnalysers(); err == nil {
+		t.Error("expected an unknown analyser to be refused")
+	}
+}
+
+func TestDetectAnalyser(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		fromClient bool
+		analyser   string
+	}{
+		{"memcached request", packet(MAGIC_REQUEST, 0x00, "k", nil, nil, 1, 0), true, "*main.MemcachedSession"},
+		{"memcached response", packet(MAGIC_RESPONSE, 0x00, "", nil, nil, 1, 0), false, "*main.MemcachedSession"},
+		{"server push", packet(MAGIC_SERVER_REQUEST, 0x01, "", nil, nil, 1, 0), false, "*main.MemcachedSession"},
+		{"memcached request from the server", packet(MAGIC_REQUEST, 0x00, "k", nil, nil, 1, 0), false, "<nil>"},
+		{"part of a memcached header", packet(MAGIC_REQUEST, 0x00, "k", nil, nil, 1, 0)[:10], true, "<nil>"},
+		{"text request", []byte("get a b\r\n"), true, "*main.TextSession"},
+		{"text admin request", []byte("stats\r\n"), true, "*main.TextSession"},
+		{"text response", []byte("VALUE a 0 1\r\nx\r\nEND\r\n"), false, "<nil>"},
+		{"http request", []byte("GET /pools HTTP/1.1\r\nHost: x\r\n\r\n"), true, "*main.HTTPSession"},
+		{"http response", []byte("HTTP/1.1 200 OK\r\n\r\n"), false, "*main.HTTPSession"},
+		{"unknown method", []byte("BREW /pot HTTP/1.1\r\n\r\n"), true, "<nil>"},
+		{"garbage", []byte("garbage"), true, "<nil>"},
+	}
+	for _, test := range tests {
+		analyser := detectAnalyser(test.data, test.fromClient, newMetrics())
+		if name := fmt.Sprintf("%T", analyser); name != test.analyser {
+			t.Errorf("%v: expected %v, got %v", test.name, test.analyser, name)
+		}
+	}
+}
+
+// A stream on an auto detected port analyses nothing until a packet tells its protocol.
+func TestStreamDetectsAnalyser(t *testing.T) {
+	stream := &Stream{detect: true, mutex: &sync.Mutex{}, metrics: newMetrics()}
+	stream.HandlePacket([]byte("garbage"), true, 1000)
+	if stream.analyser != nil {
+		t.Fatalf("expected no analyser, got %T", stream.analyser)
+	}
+	stream.HandlePacket([]byte("GET /pools HTTP/1.1\r\n\r\n"), true, 2000)
+	stream.HandlePacket([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\n{}"), false, 5000)
+	requests := httpRequests(stream.records)
+	if _, ok := stream.analyser.(*HTTPSession); !ok || len(requests) != 1 || requests[0].Service != HTTP_ANALYSER {
+		t.Errorf("expected the request to be analysed as http, got %T with %+v", stream.analyser, stream.records)
+	}
+}
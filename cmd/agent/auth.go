@@ -0,0 +1,126 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"../../tlsconfig"
+	"crypto/subtle"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+const AUTHORIZATION_HEADER = "authorization"
+
+// Authorizer decides whether a caller may use the agent's RPCs and leaves an audit line for every
+// call, whether it is allowed or not.
+type Authorizer struct {
+	token        string
+	coordinators map[string]bool
+	agent        *Agent
+}
+
+func NewAuthorizer(agent *Agent) (*Authorizer, error) {
+	config := &agent.config.Auth
+	if len(config.Coordinators) > 0 && !agent.config.TLS.ClientAuth {
+		return nil, fmt.Errorf("Coordinator allow-list needs tls clientauth to identify coordinators")
+	}
+	if config.Token != "" && !agent.config.TLS.Enabled() {
+		return nil, fmt.Errorf("Auth token needs tls, it would otherwise be visible on the network")
+	}
+	authorizer := &Authorizer{token: config.Token, coordinators: map[string]bool{}, agent: agent}
+	for _, coordinator := range config.Coordinators {
+		authorizer.coordinators[coordinator] = true
+	}
+	return authorizer, nil
+}
+
+// identities are the names the caller's verified client certificate was issued to.
+func identities(ctx context.Context) []string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	return tlsconfig.Identities(tlsInfo.State)
+}
+
+func (a *Authorizer) authorize(ctx context.Context) (string, error) {
+	names := identities(ctx)
+	identity := strings.Join(names, ",")
+	if len(a.coordinators) > 0 {
+		allowed := false
+		for _, name := range names {
+			allowed = allowed || a.coordinators[name]
+		}
+		if !allowed {
+			return identity, status.Errorf(codes.PermissionDenied, "coordinator %q is not allowed", identity)
+		}
+	}
+	if a.token != "" {
+		md, _ := metadata.FromIncomingContext(ctx)
+		token := ""
+		if values := md.Get(AUTHORIZATION_HEADER); len(values) > 0 {
+			token = strings.TrimPrefix(values[0], "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			return identity, status.Errorf(codes.Unauthenticated, "missing or invalid token")
+		}
+	}
+	return identity, nil
+}
+
+func (a *Authorizer) audit(ctx context.Context, method string, identity string, err error) {
+	address := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		address = p.Addr.String()
+	}
+	if err != nil {
+		a.agent.logger.Error("Audit: denied %v from %v identity %q: %v", method, address, identity, err)
+		return
+	}
+	a.agent.logger.Info("Audit: allowed %v from %v identity %q", method, address, identity)
+}
+
+func (a *Authorizer) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	identity, err := a.authorize(ctx)
+	a.audit(ctx, info.FullMethod, identity, err)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *Authorizer) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	identity, err := a.authorize(ss.Context())
+	a.audit(ss.Context(), info.FullMethod, identity, err)
+	if err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
@@ -0,0 +1,132 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	pb "../../rpc"
+	"bytes"
+)
+
+const CLUSTER_MANAGER_SERVICE = "cluster_manager"
+
+// the cluster manager ends every config it streams with four newlines
+var streamedConfigEnd = []byte("\n\n\n\n")
+
+// Streaming requests of the cluster manager, their response never ends and carries a new config
+// whenever the cluster changes.
+var streamingConfigPaths = map[string]bool{
+	"/pools/default/bs/{bucket}":               true,
+	"/pools/default/bucketsStreaming/{bucket}": true,
+	"/poolsStreaming/default":                  true,
+	"/pools/default/nodeServicesStreaming":     true,
+}
+
+// ConfigStream is a streaming config request on the cluster manager port, sent at Start and with
+// the configs pushed on it between FirstConfig and LastConfig, all in nanoseconds. The intervals
+// between pushes are in microseconds. A resumed stream was opened before the capture, its path is
+// not known.
+type ConfigStream struct {
+	Path        string
+	Resumed     bool
+	Start       int64
+	FirstConfig int64
+	LastConfig  int64
+	Configs     uint64
+	Bytes       int64
+	MinInterval int64
+	MaxInterval int64
+	request     *httpMessage
+}
+
+func (c *ConfigStream) report(results *Results) {
+	results.ConfigStreams = append(results.ConfigStreams, &pb.AgentResultsResponse_ConfigStream{
+		Client:      results.stream.client,
+		Server:      results.stream.server,
+		Path:        c.Path,
+		Resumed:     c.Resumed,
+		Start:       c.Start,
+		FirstConfig: c.FirstConfig,
+		LastConfig:  c.LastConfig,
+		Configs:     c.Configs,
+		Bytes:       c.Bytes,
+		MinInterval: c.MinInterval,
+		MaxInterval: c.MaxInterval,
+	})
+}
+
+// ClusterManagerSession follows a connection to the cluster manager like any HTTP service, and
+// times the configs pushed on its streaming requests. The streams outlive capture windows, so the
+// capture can join them at any config.
+type ClusterManagerSession struct {
+	*HTTPSession
+	last   *ConfigStream
+	opened []Record
+}
+
+func NewClusterManagerSession() *ClusterManagerSession {
+	session := &ClusterManagerSession{HTTPSession: NewHTTPSession(CLUSTER_MANAGER_SERVICE)}
+	session.resume = true
+	session.onChunk = session.configChunk
+	return session
+}
+
+// Read returns the requests whose response the packet completed, along with the streams that got
+// their first config, which are updated in place as more configs come.
+func (s *ClusterManagerSession) Read(data []byte, fromClient bool, timestamp int64) ([]Record, error) {
+	records, err := s.HTTPSession.Read(data, fromClient, timestamp)
+	records = append(records, s.opened...)
+	s.opened = nil
+	return records, err
+}
+
+func (s *ClusterManagerSession) configChunk(request *httpMessage, response *httpMessage, timestamp int64) {
+	if !request.resumed && !streamingConfigPaths[httpPathTemplate(request.target)] {
+		return
+	}
+	// a config can be split over several chunks, it is complete once the separator is read
+	if !bytes.HasSuffix(response.tail, streamedConfigEnd) {
+		return
+	}
+	response.tail = nil
+	configStream := s.last
+	if configStream == nil || configStream.request != request {
+		configStream = &ConfigStream{
+			Resumed: request.resumed,
+			Start:   request.start,
+			request: request,
+		}
+		if !request.resumed {
+			configStream.Path = httpPathTemplate(request.target)
+		}
+		s.last = configStream
+		s.opened = append(s.opened, configStream)
+	}
+	if configStream.Configs == 0 {
+		configStream.FirstConfig = timestamp
+	} else {
+		interval := (timestamp - configStream.LastConfig) / 1000
+		if configStream.Configs == 1 || interval < configStream.MinInterval {
+			configStream.MinInterval = interval
+		}
+		if interval > configStream.MaxInterval {
+			configStream.MaxInterval = interval
+		}
+	}
+	configStream.LastConfig = timestamp
+	configStream.Configs++
+	configStream.Bytes = response.size
+}
@@ -0,0 +1,74 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func configStreams(records []Record) []*ConfigStream {
+	var streams []*ConfigStream
+	for _, record := range records {
+		if stream, ok := record.(*ConfigStream); ok {
+			streams = append(streams, stream)
+		}
+	}
+	return streams
+}
+
+func TestConfigStreams(t *testing.T) {
+	tests := []struct {
+		name     string
+		packets  []packetData
+		requests int
+		errors   int
+		expected ConfigStream
+	}{
+		// a config split over two chunks is complete at the separator
+		{"streaming request", []packetData{
+			{[]byte("GET /pools HTTP/1.1\r\n\r\n"), true, 1000000},
+			{[]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\n{}"), false, 1500000},
+			{[]byte("GET /pools/default/bs/travel HTTP/1.1\r\n\r\n"), true, 2000000},
+			{[]byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" + httpChunk(`{"rev":1}`) + httpChunk("\n\n\n\n")), false, 3000000},
+			{[]byte(httpChunk(`{"rev":2,`)), false, 5000000},
+			{[]byte(httpChunk(`"x":1}` + "\n\n\n\n")), false, 6000000},
+			{[]byte(httpChunk(`{"rev":3}`) + httpChunk("\n\n\n\n")), false, 16000000},
+		}, 1, 0, ConfigStream{Path: "/pools/default/bs/{bucket}", Start: 2000000, FirstConfig: 3000000, LastConfig: 16000000,
+			Configs: 3, Bytes: 45, MinInterval: 3000, MaxInterval: 10000}},
+		// the capture joins in the middle of a chunk of a stream opened before it, the rest of the
+		// chunk can not be read
+		{"resumed stream", []packetData{
+			{[]byte(`"tail":1}` + "\r\n"), false, 1000},
+			{[]byte(httpChunk(`{"rev":9}`) + httpChunk("\n\n\n\n")), false, 2000},
+			{[]byte(httpChunk(`{"rev":10}` + "\n\n\n\n")), false, 2002000},
+		}, 0, 1, ConfigStream{Resumed: true, Start: 2000, FirstConfig: 2000, LastConfig: 2002000, Configs: 2, Bytes: 27,
+			MinInterval: 2000, MaxInterval: 2000}},
+	}
+	for _, test := range tests {
+		records, errors := readAll(NewClusterManagerSession(), test.packets)
+		streams := configStreams(records)
+		if errors != test.errors || len(streams) != 1 || len(httpRequests(records)) != test.requests {
+			t.Fatalf("%v: expected one stream, %v requests and %v errors, got %+v and %v errors", test.name,
+				test.requests, test.errors, records, errors)
+		}
+		stream := *streams[0]
+		stream.request = nil
+		if stream != test.expected {
+			t.Errorf("%v: expected %+v, got %+v", test.name, test.expected, stream)
+		}
+	}
+}
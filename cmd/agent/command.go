@@ -19,30 +19,48 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"log"
-	"time"
+	"math"
+	"strings"
 )
 
 type Command struct {
-	state              ParserState
-	commandType        CommandType
-	opcode             string
-	magic              uint8
-	opaque             uint32
-	keyLength          uint16
-	extrasLength       uint8
-	valueLength        uint32
-	cas                uint32
-	key                []byte
-	partial            []byte
-	captureTimeInNanos int64
+	state               ParserState
+	commandType         CommandType
+	opcode              string
+	opcodeByte          uint8
+	magic               uint8
+	opaque              uint32
+	framingExtrasLength uint8
+	keyLength           uint16
+	extrasLength        uint8
+	valueLength         uint32
+	bodyLength          uint32
+	valueSize           uint32
+	datatype            uint8
+	valueHead           []byte
+	subdoc              *subdocDecoder
+	value               []byte
+	keepValue           bool
+	vbucket             uint16
+	status              uint16
+	cas                 uint32
+	key                 []byte
+	extras              []byte
+	framingExtras       []byte
+	serverDuration      int64
+	pipelineDepth       int
+	partial             []byte
+	captureTimeInNanos  int64
 }
 
 type ParserState int
 
 const (
 	parseStateHeader ParserState = iota
+	parseStateFramingExtras
 	parseStateExtras
 	parseStateKey
 	parseStateValue
@@ -54,163 +72,288 @@ type CommandType int
 const (
 	REQUEST CommandType = iota
 	RESPONSE
+	// requests initiated by the server on a duplex connection, and the client's responses to them
+	SERVER_REQUEST
+	SERVER_RESPONSE
+)
+
+const (
+	MAGIC_REQUEST          = 0x80
+	MAGIC_RESPONSE         = 0x81
+	MAGIC_ALT_REQUEST      = 0x08
+	MAGIC_ALT_RESPONSE     = 0x18
+	MAGIC_SERVER_REQUEST   = 0x82
+	MAGIC_SERVER_RESPONSE  = 0x83
+	HEADER_LENGTH          = 24
+	FRAME_SERVER_DURATION  = 0x00
+	FRAME_ESCAPE           = 0x0f
+	SERVER_DURATION_LENGTH = 2
+)
+
+// Datatype flags, a value can be JSON, snappy compressed and carry extended attributes at once.
+const (
+	DATATYPE_JSON   = 0x01
+	DATATYPE_SNAPPY = 0x02
+	DATATYPE_XATTR  = 0x04
+	// a snappy block starts with its uncompressed length as a varint of at most 5 bytes
+	SNAPPY_LENGTH_MAX = 5
 )
 
 type Opcode string
 
 const (
-	GET     = "0"
-	SET     = "1"
-	IGNORED = "IGNORED"
+	GET           = "get"
+	SET           = "set"
+	ADD           = "add"
+	REPLACE       = "replace"
+	DELETE        = "delete"
+	INCREMENT     = "increment"
+	DECREMENT     = "decrement"
+	APPEND        = "append"
+	PREPEND       = "prepend"
+	TOUCH         = "touch"
+	GAT           = "gat"
+	GET_REPLICA   = "get_replica"
+	GET_LOCKED    = "get_locked"
+	UNLOCK        = "unlock"
+	SELECT_BUCKET = "select_bucket"
+	IGNORED       = "IGNORED"
 )
 
-func NewCommand() *Command {
+// Opcodes that are tracked, anything else is IGNORED. Quiet variants are left out as they only
+// get a response on failure and would never be paired.
+var opcodes = map[uint8]string{
+	0x00: GET,
+	0x01: SET,
+	0x02: ADD,
+	0x03: REPLACE,
+	0x04: DELETE,
+	0x05: INCREMENT,
+	0x06: DECREMENT,
+	0x0e: APPEND,
+	0x0f: PREPEND,
+	0x1c: TOUCH,
+	0x1d: GAT,
+	0x83: GET_REPLICA,
+	0x89: SELECT_BUCKET,
+	0x94: GET_LOCKED,
+	0x95: UNLOCK,
+	0xd0: SUBDOC_MULTI_LOOKUP,
+	0xd1: SUBDOC_MULTI_MUTATION,
+}
+
+var statuses = map[uint16]string{
+	0x00: "success",
+	0x01: "key_not_found",
+	0x02: "key_exists",
+	0x03: "too_big",
+	0x04: "invalid_arguments",
+	0x05: "not_stored",
+	0x06: "delta_bad_value",
+	0x07: "not_my_vbucket",
+	0x08: "no_bucket",
+	0x09: "locked",
+	0x20: "auth_error",
+	0x22: "range_error",
+	0x24: "no_access",
+	0x81: "unknown_command",
+	0x82: "no_memory",
+	0x83: "not_supported",
+	0x84: "internal_error",
+	0x85: "busy",
+	0x86: "temporary_failure",
+	0xc0: "subdoc_path_not_found",
+	0xc1: "subdoc_path_mismatch",
+	0xc2: "subdoc_path_invalid",
+	0xc3: "subdoc_path_too_big",
+	0xc4: "subdoc_doc_too_deep",
+	0xc5: "subdoc_value_cantinsert",
+	0xc6: "subdoc_doc_not_json",
+	0xc7: "subdoc_num_range",
+	0xc8: "subdoc_delta_range",
+	0xc9: "subdoc_path_exists",
+	0xca: "subdoc_value_too_deep",
+	0xcb: "subdoc_invalid_combo",
+	0xcc: "subdoc_multi_path_failure",
+	0xcd: "subdoc_success_deleted",
+	0xce: "subdoc_xattr_invalid_flag_combo",
+	0xcf: "subdoc_xattr_invalid_key_combo",
+	0xd0: "subdoc_xattr_unknown_macro",
+	0xd1: "subdoc_xattr_unknown_vattr",
+	0xd2: "subdoc_xattr_cant_modify_vattr",
+	0xd3: "subdoc_multi_path_failure_deleted",
+	0xd4: "subdoc_invalid_xattr_order",
+}
+
+func statusName(status uint16) string {
+	if name, ok := statuses[status]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", status)
+}
+
+// datatypeName lists the datatype flags that are set, raw when there are none.
+func datatypeName(datatype uint8) string {
+	var names []string
+	if datatype&DATATYPE_JSON != 0 {
+		names = append(names, "json")
+	}
+	if datatype&DATATYPE_SNAPPY != 0 {
+		names = append(names, "snappy")
+	}
+	if datatype&DATATYPE_XATTR != 0 {
+		names = append(names, "xattr")
+	}
+	if len(names) == 0 {
+		return "raw"
+	}
+	return strings.Join(names, ",")
+}
+
+func NewCommand(captureTimeInNanos int64) *Command {
 	return &Command{
 		state:              parseStateHeader,
-		captureTimeInNanos: time.Now().UnixNano(),
+		captureTimeInNanos: captureTimeInNanos,
 	}
 }
 
 func (c *Command) ReadNewPacketData(data *bytes.Buffer) error {
 	if c.state == parseStateHeader {
-		partialLen := len(c.partial)
-		if data.Len() < 24 && partialLen == 0 {
-			data.Read(c.partial)
-			return io.EOF
-		} else if data.Len() < 24 && partialLen > 0 {
-			if b, err := data.ReadByte(); err != nil {
-				if err == io.EOF {
-					return err
-				} else {
-					log.Fatalf("Failed parsing packet at partial %v", c.state, err)
-				}
-			} else {
-				c.partial = append(c.partial, b)
-			}
+		// a header split across packets is kept until the rest of it arrives
+		needed := HEADER_LENGTH - len(c.partial)
+		if data.Len() < needed {
+			c.partial = append(c.partial, data.Next(data.Len())...)
 			return io.EOF
 		}
 
 		var header bytes.Buffer
-
-		if len(c.partial) > 0 {
-			if n, err := header.Write(c.partial); err != nil {
-				log.Fatalf("Failed parsing packet at partial %v", c.state, err)
-			} else {
-				header.Write(data.Next(24 - n))
-			}
-		} else {
-			header.Write(data.Next(24))
-		}
+		header.Write(c.partial)
+		header.Write(data.Next(needed))
 
 		if magic, err := header.ReadByte(); err != nil {
-			log.Fatal("Failed parsing packet at magic %v", c.state, err)
+			log.Fatalf("Failed parsing packet at magic in state %v: %v", c.state, err)
 		} else {
 			c.magic = magic
-			if c.magic == 0x80 {
+			switch c.magic {
+			case MAGIC_REQUEST, MAGIC_ALT_REQUEST:
 				c.commandType = REQUEST
-			} else if c.magic == 0x81 {
+			case MAGIC_RESPONSE, MAGIC_ALT_RESPONSE:
 				c.commandType = RESPONSE
+			case MAGIC_SERVER_REQUEST:
+				c.commandType = SERVER_REQUEST
+			case MAGIC_SERVER_RESPONSE:
+				c.commandType = SERVER_RESPONSE
+			default:
+				return fmt.Errorf("Unknown magic 0x%02x", c.magic)
 			}
 		}
 
 		if opcode, err := header.ReadByte(); err != nil {
-			log.Fatal("Failed parsing packet opcode %v", err)
+			log.Fatalf("Failed parsing packet opcode %v", err)
 		} else {
-			if opcode == 0x0 {
-				c.opcode = GET
-			} else if opcode == 0x1 {
-				c.opcode = SET
+			c.opcodeByte = opcode
+			if c.isServerPush() {
+				// server requests have opcodes of their own
+				c.opcode = serverOpcodeName(opcode)
+			} else if name, ok := opcodes[opcode]; ok {
+				c.opcode = name
 			} else {
 				c.opcode = IGNORED
 			}
-
 		}
 
-		keyLenBytes := header.Next(2)
-		c.keyLength = binary.BigEndian.Uint16(keyLenBytes)
+		// the alternative encoding splits the key length to carry the length of the framing extras
+		if c.magic == MAGIC_ALT_REQUEST || c.magic == MAGIC_ALT_RESPONSE {
+			c.framingExtrasLength, _ = header.ReadByte()
+			keyLenByte, _ := header.ReadByte()
+			c.keyLength = uint16(keyLenByte)
+		} else {
+			keyLenBytes := header.Next(2)
+			c.keyLength = binary.BigEndian.Uint16(keyLenBytes)
+		}
 
 		extrasLenBytes, _ := header.ReadByte()
 		c.extrasLength = extrasLenBytes
 
-		header.Next(1) //datatype
-		header.Next(2) //vbucket or status
+		c.datatype, _ = header.ReadByte()
+		if c.commandType == RESPONSE || c.commandType == SERVER_RESPONSE {
+			c.status = binary.BigEndian.Uint16(header.Next(2))
+		} else {
+			c.vbucket = binary.BigEndian.Uint16(header.Next(2))
+		}
 
-		totalBodyLength := binary.BigEndian.Uint32(header.Next(4))
-		c.valueLength = totalBodyLength - uint32(c.keyLength) - uint32(c.extrasLength)
+		c.bodyLength = binary.BigEndian.Uint32(header.Next(4))
+		// a malformed frame, or data that is not at a frame boundary, would wait for gigabytes of value
+		lengths := uint32(c.keyLength) + uint32(c.extrasLength) + uint32(c.framingExtrasLength)
+		if c.bodyLength < lengths {
+			return fmt.Errorf("Body length %v is shorter than the key and extras %v", c.bodyLength, lengths)
+		}
+		c.valueLength = c.bodyLength - lengths
+		c.valueSize = c.valueLength
 
 		opaqueBytes := header.Next(4)
 		c.opaque = binary.BigEndian.Uint32(opaqueBytes)
 		header.Next(2) //cas
 
-		if data.Len() > 0 && c.extrasLength > 0 {
-			c.state = parseStateExtras
-		} else if data.Len() > 0 && c.extrasLength == 0 && c.keyLength > 0 {
-			c.state = parseStateKey
-		} else if data.Len() > 0 && c.extrasLength == 0 && c.valueLength > 0 {
-			c.state = parseStateValue
-		} else {
-			c.state = parseStateComplete
+		if c.opcode == SUBDOC_MULTI_LOOKUP || c.opcode == SUBDOC_MULTI_MUTATION {
+			c.subdoc = newSubdocDecoder(c.opcode, c.commandType, c.status)
 		}
+		// DCP control values are short settings and NOT_MY_VBUCKET responses carry the cluster map
+		// the client should have used, every other value is only looked at in passing
+		c.keepValue = (c.commandType == REQUEST && c.opcodeByte == DCP_CONTROL) ||
+			(c.commandType == RESPONSE && c.status == STATUS_NOT_MY_VBUCKET)
+		c.state = c.nextState(parseStateFramingExtras)
 		c.partial = nil
 
 	}
 
+	if c.state == parseStateFramingExtras {
+		needed := int(c.framingExtrasLength) - len(c.framingExtras)
+
+		if data.Len() >= needed {
+			c.framingExtras = append(c.framingExtras, data.Next(needed)...)
+			c.decodeFramingExtras()
+			c.state = c.nextState(parseStateExtras)
+		} else {
+			c.framingExtras = append(c.framingExtras, data.Next(data.Len())...)
+			return io.EOF
+		}
+	}
+
 	if c.state == parseStateExtras {
-		extrasLen := int(c.extrasLength)
-
-		if data.Len() >= extrasLen {
-			data.Next(extrasLen)
-			if c.keyLength > 0 {
-				c.state = parseStateKey
-			} else if c.valueLength > 0 {
-				c.state = parseStateValue
-			} else {
-				c.state = parseStateComplete
-			}
+		needed := int(c.extrasLength) - len(c.extras)
+
+		if data.Len() >= needed {
+			c.extras = append(c.extras, data.Next(needed)...)
+			c.state = c.nextState(parseStateKey)
 		} else {
-			available := data.Len()
-			data.Next(available)
-			c.extrasLength -= uint8(available)
+			c.extras = append(c.extras, data.Next(data.Len())...)
 			return io.EOF
 		}
 	}
 
 	if c.state == parseStateKey {
-		keyLen := int(c.keyLength)
+		needed := int(c.keyLength) - len(c.key)
 
-		if data.Len() >= keyLen {
-			if c.key == nil {
-				c.key = data.Next(keyLen)
-			} else {
-				for i := 0; i < keyLen; i++ {
-					byte, _ := data.ReadByte()
-					c.key = append(c.key, byte)
-				}
-			}
-			if c.valueLength > 0 {
-				c.state = parseStateValue
-			} else {
-				c.state = parseStateComplete
-			}
+		if data.Len() >= needed {
+			c.key = append(c.key, data.Next(needed)...)
+			c.state = c.nextState(parseStateValue)
 		} else {
-			available := data.Len()
-			data.Next(available)
-			c.keyLength -= uint16(available)
+			c.key = append(c.key, data.Next(data.Len())...)
 			return io.EOF
 		}
-
 	}
 
 	if c.state == parseStateValue {
 		valueLen := int(c.valueLength)
 
 		if data.Len() >= valueLen {
-			data.Next(valueLen)
+			c.readValue(data.Next(valueLen))
 			c.state = parseStateComplete
 
 		} else {
 			available := data.Len()
-			data.Next(available)
+			c.readValue(data.Next(available))
 			c.valueLength -= uint32(available)
 			return io.EOF
 		}
@@ -218,10 +361,96 @@ func (c *Command) ReadNewPacketData(data *bytes.Buffer) error {
 	return nil
 }
 
+// nextState skips the parts of the body that are empty, starting from state.
+func (c *Command) nextState(state ParserState) ParserState {
+	if state <= parseStateFramingExtras && c.framingExtrasLength > 0 {
+		return parseStateFramingExtras
+	}
+	if state <= parseStateExtras && c.extrasLength > 0 {
+		return parseStateExtras
+	}
+	if state <= parseStateKey && c.keyLength > 0 {
+		return parseStateKey
+	}
+	if state <= parseStateValue && c.valueLength > 0 {
+		return parseStateValue
+	}
+	return parseStateComplete
+}
+
+// decodeFramingExtras reads the frame infos of the alternative encoding. Each frame starts with
+// an id and a length nibble, a nibble of 15 is escaped and continues in the following byte.
+func (c *Command) decodeFramingExtras() {
+	frames := c.framingExtras
+	for i := 0; i < len(frames); {
+		id := int(frames[i] >> 4)
+		length := int(frames[i] & 0x0f)
+		i++
+		if id == FRAME_ESCAPE {
+			if i >= len(frames) {
+				return
+			}
+			id += int(frames[i])
+			i++
+		}
+		if length == FRAME_ESCAPE {
+			if i >= len(frames) {
+				return
+			}
+			length += int(frames[i])
+			i++
+		}
+		if i+length > len(frames) {
+			return
+		}
+		if c.commandType == RESPONSE && id == FRAME_SERVER_DURATION && length == SERVER_DURATION_LENGTH {
+			// the server encodes its duration in microseconds as (2 * duration) ^ (1 / 1.74)
+			encoded := binary.BigEndian.Uint16(frames[i:])
+			c.serverDuration = int64(math.Pow(float64(encoded), 1.74) / 2)
+		}
+		i += length
+	}
+}
+
+// readValue looks at the part of the value in this packet, only DCP settings, cluster maps, the
+// specs of multi-path operations and the start of snappy values are of interest.
+func (c *Command) readValue(value []byte) {
+	if c.keepValue {
+		c.value = append(c.value, value...)
+	}
+	if c.subdoc != nil && c.datatype&DATATYPE_SNAPPY == 0 {
+		c.subdoc.feed(value)
+	}
+	if c.datatype&DATATYPE_SNAPPY == 0 || len(c.valueHead) >= SNAPPY_LENGTH_MAX {
+		return
+	}
+	if needed := SNAPPY_LENGTH_MAX - len(c.valueHead); len(value) > needed {
+		value = value[:needed]
+	}
+	c.valueHead = append(c.valueHead, value...)
+}
+
+// uncompressedSize is the size of the value once inflated, the size on the wire when it is not
+// compressed and 0 when the snappy length can not be read.
+func (c *Command) uncompressedSize() uint32 {
+	if c.datatype&DATATYPE_SNAPPY == 0 {
+		return c.valueSize
+	}
+	size, n := binary.Uvarint(c.valueHead)
+	if n <= 0 || size > math.MaxUint32 {
+		return 0
+	}
+	return uint32(size)
+}
+
 func (c *Command) isComplete() bool {
 	return c.state == parseStateComplete
 }
 
 func (c *Command) isResponse() bool {
 	return c.commandType == RESPONSE
-}
\ No newline at end of file
+}
+
+func (c *Command) isServerPush() bool {
+	return c.commandType == SERVER_REQUEST || c.commandType == SERVER_RESPONSE
+}
@@ -0,0 +1,199 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// packet builds a frame with the regular encoding, magic telling requests from responses.
+func packet(magic, opcode uint8, key string, extras []byte, value []byte, opaque uint32, status uint16) []byte {
+	header := make([]byte, HEADER_LENGTH)
+	header[0] = magic
+	header[1] = opcode
+	binary.BigEndian.PutUint16(header[2:], uint16(len(key)))
+	header[4] = uint8(len(extras))
+	binary.BigEndian.PutUint16(header[6:], status)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(header[12:], opaque)
+	frame := append(header, extras...)
+	frame = append(frame, key...)
+	return append(frame, value...)
+}
+
+// altResponse builds a response with the alternative encoding, carrying framing extras.
+func altResponse(opcode uint8, framing []byte, value []byte, opaque uint32, status uint16) []byte {
+	header := make([]byte, HEADER_LENGTH)
+	header[0] = MAGIC_ALT_RESPONSE
+	header[1] = opcode
+	header[2] = uint8(len(framing))
+	binary.BigEndian.PutUint16(header[6:], status)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(framing)+len(value)))
+	binary.BigEndian.PutUint32(header[12:], opaque)
+	frame := append(header, framing...)
+	return append(frame, value...)
+}
+
+// packetData is one packet of a captured connection.
+type packetData struct {
+	data       []byte
+	fromClient bool
+	timestamp  int64
+}
+
+// readAll feeds the packets to the analyser in order and returns every record and parse error.
+func readAll(analyser Analyser, packets []packetData) ([]Record, int) {
+	var records []Record
+	errors := 0
+	for _, p := range packets {
+		read, err := analyser.Read(p.data, p.fromClient, p.timestamp)
+		if err != nil {
+			errors++
+		}
+		records = append(records, read...)
+	}
+	return records, errors
+}
+
+func operations(records []Record) []*Operation {
+	var operations []*Operation
+	for _, record := range records {
+		if operation, ok := record.(*Operation); ok {
+			operations = append(operations, operation)
+		}
+	}
+	return operations
+}
+
+func TestMemcachedFraming(t *testing.T) {
+	get := packet(MAGIC_REQUEST, 0x00, "k1", nil, nil, 1, 0)
+	hit := packet(MAGIC_RESPONSE, 0x00, "", []byte{0, 0, 0, 0}, []byte("value"), 1, 0)
+	// a body length shorter than the key and extras it claims to carry
+	short := packet(MAGIC_REQUEST, 0x00, "k1", make([]byte, 4), nil, 2, 0)
+	binary.BigEndian.PutUint32(short[8:], 3)
+
+	tests := []struct {
+		name       string
+		packets    []packetData
+		operations int
+		errors     int
+	}{
+		{"whole frames", []packetData{{get, true, 1000}, {hit, false, 3000}}, 1, 0},
+		{"header split across packets", []packetData{{get[:10], true, 1000}, {get[10:], true, 1000}, {hit, false, 3000}}, 1, 0},
+		{"body split across packets", []packetData{{get, true, 1000}, {hit[:26], false, 3000}, {hit[26:], false, 3000}}, 1, 0},
+		{"unknown magic", []packetData{{[]byte{0x42, 0, 0, 0}, true, 1000}}, 0, 0},
+		{"short body length resyncs", []packetData{{short, true, 1000}, {get, true, 1000}, {hit, false, 3000}}, 1, 1},
+	}
+	for _, test := range tests {
+		records, errors := readAll(NewMemcachedSession(newMetrics()), test.packets)
+		if ops := operations(records); len(ops) != test.operations || errors != test.errors {
+			t.Errorf("%v: expected %v operations and %v errors, got %v and %v", test.name, test.operations, test.errors, len(ops), errors)
+		}
+	}
+}
+
+func TestMemcachedOperation(t *testing.T) {
+	session := NewMemcachedSession(newMetrics())
+	records, _ := readAll(session, []packetData{
+		{packet(MAGIC_REQUEST, 0x89, "travel", nil, nil, 1, 0), true, 1000},
+		{packet(MAGIC_RESPONSE, 0x89, "", nil, nil, 1, 0), false, 2000},
+		{packet(MAGIC_REQUEST, 0x01, "k1", make([]byte, 8), []byte("hello"), 7, 0), true, 1000000},
+		{altResponse(0x01, []byte{0x02, 0x00, 100}, nil, 7, 0), false, 6000000},
+	})
+	ops := operations(records)
+	if len(ops) != 1 {
+		t.Fatalf("expected one operation, got %+v", records)
+	}
+	op := ops[0]
+	if op.Opcode != "set" || op.Key != "k1" || op.Bucket != "travel" || op.Latency != 5000 || op.ValueSize != 5 ||
+		op.Timestamp != 1000000 || op.ServerDuration == 0 || op.PipelineDepth != 1 {
+		t.Errorf("unexpected operation %+v", op)
+	}
+}
+
+func TestMemcachedDirectionsInterleaved(t *testing.T) {
+	set := packet(MAGIC_REQUEST, 0x01, "k1", make([]byte, 8), []byte("hello"), 3, 0)
+	push := packet(MAGIC_SERVER_REQUEST, 0x01, "", make([]byte, 4), []byte(`{"rev":1}`), 9, 0)
+	records, errors := readAll(NewMemcachedSession(newMetrics()), []packetData{
+		// the server pushes a new cluster map while the client is in the middle of a request
+		{set[:30], true, 1000},
+		{push, false, 1500},
+		{set[30:], true, 2000},
+		{packet(MAGIC_RESPONSE, 0x01, "", nil, nil, 3, 0), false, 4000},
+	})
+	ops := operations(records)
+	if errors != 0 || len(ops) != 1 || ops[0].Key != "k1" || ops[0].ValueSize != 5 {
+		t.Fatalf("expected the set once, got %+v and %v errors", ops, errors)
+	}
+	var pushes []*ServerPush
+	for _, record := range records {
+		if push, ok := record.(*ServerPush); ok {
+			pushes = append(pushes, push)
+		}
+	}
+	if len(pushes) != 1 || pushes[0].Requests != 1 {
+		t.Errorf("expected one pushed request, got %+v", pushes)
+	}
+}
+
+// withDatatype sets the datatype of a frame.
+func withDatatype(frame []byte, datatype uint8) []byte {
+	frame[5] = datatype
+	return frame
+}
+
+func TestMemcachedValueSizes(t *testing.T) {
+	compressed := make([]byte, 43)
+	binary.PutUvarint(compressed, 100000)
+	set := withDatatype(packet(MAGIC_REQUEST, 0x01, "doc", make([]byte, 8), compressed, 1, 0),
+		DATATYPE_SNAPPY|DATATYPE_JSON|DATATYPE_XATTR)
+	// the snappy length is cut between packets
+	cut := HEADER_LENGTH + 8 + 3 + 2
+	stored := packet(MAGIC_RESPONSE, 0x01, "", nil, nil, 1, 0)
+	get := packet(MAGIC_REQUEST, 0x00, "doc", nil, nil, 1, 0)
+	badLength := withDatatype(packet(MAGIC_RESPONSE, 0x00, "", make([]byte, 4), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 1, 0), DATATYPE_SNAPPY)
+
+	tests := []struct {
+		name             string
+		packets          []packetData
+		datatype         string
+		valueSize        uint32
+		uncompressedSize uint32
+	}{
+		{"compressed mutation", []packetData{{set[:cut], true, 1000}, {set[cut:], true, 1000}, {stored, false, 2000}},
+			"json,snappy,xattr", uint32(len(compressed)), 100000},
+		{"read", []packetData{{get, true, 1000},
+			{withDatatype(packet(MAGIC_RESPONSE, 0x00, "", make([]byte, 4), []byte(`{"a":1}`), 1, 0), DATATYPE_JSON), false, 2000}},
+			"json", 7, 7},
+		{"miss", []packetData{{get, true, 1000}, {packet(MAGIC_RESPONSE, 0x00, "", nil, nil, 1, 1), false, 2000}},
+			"raw", 0, 0},
+		{"unreadable snappy length", []packetData{{get, true, 1000}, {badLength, false, 2000}},
+			"snappy", 6, 0},
+	}
+	for _, test := range tests {
+		records, _ := readAll(NewMemcachedSession(newMetrics()), test.packets)
+		ops := operations(records)
+		if len(ops) != 1 {
+			t.Fatalf("%v: expected one operation, got %+v", test.name, records)
+		}
+		if op := ops[0]; op.Datatype != test.datatype || op.ValueSize != test.valueSize || op.UncompressedSize != test.uncompressedSize {
+			t.Errorf("%v: expected %v %v %v, got %v %v %v", test.name, test.datatype, test.valueSize, test.uncompressedSize,
+				op.Datatype, op.ValueSize, op.UncompressedSize)
+		}
+	}
+}
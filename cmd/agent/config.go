@@ -16,10 +16,23 @@
 
 package main
 
+import (
+	"../../tlsconfig"
+)
+
 type Config struct {
-	Port            int             `yaml:"port"`
-	InterfaceConfig InterfaceConfig `yaml:"interface"`
-	logging         LoggingConfig   `yaml:"log"`
+	Port            int              `yaml:"port"`
+	InterfaceConfig InterfaceConfig  `yaml:"interface"`
+	Coordinator     string           `yaml:"coordinator"`
+	Advertise       string           `yaml:"advertise"`
+	Admin           string           `yaml:"admin"`
+	SlowOps         SlowOpsConfig    `yaml:"slowops"`
+	HotKeys         HotKeysConfig    `yaml:"hotkeys"`
+	Keys            KeysConfig       `yaml:"keys"`
+	Statements      StatementsConfig `yaml:"statements"`
+	TLS             tlsconfig.Config `yaml:"tls"`
+	Auth            AuthConfig       `yaml:"auth"`
+	logging         LoggingConfig    `yaml:"log"`
 }
 
 type InterfaceConfig struct {
@@ -27,6 +40,87 @@ type InterfaceConfig struct {
 	CaptureType            string `yaml:"type"`
 	AfPacketTragetSizeInMB int    `yaml:"targetsize"`
 	Port                   int    `yaml:"port"`
+	TLSPort                int    `yaml:"tlsport"`
+	KeyLog                 string `yaml:"keylog"`
+	// TextPorts speak the ASCII memcached protocol, mapped to the bucket they serve
+	TextPorts map[int]string `yaml:"textports"`
+	// HTTPPorts are those of the query, search, views and analytics services, mapped to the
+	// service they serve
+	HTTPPorts map[int]string `yaml:"httpports"`
+	// ClusterManagerPort is followed for bootstrap and streaming config requests when set
+	ClusterManagerPort int `yaml:"clustermanagerport"`
+	// Analysers enables an analyser on any other port, or overrides the one of a port above
+	Analysers map[int]AnalyserConfig `yaml:"analysers"`
+}
+
+// AnalyserConfig is the analyser of a port: memcached, memcached_text with the bucket as Option,
+// http with the service as Option, cluster_manager, or auto to detect the protocol of each
+// connection. With TLS the connections are decrypted before they are analysed.
+type AnalyserConfig struct {
+	Analyser string `yaml:"analyser"`
+	Option   string `yaml:"option"`
+	TLS      bool   `yaml:"tls"`
+}
+
+// SlowOpsConfig sets the latency in microseconds from which an operation is recorded with its
+// full context, per opcode with Threshold as the default. A threshold of 0 records nothing.
+type SlowOpsConfig struct {
+	Threshold int64            `yaml:"threshold"`
+	Opcodes   map[string]int64 `yaml:"opcodes"`
+}
+
+func (s *SlowOpsConfig) isSlow(opcode string, latency int64) bool {
+	threshold, ok := s.Opcodes[opcode]
+	if !ok {
+		threshold = s.Threshold
+	}
+	return threshold > 0 && latency >= threshold
+}
+
+// HotKeysConfig sizes the sketches behind hot key detection, a wider and deeper sketch
+// overestimates less at the cost of memory.
+type HotKeysConfig struct {
+	TopK  int `yaml:"topk"`
+	Width int `yaml:"width"`
+	Depth int `yaml:"depth"`
+}
+
+// KeysConfig sets how keys are reported: plain, redact, hash with an HMAC keyed by Secret, or
+// prefix:N to keep the first N bytes, fewer when the cut would split a character.
+type KeysConfig struct {
+	Policy string `yaml:"policy"`
+	Secret string `yaml:"secret"`
+}
+
+// StatementsConfig sets how N1QL and analytics statements are reported: plain, literals to replace
+// their strings and numbers with ?, or redact to drop them along with their client_context_id.
+// Statements are cut at MaxLength bytes, on a character boundary.
+type StatementsConfig struct {
+	Policy    string `yaml:"policy"`
+	MaxLength int    `yaml:"maxlength"`
+}
+
+// AuthConfig restricts who may call the agent: callers must send Token when it is set, and present
+// a client certificate issued to one of Coordinators when the allow-list is set. The agent sends
+// Token to the coordinator when it registers.
+type AuthConfig struct {
+	Token        string   `yaml:"token"`
+	Coordinators []string `yaml:"coordinators"`
+}
+
+func (config *Config) setDefaults() {
+	if config.HotKeys.TopK == 0 {
+		config.HotKeys.TopK = 20
+	}
+	if config.HotKeys.Width == 0 {
+		config.HotKeys.Width = 2048
+	}
+	if config.HotKeys.Depth == 0 {
+		config.HotKeys.Depth = 4
+	}
+	if config.Statements.MaxLength == 0 {
+		config.Statements.MaxLength = 1024
+	}
 }
 
 const (
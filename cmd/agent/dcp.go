@@ -0,0 +1,315 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	pb "../../rpc"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+const (
+	DCP_OPEN                   = 0x50
+	DCP_STREAM_REQ             = 0x53
+	DCP_STREAM_END             = 0x55
+	DCP_SNAPSHOT_MARKER        = 0x56
+	DCP_MUTATION               = 0x57
+	DCP_DELETION               = 0x58
+	DCP_EXPIRATION             = 0x59
+	DCP_SET_VBUCKET_STATE      = 0x5b
+	DCP_NOOP                   = 0x5c
+	DCP_BUFFER_ACKNOWLEDGEMENT = 0x5d
+	DCP_CONTROL                = 0x5e
+	DCP_SYSTEM_EVENT           = 0x5f
+	DCP_PREPARE                = 0x60
+	DCP_COMMIT                 = 0x62
+	DCP_ABORT                  = 0x63
+	DCP_SEQNO_ADVANCED         = 0x64
+	DCP_OSO_SNAPSHOT           = 0x65
+	DCP_OPEN_PRODUCER          = 0x01
+	// the control key a consumer sets its flow-control buffer with
+	DCP_CONNECTION_BUFFER_SIZE = "connection_buffer_size"
+)
+
+// Messages from the producer that count towards the flow-control buffer until acknowledged.
+var dcpFlowControlled = map[uint8]bool{
+	DCP_STREAM_END:        true,
+	DCP_SNAPSHOT_MARKER:   true,
+	DCP_MUTATION:          true,
+	DCP_DELETION:          true,
+	DCP_EXPIRATION:        true,
+	DCP_SET_VBUCKET_STATE: true,
+	DCP_SYSTEM_EVENT:      true,
+	DCP_PREPARE:           true,
+	DCP_COMMIT:            true,
+	DCP_ABORT:             true,
+	DCP_SEQNO_ADVANCED:    true,
+	DCP_OSO_SNAPSHOT:      true,
+}
+
+var dcpStreamEndReasons = map[uint32]string{
+	0: "ok",
+	1: "closed",
+	2: "state_changed",
+	3: "disconnected",
+	4: "too_slow",
+	5: "backfill_failed",
+	6: "rollback",
+	7: "filter_empty",
+	8: "lost_privileges",
+}
+
+func isDCP(opcode uint8) bool {
+	return opcode >= DCP_OPEN && opcode <= DCP_OSO_SNAPSHOT
+}
+
+// DCPStream is one vbucket stream of a DCP connection, identified by the opaque of its stream
+// request. Timestamps are in nanoseconds.
+type DCPStream struct {
+	Opaque           uint32
+	Vbucket          uint16
+	Status           string
+	StartSeqno       uint64
+	EndSeqno         uint64
+	LastSeqno        uint64
+	Mutations        uint64
+	Deletions        uint64
+	Expirations      uint64
+	Bytes            uint64
+	First            int64
+	Last             int64
+	Snapshots        uint64
+	MaxSnapshotItems uint64
+	MaxSnapshotBytes uint64
+	End              string
+	snapshotItems    uint64
+	snapshotBytes    uint64
+}
+
+// DCPConnection follows the streams of a DCP connection and how full the producer's view of the
+// consumer's flow-control buffer gets. Without the control message setting the buffer size, which
+// only goes by when the connection opens, stalls can not be told apart from a quiet connection.
+type DCPConnection struct {
+	Name       string
+	Producer   bool
+	BufferSize uint32
+	MaxUnacked uint64
+	Acked      uint64
+	Stalls     uint32
+	// StallTime and MaxStall are in microseconds, a stall lasts while the buffer is full
+	StallTime int64
+	MaxStall  int64
+	unacked   uint64
+	stalledAt int64
+	lastSeen  int64
+	streams   map[uint32]*DCPStream
+	order     []*DCPStream
+}
+
+func NewDCPConnection() *DCPConnection {
+	return &DCPConnection{streams: make(map[uint32]*DCPStream)}
+}
+
+// stream returns the stream of opaque, streams requested before the capture started are picked
+// up from their first message.
+func (d *DCPConnection) stream(opaque uint32, vbucket uint16) *DCPStream {
+	stream, ok := d.streams[opaque]
+	if !ok {
+		stream = &DCPStream{Opaque: opaque, Vbucket: vbucket}
+		d.streams[opaque] = stream
+		d.order = append(d.order, stream)
+	}
+	return stream
+}
+
+func (d *DCPConnection) Handle(c *Command) {
+	d.lastSeen = c.captureTimeInNanos
+	if c.isResponse() {
+		if c.opcodeByte == DCP_STREAM_REQ {
+			if stream, ok := d.streams[c.opaque]; ok {
+				stream.Status = statusName(c.status)
+			}
+		}
+		return
+	}
+
+	switch c.opcodeByte {
+	case DCP_OPEN:
+		d.Name = string(c.key)
+		if len(c.extras) >= 8 {
+			d.Producer = binary.BigEndian.Uint32(c.extras[4:])&DCP_OPEN_PRODUCER != 0
+		}
+	case DCP_CONTROL:
+		if string(c.key) == DCP_CONNECTION_BUFFER_SIZE {
+			if size, err := strconv.ParseUint(string(c.value), 10, 32); err == nil {
+				d.BufferSize = uint32(size)
+			}
+		}
+	case DCP_BUFFER_ACKNOWLEDGEMENT:
+		if len(c.extras) >= 4 {
+			d.acknowledge(uint64(binary.BigEndian.Uint32(c.extras)), c.captureTimeInNanos)
+		}
+	case DCP_STREAM_REQ:
+		stream := d.stream(c.opaque, c.vbucket)
+		if len(c.extras) >= 24 {
+			stream.StartSeqno = binary.BigEndian.Uint64(c.extras[8:])
+			stream.EndSeqno = binary.BigEndian.Uint64(c.extras[16:])
+		}
+	}
+
+	if dcpFlowControlled[c.opcodeByte] {
+		d.produced(c)
+	}
+}
+
+// produced accounts for a message of a stream sent by the producer.
+func (d *DCPConnection) produced(c *Command) {
+	size := uint64(HEADER_LENGTH + c.bodyLength)
+	stream := d.stream(c.opaque, c.vbucket)
+	if stream.First == 0 {
+		stream.First = c.captureTimeInNanos
+	}
+	stream.Last = c.captureTimeInNanos
+	stream.Bytes += size
+
+	switch c.opcodeByte {
+	case DCP_SNAPSHOT_MARKER:
+		stream.endSnapshot()
+		stream.Snapshots++
+	case DCP_MUTATION, DCP_DELETION, DCP_EXPIRATION:
+		switch c.opcodeByte {
+		case DCP_MUTATION:
+			stream.Mutations++
+		case DCP_DELETION:
+			stream.Deletions++
+		default:
+			stream.Expirations++
+		}
+		stream.snapshotItems++
+		stream.snapshotBytes += size
+		if len(c.extras) >= 8 {
+			stream.LastSeqno = binary.BigEndian.Uint64(c.extras)
+		}
+	case DCP_STREAM_END:
+		stream.endSnapshot()
+		stream.End = "unknown"
+		if len(c.extras) >= 4 {
+			stream.End = dcpStreamEndReason(binary.BigEndian.Uint32(c.extras))
+		}
+	}
+
+	d.unacked += size
+	if d.unacked > d.MaxUnacked {
+		d.MaxUnacked = d.unacked
+	}
+	if d.BufferSize > 0 && d.unacked >= uint64(d.BufferSize) && d.stalledAt == 0 {
+		d.stalledAt = c.captureTimeInNanos
+		d.Stalls++
+	}
+}
+
+func (d *DCPConnection) acknowledge(bytes uint64, timestamp int64) {
+	d.Acked += bytes
+	// acknowledgements for messages sent before the capture started
+	if bytes > d.unacked {
+		bytes = d.unacked
+	}
+	d.unacked -= bytes
+	if d.stalledAt != 0 && d.unacked < uint64(d.BufferSize) {
+		d.endStall(timestamp)
+	}
+}
+
+func (d *DCPConnection) endStall(timestamp int64) {
+	stall := (timestamp - d.stalledAt) / 1000
+	d.StallTime += stall
+	if stall > d.MaxStall {
+		d.MaxStall = stall
+	}
+	d.stalledAt = 0
+}
+
+func (s *DCPStream) endSnapshot() {
+	if s.snapshotItems > s.MaxSnapshotItems {
+		s.MaxSnapshotItems = s.snapshotItems
+	}
+	if s.snapshotBytes > s.MaxSnapshotBytes {
+		s.MaxSnapshotBytes = s.snapshotBytes
+	}
+	s.snapshotItems = 0
+	s.snapshotBytes = 0
+}
+
+func dcpStreamEndReason(flags uint32) string {
+	if reason, ok := dcpStreamEndReasons[flags]; ok {
+		return reason
+	}
+	return fmt.Sprintf("0x%02x", flags)
+}
+
+func (d *DCPConnection) report(results *Results) {
+	stats, streams := d.Stats()
+	connection := &pb.AgentResultsResponse_DcpConnection{
+		Client:     results.stream.client,
+		Server:     results.stream.server,
+		Name:       stats.Name,
+		Producer:   stats.Producer,
+		BufferSize: stats.BufferSize,
+		MaxUnacked: stats.MaxUnacked,
+		Acked:      stats.Acked,
+		Stalls:     stats.Stalls,
+		StallTime:  stats.StallTime,
+		MaxStall:   stats.MaxStall,
+	}
+	for _, stream := range streams {
+		connection.Streams = append(connection.Streams, &pb.AgentResultsResponse_DcpStream{
+			Opaque:           stream.Opaque,
+			Vbucket:          uint32(stream.Vbucket),
+			Status:           stream.Status,
+			StartSeqno:       stream.StartSeqno,
+			EndSeqno:         stream.EndSeqno,
+			LastSeqno:        stream.LastSeqno,
+			Mutations:        stream.Mutations,
+			Deletions:        stream.Deletions,
+			Expirations:      stream.Expirations,
+			Bytes:            stream.Bytes,
+			Duration:         (stream.Last - stream.First) / 1000,
+			Snapshots:        stream.Snapshots,
+			MaxSnapshotItems: stream.MaxSnapshotItems,
+			MaxSnapshotBytes: stream.MaxSnapshotBytes,
+			End:              stream.End,
+		})
+	}
+	results.DcpConnections = append(results.DcpConnections, connection)
+}
+
+// Stats returns the connection and its streams as of now, a stall still going on counts up to the
+// last message seen and the snapshot in progress counts as a whole one.
+func (d *DCPConnection) Stats() (*DCPConnection, []*DCPStream) {
+	stats := *d
+	if stats.stalledAt != 0 {
+		stats.endStall(d.lastSeen)
+	}
+	var streams []*DCPStream
+	for _, stream := range d.order {
+		copied := *stream
+		copied.endSnapshot()
+		streams = append(streams, &copied)
+	}
+	return &stats, streams
+}
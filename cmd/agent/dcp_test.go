@@ -0,0 +1,120 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// dcpMessage builds a DCP request, which carries its vbucket where other requests are reserved.
+func dcpMessage(opcode uint8, vbucket uint16, key string, extras []byte, value []byte, opaque uint32) []byte {
+	frame := packet(MAGIC_REQUEST, opcode, key, extras, value, opaque, 0)
+	binary.BigEndian.PutUint16(frame[6:], vbucket)
+	return frame
+}
+
+func uint64s(values ...uint64) []byte {
+	b := make([]byte, 8*len(values))
+	for i, value := range values {
+		binary.BigEndian.PutUint64(b[8*i:], value)
+	}
+	return b
+}
+
+func dcpConnections(records []Record) []*DCPConnection {
+	var connections []*DCPConnection
+	for _, record := range records {
+		if connection, ok := record.(*DCPConnection); ok {
+			connections = append(connections, connection)
+		}
+	}
+	return connections
+}
+
+const dcpStart = int64(1000000000)
+
+// dcpOpen opens a producer with a flow-control buffer and requests a stream of vbucket 7 from
+// seqno 10 on opaque 42.
+func dcpOpen() []packetData {
+	return []packetData{
+		{dcpMessage(DCP_OPEN, 0, "replication:a->b:default", []byte{0, 0, 0, 0, 0, 0, 0, DCP_OPEN_PRODUCER}, nil, 1), true, dcpStart},
+		{dcpMessage(DCP_CONTROL, 0, DCP_CONNECTION_BUFFER_SIZE, nil, []byte("300"), 2), true, dcpStart},
+		{dcpMessage(DCP_STREAM_REQ, 7, "", append(make([]byte, 8), uint64s(10, 1000, 0, 0, 0)...), nil, 42), true, dcpStart},
+		{packet(MAGIC_RESPONSE, DCP_STREAM_REQ, "", nil, uint64s(1, 0), 42, 0), false, dcpStart},
+	}
+}
+
+// dcpSnapshot is a snapshot of three mutations and a deletion, sent as one segment.
+func dcpSnapshot() []byte {
+	segment := dcpMessage(DCP_SNAPSHOT_MARKER, 7, "", append(uint64s(11, 14), 0, 0, 0, 1), nil, 42)
+	for seqno := uint64(11); seqno <= 13; seqno++ {
+		segment = append(segment, dcpMessage(DCP_MUTATION, 7, "k", append(uint64s(seqno, 1), make([]byte, 15)...), make([]byte, 20), 42)...)
+	}
+	return append(segment, dcpMessage(DCP_DELETION, 7, "k", uint64s(14, 1, 0)[:18], nil, 42)...)
+}
+
+func TestDCPStream(t *testing.T) {
+	snapshot := dcpSnapshot()
+	packets := append(dcpOpen(),
+		// the segment is cut in the middle of the deletion's header
+		packetData{snapshot[:len(snapshot)-30], false, dcpStart + 1000000},
+		packetData{snapshot[len(snapshot)-30:], false, dcpStart + 2000000},
+		packetData{dcpMessage(DCP_STREAM_END, 7, "", []byte{0, 0, 0, 0}, nil, 42), false, dcpStart + 3000000},
+		// a get on the same connection is still an operation
+		packetData{packet(MAGIC_REQUEST, 0x00, "k1", nil, nil, 5, 0), true, dcpStart},
+		packetData{packet(MAGIC_RESPONSE, 0x00, "", make([]byte, 4), []byte("v"), 5, 0), false, dcpStart + 1000},
+	)
+	session := NewMemcachedSession(newMetrics())
+	records, errors := readAll(session, packets)
+	connections := dcpConnections(records)
+	if errors != 0 || len(connections) != 1 || len(operations(records)) != 1 || len(session.currentRequests) != 0 {
+		t.Fatalf("expected one connection and one operation, got %+v and %v errors", records, errors)
+	}
+	connection, streams := connections[0].Stats()
+	if !connection.Producer || connection.Name != "replication:a->b:default" || len(streams) != 1 {
+		t.Fatalf("unexpected connection %+v with %v streams", connection, len(streams))
+	}
+	stream := streams[0]
+	if stream.Vbucket != 7 || stream.Status != "success" || stream.StartSeqno != 10 || stream.EndSeqno != 1000 ||
+		stream.LastSeqno != 14 || stream.Mutations != 3 || stream.Deletions != 1 || stream.Snapshots != 1 ||
+		stream.MaxSnapshotItems != 4 || stream.End != "ok" || stream.Last-stream.First != 2000000 {
+		t.Errorf("unexpected stream %+v", stream)
+	}
+}
+
+func TestDCPFlowControl(t *testing.T) {
+	snapshot := dcpSnapshot()
+	packets := append(dcpOpen(),
+		packetData{snapshot, false, dcpStart + 1000000},
+		// a noop is not flow controlled, and the consumer's response to it is not an operation
+		packetData{dcpMessage(DCP_NOOP, 0, "", nil, nil, 99), false, dcpStart + 2000000},
+		packetData{packet(MAGIC_RESPONSE, DCP_NOOP, "", nil, nil, 99, 0), true, dcpStart + 2000000},
+		// the consumer frees its buffer 6ms after it filled up
+		packetData{dcpMessage(DCP_BUFFER_ACKNOWLEDGEMENT, 0, "", []byte{0, 0, 1, 0}, nil, 3), true, dcpStart + 7000000},
+	)
+	records, _ := readAll(NewMemcachedSession(newMetrics()), packets)
+	connections := dcpConnections(records)
+	if len(connections) != 1 || len(operations(records)) != 0 {
+		t.Fatalf("expected only a DCP connection, got %+v", records)
+	}
+	connection, _ := connections[0].Stats()
+	if connection.BufferSize != 300 || connection.MaxUnacked != uint64(len(snapshot)) || connection.Acked != 256 ||
+		connection.Stalls != 1 || connection.StallTime != 6000 || connection.MaxStall != 6000 {
+		t.Errorf("unexpected flow control %+v", connection)
+	}
+}
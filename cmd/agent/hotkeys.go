@@ -0,0 +1,209 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+// CountMinSketch estimates per key totals in fixed memory. Estimates never undercount, they
+// overcount by at most the collisions in the least collided row.
+type CountMinSketch struct {
+	width  uint64
+	counts [][]uint64
+}
+
+func NewCountMinSketch(width int, depth int) *CountMinSketch {
+	counts := make([][]uint64, depth)
+	for i := range counts {
+		counts[i] = make([]uint64, width)
+	}
+	return &CountMinSketch{width: uint64(width), counts: counts}
+}
+
+// Add adds n to key and returns the new estimate for key.
+func (s *CountMinSketch) Add(key string, n uint64) uint64 {
+	var estimate uint64
+	for row, counts := range s.counts {
+		column := s.column(key, row)
+		counts[column] += n
+		if row == 0 || counts[column] < estimate {
+			estimate = counts[column]
+		}
+	}
+	return estimate
+}
+
+func (s *CountMinSketch) Estimate(key string) uint64 {
+	var estimate uint64
+	for row, counts := range s.counts {
+		count := counts[s.column(key, row)]
+		if row == 0 || count < estimate {
+			estimate = count
+		}
+	}
+	return estimate
+}
+
+// column hashes key with the row as seed so that every row spreads keys differently.
+func (s *CountMinSketch) column(key string, row int) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte{byte(row)})
+	hash.Write([]byte(key))
+	return hash.Sum64() % s.width
+}
+
+type topKEntry struct {
+	key   string
+	value uint64
+	index int
+}
+
+// topKHeap is a min heap so the smallest of the current top K is the one to evict.
+type topKHeap []*topKEntry
+
+func (h topKHeap) Len() int           { return len(h) }
+func (h topKHeap) Less(i, j int) bool { return h[i].value < h[j].value }
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topKHeap) Push(x interface{}) {
+	entry := x.(*topKEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// TopK keeps the k keys with the largest estimates seen so far.
+type TopK struct {
+	k       int
+	heap    topKHeap
+	entries map[string]*topKEntry
+}
+
+func NewTopK(k int) *TopK {
+	return &TopK{k: k, entries: make(map[string]*topKEntry)}
+}
+
+func (t *TopK) Offer(key string, value uint64) {
+	if entry, ok := t.entries[key]; ok {
+		entry.value = value
+		heap.Fix(&t.heap, entry.index)
+		return
+	}
+	if len(t.heap) < t.k {
+		entry := &topKEntry{key: key, value: value}
+		heap.Push(&t.heap, entry)
+		t.entries[key] = entry
+		return
+	}
+	if t.k > 0 && value > t.heap[0].value {
+		evicted := t.heap[0]
+		delete(t.entries, evicted.key)
+		evicted.key = key
+		evicted.value = value
+		t.entries[key] = evicted
+		heap.Fix(&t.heap, 0)
+	}
+}
+
+// Keys returns the tracked keys, largest first.
+func (t *TopK) Keys() []string {
+	entries := make([]*topKEntry, len(t.heap))
+	copy(entries, t.heap)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].value > entries[j].value
+	})
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.key
+	}
+	return keys
+}
+
+// HotKeys tracks the most frequent keys and the keys with the most cumulative latency over one
+// capture window.
+type HotKeys struct {
+	counts     *CountMinSketch
+	latencies  *CountMinSketch
+	topCount   *TopK
+	topLatency *TopK
+}
+
+type HotKey struct {
+	Bucket  string
+	Key     string
+	Count   uint64
+	Latency uint64
+}
+
+func NewHotKeys(config *HotKeysConfig) *HotKeys {
+	return &HotKeys{
+		counts:     NewCountMinSketch(config.Width, config.Depth),
+		latencies:  NewCountMinSketch(config.Width, config.Depth),
+		topCount:   NewTopK(config.TopK),
+		topLatency: NewTopK(config.TopK),
+	}
+}
+
+// hotKeyId keeps keys of different buckets apart, a key can not contain a NUL.
+func hotKeyId(bucket string, key string) string {
+	return bucket + "\x00" + key
+}
+
+func (h *HotKeys) Track(bucket string, key string, latency int64) {
+	if key == "" {
+		return
+	}
+	id := hotKeyId(bucket, key)
+	h.topCount.Offer(id, h.counts.Add(id, 1))
+	if latency > 0 {
+		h.topLatency.Offer(id, h.latencies.Add(id, uint64(latency)))
+	}
+}
+
+// Top returns the union of the most frequent keys and the keys with the most latency.
+func (h *HotKeys) Top() []HotKey {
+	seen := make(map[string]bool)
+	var hotKeys []HotKey
+	for _, id := range append(h.topCount.Keys(), h.topLatency.Keys()...) {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		parts := strings.SplitN(id, "\x00", 2)
+		hotKeys = append(hotKeys, HotKey{
+			Bucket:  parts[0],
+			Key:     parts[1],
+			Count:   h.counts.Estimate(id),
+			Latency: h.latencies.Estimate(id),
+		})
+	}
+	return hotKeys
+}
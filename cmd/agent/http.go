@@ -0,0 +1,482 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	pb "../../rpc"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	MAX_HTTP_LINE = 8192
+	// query bodies are kept for their statement up to this size
+	MAX_HTTP_BODY    = 64 * 1024
+	HTTP_TAIL_LENGTH = 4
+)
+
+const (
+	httpStateStart = iota
+	httpStateHeaders
+	httpStateBody
+	httpStateChunkSize
+	httpStateChunkData
+	httpStateChunkEnd
+	httpStateTrailers
+	httpStateUntilClose
+)
+
+var errHTTPLineTooLong = errors.New("http line too long")
+var errHTTPStartLine = errors.New("not an http request or status line")
+
+// Methods recognised when detecting HTTP on a port left to auto detection.
+var httpMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"DELETE":  true,
+	"OPTIONS": true,
+	"PATCH":   true,
+}
+
+// Path templates of the services' REST APIs, segments in braces stand for any name. Paths that
+// match none keep their names and only lose their ids.
+var httpPathTemplates = [][]string{
+	strings.Split("/{bucket}/_design/{ddoc}/_view/{view}", "/"),
+	strings.Split("/{bucket}/_design/{ddoc}/_spatial/{view}", "/"),
+	strings.Split("/{bucket}/_design/{ddoc}", "/"),
+	strings.Split("/api/index/{index}/query", "/"),
+	strings.Split("/api/index/{index}/count", "/"),
+	strings.Split("/api/index/{index}", "/"),
+	strings.Split("/api/bucket/{bucket}/scope/{scope}/index/{index}/query", "/"),
+	strings.Split("/api/bucket/{bucket}/scope/{scope}/index/{index}", "/"),
+	strings.Split("/pools/default/b/{bucket}", "/"),
+	strings.Split("/pools/default/bs/{bucket}", "/"),
+	strings.Split("/pools/default/buckets/{bucket}", "/"),
+	strings.Split("/pools/default/bucketsStreaming/{bucket}", "/"),
+	strings.Split("/pools/default/buckets/{bucket}/stats", "/"),
+	strings.Split("/pools/default/buckets/{bucket}/scopes", "/"),
+}
+
+// HTTPRequest is a request to one of the HTTP services paired with its response, started at
+// Timestamp in nanoseconds. Latency runs to the end of the response and FirstByte to its headers,
+// both in microseconds. Sizes are those of the bodies. A response without a length or chunks
+// ends with the connection, which the agent does not see, so it is paired at its headers: its
+// Latency is its FirstByte and its ResponseSize is 0.
+type HTTPRequest struct {
+	Timestamp       int64
+	Service         string
+	Method          string
+	Path            string
+	Status          int
+	Latency         int64
+	FirstByte       int64
+	RequestSize     int64
+	ResponseSize    int64
+	Statement       string
+	ClientContextId string
+}
+
+// report applies the statement policy, statements and client_context_ids never leave the agent as
+// they were captured unless the policy says so.
+func (request *HTTPRequest) report(results *Results) {
+	policy := results.agent.statementPolicy
+	results.HttpRequests = append(results.HttpRequests, &pb.AgentResultsResponse_HttpRequest{
+		Timestamp:       request.Timestamp,
+		Client:          results.stream.client,
+		Server:          results.stream.server,
+		Service:         request.Service,
+		Method:          request.Method,
+		Path:            request.Path,
+		Status:          int32(request.Status),
+		Latency:         request.Latency,
+		FirstByte:       request.FirstByte,
+		RequestSize:     request.RequestSize,
+		ResponseSize:    request.ResponseSize,
+		Statement:       policy.Apply(request.Statement),
+		ClientContextId: policy.ClientContextId(request.ClientContextId),
+	})
+}
+
+type httpMessage struct {
+	method  string
+	target  string
+	status  int
+	chunked bool
+	length  int64
+	start   int64
+	headers int64
+	size    int64
+	body    []byte
+	// truncated bodies went over MAX_HTTP_BODY, none of them is kept
+	truncated bool
+	// tail is the last bytes of a response body, where streamed configs end with a separator
+	tail []byte
+	// resumed requests were sent before the capture, their response was joined at a chunk
+	resumed bool
+}
+
+// httpReader splits one direction of a connection into messages.
+type httpReader struct {
+	request   bool
+	state     int
+	line      []byte
+	remaining int64
+	message   *httpMessage
+}
+
+// nextLine returns the next complete line without its \r\n, false when the data ends first.
+func (r *httpReader) nextLine(data *bytes.Buffer) ([]byte, bool, error) {
+	for data.Len() > 0 {
+		b, _ := data.ReadByte()
+		if b == '\n' {
+			line := bytes.TrimSuffix(r.line, []byte("\r"))
+			r.line = nil
+			return line, true, nil
+		}
+		if len(r.line) >= MAX_HTTP_LINE {
+			r.line = nil
+			return nil, false, errHTTPLineTooLong
+		}
+		r.line = append(r.line, b)
+	}
+	return nil, false, nil
+}
+
+// body reads up to the remaining bytes of the body or chunk, keeping those of requests.
+func (r *httpReader) body(data *bytes.Buffer) {
+	chunk := data.Next(int(r.remaining))
+	r.remaining -= int64(len(chunk))
+	r.message.size += int64(len(chunk))
+	if r.request && !r.message.truncated {
+		if len(r.message.body)+len(chunk) <= MAX_HTTP_BODY {
+			r.message.body = append(r.message.body, chunk...)
+		} else {
+			// later chunks would leave a hole where this one was
+			r.message.truncated = true
+			r.message.body = nil
+		}
+	}
+	if !r.request {
+		r.message.tail = append(r.message.tail, chunk...)
+		if len(r.message.tail) > HTTP_TAIL_LENGTH {
+			r.message.tail = r.message.tail[len(r.message.tail)-HTTP_TAIL_LENGTH:]
+		}
+	}
+}
+
+// HTTPSession pairs the requests and responses of a keep-alive HTTP/1.1 connection to one of the
+// services, responses come in the order of the requests.
+type HTTPSession struct {
+	service  string
+	client   httpReader
+	server   httpReader
+	requests []*httpMessage
+	// resume joins a chunked response the capture started in the middle of, for responses that
+	// outlive a capture window
+	resume bool
+	// onChunk is called with the oldest request whenever a chunk of its response is complete
+	onChunk func(request *httpMessage, response *httpMessage, timestamp int64)
+}
+
+func NewHTTPSession(service string) *HTTPSession {
+	return &HTTPSession{
+		service: service,
+		client:  httpReader{request: true},
+	}
+}
+
+// Read parses the packet and returns the requests whose response it completed, as *HTTPRequest.
+func (s *HTTPSession) Read(data []byte, fromClient bool, timestamp int64) ([]Record, error) {
+	reader := &s.server
+	if fromClient {
+		reader = &s.client
+	}
+	var completed []Record
+	buffer := bytes.NewBuffer(data)
+	for buffer.Len() > 0 {
+		switch reader.state {
+		case httpStateBody, httpStateChunkData:
+			reader.body(buffer)
+			if reader.remaining > 0 {
+				continue
+			}
+			if reader.state == httpStateChunkData {
+				reader.state = httpStateChunkEnd
+				if !reader.request && s.onChunk != nil && len(s.requests) > 0 {
+					s.onChunk(s.requests[0], reader.message, timestamp)
+				}
+				continue
+			}
+		case httpStateUntilClose:
+			reader.message.size += int64(buffer.Len())
+			buffer.Reset()
+			continue
+		default:
+			line, ok, err := reader.nextLine(buffer)
+			if err != nil {
+				reader.state = httpStateStart
+				return completed, err
+			}
+			if !ok {
+				continue
+			}
+			done, err := s.readLine(reader, line, timestamp)
+			if err != nil {
+				// drop the rest of the packet, the next one may start a message
+				reader.state = httpStateStart
+				return completed, err
+			}
+			if !done {
+				continue
+			}
+		}
+		if request := s.complete(reader, timestamp); request != nil {
+			completed = append(completed, request)
+		}
+	}
+	return completed, nil
+}
+
+// readLine moves the reader along a start line, header, chunk size or trailer and tells whether the
+// message is complete.
+func (s *HTTPSession) readLine(r *httpReader, line []byte, timestamp int64) (bool, error) {
+	switch r.state {
+	case httpStateStart:
+		if len(line) == 0 {
+			// tolerated between messages
+			return false, nil
+		}
+		if !r.request && s.resume && len(s.requests) == 0 {
+			if length, err := strconv.ParseInt(string(line), 16, 64); err == nil && length > 0 {
+				s.resumeChunked(r, length, timestamp)
+				return false, nil
+			}
+		}
+		fields := strings.SplitN(string(line), " ", 3)
+		if len(fields) < 2 {
+			return false, errHTTPStartLine
+		}
+		r.message = &httpMessage{start: timestamp, length: -1}
+		if r.request {
+			if len(fields) < 3 || !strings.HasPrefix(fields[2], "HTTP/") {
+				return false, errHTTPStartLine
+			}
+			r.message.method, r.message.target = fields[0], fields[1]
+		} else {
+			status, err := strconv.Atoi(fields[1])
+			if err != nil || !strings.HasPrefix(fields[0], "HTTP/") {
+				return false, errHTTPStartLine
+			}
+			r.message.status = status
+		}
+		r.state = httpStateHeaders
+	case httpStateHeaders:
+		if len(line) > 0 {
+			header := strings.SplitN(string(line), ":", 2)
+			if len(header) < 2 {
+				return false, nil
+			}
+			value := strings.TrimSpace(header[1])
+			switch strings.ToLower(header[0]) {
+			case "content-length":
+				r.message.length, _ = strconv.ParseInt(value, 10, 64)
+			case "transfer-encoding":
+				r.message.chunked = strings.Contains(strings.ToLower(value), "chunked")
+			}
+			return false, nil
+		}
+		r.message.headers = timestamp
+		return s.startBody(r), nil
+	case httpStateChunkSize:
+		size := strings.SplitN(string(line), ";", 2)[0]
+		length, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+		if err != nil || length < 0 {
+			return false, errHTTPStartLine
+		}
+		if length == 0 {
+			r.state = httpStateTrailers
+		} else {
+			r.remaining = length
+			r.state = httpStateChunkData
+		}
+	case httpStateChunkEnd:
+		r.state = httpStateChunkSize
+	case httpStateTrailers:
+		return len(line) == 0, nil
+	}
+	return false, nil
+}
+
+// resumeChunked joins the chunked response to a request sent before the capture at the chunk of
+// length that starts in this packet.
+func (s *HTTPSession) resumeChunked(r *httpReader, length int64, timestamp int64) {
+	s.requests = append(s.requests, &httpMessage{method: "GET", start: timestamp, resumed: true})
+	r.message = &httpMessage{status: 200, chunked: true, start: timestamp, headers: timestamp}
+	r.remaining = length
+	r.state = httpStateChunkData
+}
+
+// startBody works out how the body of a message ends once its headers are read, and tells whether
+// it has none.
+func (s *HTTPSession) startBody(r *httpReader) bool {
+	message := r.message
+	if !r.request {
+		// interim responses come before the final one to the same request
+		if message.status/100 == 1 {
+			r.state = httpStateStart
+			return false
+		}
+		if message.status == 204 || message.status == 304 ||
+			(len(s.requests) > 0 && s.requests[0].method == "HEAD") {
+			return true
+		}
+	}
+	switch {
+	case message.chunked:
+		r.state = httpStateChunkSize
+	case message.length > 0:
+		r.remaining = message.length
+		r.state = httpStateBody
+	case message.length == 0 || r.request:
+		return true
+	default:
+		// a response without a length ends with the connection, it is paired at its headers and
+		// the rest of the connection is skipped
+		r.state = httpStateUntilClose
+		return true
+	}
+	return false
+}
+
+// complete hands a finished request over to wait for its response, or pairs a finished response
+// with the oldest request.
+func (s *HTTPSession) complete(r *httpReader, timestamp int64) *HTTPRequest {
+	message := r.message
+	if r.state != httpStateUntilClose {
+		r.state = httpStateStart
+		r.message = nil
+	}
+	if r.request {
+		s.requests = append(s.requests, message)
+		return nil
+	}
+	if len(s.requests) == 0 {
+		return nil
+	}
+	request := s.requests[0]
+	s.requests = s.requests[1:]
+	statement, clientContextId := httpStatement(request)
+	return &HTTPRequest{
+		Timestamp:       request.start,
+		Service:         s.service,
+		Method:          request.method,
+		Path:            httpPathTemplate(request.target),
+		Status:          message.status,
+		Latency:         (timestamp - request.start) / 1000,
+		FirstByte:       (message.headers - request.start) / 1000,
+		RequestSize:     request.size,
+		ResponseSize:    message.size,
+		Statement:       statement,
+		ClientContextId: clientContextId,
+	}
+}
+
+// httpPathTemplate leaves the query string out of a request target and replaces the names and ids
+// in its path, to keep the number of paths reported bounded.
+func httpPathTemplate(target string) string {
+	segments := strings.Split(strings.SplitN(target, "?", 2)[0], "/")
+	for _, template := range httpPathTemplates {
+		if matchesPathTemplate(segments, template) {
+			return strings.Join(template, "/")
+		}
+	}
+	for i, segment := range segments {
+		if isPathId(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func matchesPathTemplate(segments []string, template []string) bool {
+	if len(segments) != len(template) {
+		return false
+	}
+	for i, segment := range template {
+		if strings.HasPrefix(segment, "{") {
+			if segments[i] == "" {
+				return false
+			}
+		} else if segments[i] != segment {
+			return false
+		}
+	}
+	return true
+}
+
+// isPathId tells numbers, uuids and long hex strings apart from the names of a REST API.
+func isPathId(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	digits := true
+	for _, c := range segment {
+		switch {
+		case c >= '0' && c <= '9':
+		case c >= 'a' && c <= 'f', c >= 'A' && c <= 'F', c == '-':
+			digits = false
+		default:
+			return false
+		}
+	}
+	return digits || len(segment) >= 16
+}
+
+// httpStatement reads the N1QL or analytics statement and client_context_id of a request, from its
+// query string, a form or a JSON body.
+func httpStatement(request *httpMessage) (string, string) {
+	if target := strings.SplitN(request.target, "?", 2); len(target) == 2 {
+		if values, err := url.ParseQuery(target[1]); err == nil && values.Get("statement") != "" {
+			return values.Get("statement"), values.Get("client_context_id")
+		}
+	}
+	body := bytes.TrimSpace(request.body)
+	if len(body) == 0 || request.truncated {
+		return "", ""
+	}
+	if body[0] == '{' {
+		var query struct {
+			Statement       string `json:"statement"`
+			ClientContextId string `json:"client_context_id"`
+		}
+		if err := json.Unmarshal(body, &query); err != nil {
+			return "", ""
+		}
+		return query.Statement, query.ClientContextId
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return "", ""
+	}
+	return values.Get("statement"), values.Get("client_context_id")
+}
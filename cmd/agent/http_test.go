@@ -0,0 +1,161 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func httpRequests(records []Record) []*HTTPRequest {
+	var requests []*HTTPRequest
+	for _, record := range records {
+		if request, ok := record.(*HTTPRequest); ok {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// httpChunk encodes data as one chunk of a chunked body.
+func httpChunk(data string) string {
+	return fmt.Sprintf("%x\r\n%s\r\n", len(data), data)
+}
+
+func TestHTTPBodyCap(t *testing.T) {
+	statement := "statement=SELECT+1&client_context_id=c1"
+	padding := "&padding=" + strings.Repeat("x", MAX_HTTP_BODY)
+	tests := []struct {
+		name      string
+		chunks    []string
+		statement string
+	}{
+		{"under the cap", []string{statement[:10], statement[10:]}, "SELECT 1"},
+		// the small chunk after the one over the cap must not be read on its own
+		{"over the cap", []string{padding, statement}, ""},
+		{"reaching the cap", []string{statement, padding}, ""},
+	}
+	for _, test := range tests {
+		request := "POST /query/service HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded\r\n" +
+			"Transfer-Encoding: chunked\r\n\r\n"
+		for _, chunk := range test.chunks {
+			request += httpChunk(chunk)
+		}
+		request += httpChunk("")
+		records, errors := readAll(NewHTTPSession("query"), []packetData{
+			{[]byte(request), true, 1000},
+			{[]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\n{}"), false, 5000},
+		})
+		requests := httpRequests(records)
+		if errors != 0 || len(requests) != 1 {
+			t.Fatalf("%v: expected one request, got %+v and %v errors", test.name, records, errors)
+		}
+		if requests[0].Statement != test.statement {
+			t.Errorf("%v: expected statement %q, got %q", test.name, test.statement, requests[0].Statement)
+		}
+	}
+}
+
+func TestHTTPSession(t *testing.T) {
+	body := `{"statement":"SELECT * FROM ` + "`travel-sample`" + ` WHERE id = 10","client_context_id":"abc-1"}`
+	form := "statement=SELECT+1&client_context_id=z"
+	tests := []struct {
+		name     string
+		service  string
+		client   string
+		server   string
+		requests []string
+		errors   int
+	}{
+		{"pipelined requests", "query",
+			"POST /query/service HTTP/1.1\r\nHost: x\r\nContent-Type: application/json\r\n" +
+				fmt.Sprintf("Content-Length: %v\r\n\r\n", len(body)) + body +
+				"GET /default/_design/dd/_view/v?limit=10 HTTP/1.1\r\nHost: x\r\n\r\n" +
+				"HEAD /x/123 HTTP/1.1\r\n\r\n",
+			// an interim response, a chunked body with a trailer, then a HEAD response that has no body
+			"HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5;x=1\r\nhello\r\n3\r\nabc\r\n0\r\nX-T: 1\r\n\r\n" +
+				"HTTP/1.1 404 Not Found\r\nContent-Length: 2\r\n\r\nno" +
+				"HTTP/1.1 200 OK\r\nContent-Length: 99\r\n\r\n",
+			[]string{
+				fmt.Sprintf(`POST /query/service 200 %v 8 "SELECT * FROM `+"`travel-sample`"+` WHERE id = 10" "abc-1"`, len(body)),
+				`GET /{bucket}/_design/{ddoc}/_view/{view} 404 0 2 "" ""`,
+				`HEAD /x/{id} 200 0 0 "" ""`},
+			0},
+		{"statements in a form and the query string", "query",
+			"POST /query/service HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded\r\n" +
+				fmt.Sprintf("Content-Length: %v\r\n\r\n", len(form)) + form +
+				"GET /query/service?statement=SELECT+2&client_context_id=y HTTP/1.1\r\n\r\n",
+			"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n",
+			[]string{
+				fmt.Sprintf(`POST /query/service 200 %v 0 "SELECT 1" "z"`, len(form)),
+				`GET /query/service 204 0 0 "SELECT 2" "y"`},
+			0},
+		{"garbage before a request", "fts",
+			"xx yy\r\nGET /api/index/idx1/query HTTP/1.1\r\n\r\n",
+			"HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\nx",
+			[]string{`GET /api/index/{index}/query 200 0 1 "" ""`},
+			1},
+	}
+	for _, test := range tests {
+		records, errors := readAll(NewHTTPSession(test.service), textPackets(test.client, test.server))
+		var found []string
+		for _, request := range httpRequests(records) {
+			found = append(found, fmt.Sprintf("%v %v %v %v %v %q %q", request.Method, request.Path, request.Status,
+				request.RequestSize, request.ResponseSize, request.Statement, request.ClientContextId))
+			if request.Service != test.service {
+				t.Errorf("%v: expected service %v, got %v", test.name, test.service, request.Service)
+			}
+		}
+		if fmt.Sprint(found) != fmt.Sprint(test.requests) || errors != test.errors {
+			t.Errorf("%v: expected %q and %v errors, got %q and %v errors", test.name, test.requests, test.errors, found, errors)
+		}
+	}
+}
+
+func TestHTTPTiming(t *testing.T) {
+	tests := []struct {
+		name      string
+		response  []packetData
+		latency   int64
+		firstByte int64
+		size      int64
+	}{
+		{"with a length", []packetData{
+			{[]byte("HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\nbo"), false, 3000000},
+			{[]byte("dy"), false, 8000000},
+		}, 7000, 2000, 4},
+		// the end of a response that lasts until the connection closes is not seen
+		{"until the connection closes", []packetData{
+			{[]byte("HTTP/1.1 200 OK\r\n\r\nbo"), false, 3000000},
+			{[]byte("dy"), false, 8000000},
+		}, 2000, 2000, 0},
+	}
+	for _, test := range tests {
+		packets := append([]packetData{{[]byte("GET /pools HTTP/1.1\r\n\r\n"), true, 1000000}}, test.response...)
+		records, _ := readAll(NewHTTPSession("views"), packets)
+		requests := httpRequests(records)
+		if len(requests) != 1 {
+			t.Fatalf("%v: expected one request, got %+v", test.name, records)
+		}
+		request := requests[0]
+		if request.Timestamp != 1000000 || request.Latency != test.latency || request.FirstByte != test.firstByte ||
+			request.ResponseSize != test.size {
+			t.Errorf("%v: unexpected request %+v", test.name, request)
+		}
+	}
+}
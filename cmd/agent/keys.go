@@ -0,0 +1,107 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	KEY_POLICY_PLAIN  = "plain"
+	KEY_POLICY_REDACT = "redact"
+	KEY_POLICY_HASH   = "hash"
+	KEY_POLICY_PREFIX = "prefix"
+)
+
+// Length in bytes of the HMAC kept for hashed keys, enough to keep collisions out of the picture.
+const HASHED_KEY_LENGTH = 16
+
+// KeyPolicy decides what is left of a key before it leaves the agent.
+type KeyPolicy struct {
+	name   string
+	prefix int
+	secret []byte
+}
+
+func NewKeyPolicy(config *KeysConfig) (*KeyPolicy, error) {
+	policy := &KeyPolicy{name: config.Policy}
+	switch {
+	case config.Policy == "" || config.Policy == KEY_POLICY_PLAIN:
+		policy.name = KEY_POLICY_PLAIN
+	case config.Policy == KEY_POLICY_REDACT:
+	case config.Policy == KEY_POLICY_HASH:
+		// every agent needs the same secret for hashed keys to correlate across the cluster
+		if config.Secret == "" {
+			return nil, fmt.Errorf("Key policy %v needs a secret", config.Policy)
+		}
+		policy.secret = []byte(config.Secret)
+	case strings.HasPrefix(config.Policy, KEY_POLICY_PREFIX+":"):
+		prefix, err := strconv.Atoi(strings.TrimPrefix(config.Policy, KEY_POLICY_PREFIX+":"))
+		if err != nil || prefix < 0 {
+			return nil, fmt.Errorf("Invalid key policy %v, expected prefix:N", config.Policy)
+		}
+		policy.name = KEY_POLICY_PREFIX
+		policy.prefix = prefix
+	default:
+		return nil, fmt.Errorf("Unknown key policy %v", config.Policy)
+	}
+	return policy, nil
+}
+
+func (p *KeyPolicy) Apply(key string) string {
+	switch p.name {
+	case KEY_POLICY_REDACT:
+		return ""
+	case KEY_POLICY_HASH:
+		mac := hmac.New(sha256.New, p.secret)
+		mac.Write([]byte(key))
+		return hex.EncodeToString(mac.Sum(nil)[:HASHED_KEY_LENGTH])
+	case KEY_POLICY_PREFIX:
+		return truncate(key, p.prefix)
+	}
+	return key
+}
+
+// truncate cuts s to at most n bytes, backing off to the start of the character at the cut so a
+// multi-byte character is never split and the result stays valid UTF-8.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// String is the policy as it is reported along with the keys, the secret is never part of it.
+func (p *KeyPolicy) String() string {
+	if p.name == KEY_POLICY_PREFIX {
+		return fmt.Sprintf("%v:%v", KEY_POLICY_PREFIX, p.prefix)
+	}
+	return p.name
+}
+
+func (p *KeyPolicy) redacts() bool {
+	return p.name == KEY_POLICY_REDACT
+}
@@ -14,7 +14,6 @@
 * limitations under the License.
 */
 
-
 package main
 
 import (
@@ -23,13 +22,16 @@ import (
 	"flag"
 	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/reflection"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"log"
 	"net"
+	"os"
 	"strings"
 	"sync"
+	"time"
 )
 
 func loadConfig(configFile string, config *Config) {
@@ -45,13 +47,18 @@ func loadConfig(configFile string, config *Config) {
 
 func main() {
 	configFile := flag.String("config", "config.yml", "Config file for the tricorder agent")
+	pcapFile := flag.String("pcap", "", "Analyse a pcap file offline and print the results instead of serving the coordinator")
+	keyLogFile := flag.String("keylog", "", "Key log file to decrypt TLS with, overrides interface.keylog")
 	flag.Parse()
 	agent := &Agent{
-		config: &Config{},
-		mutex:  &sync.Mutex{},
-		logger: &logger.Logger{},
+		config:      &Config{},
+		mutex:       &sync.Mutex{},
+		packetMutex: &sync.Mutex{},
+		metrics:     newMetrics(),
+		logger:      &logger.Logger{},
 	}
 	loadConfig(fmt.Sprint("./", *configFile), agent.config)
+	agent.config.setDefaults()
 
 	if agent.config.logging.logLevel == "" || strings.EqualFold(agent.config.logging.logLevel, "info") {
 		agent.logger.Init(agent.config.logging.file, 1)
@@ -60,20 +67,84 @@ func main() {
 	} else if strings.EqualFold(agent.config.logging.logLevel, "debug") {
 		agent.logger.Init(agent.config.logging.file, 2)
 	}
+	// keep stdout for the results of an offline analysis
+	if *pcapFile != "" && agent.config.logging.file == "" {
+		agent.logger.Init("", logger.ERRORLEVEL)
+	}
+
+	keyPolicy, err := NewKeyPolicy(&agent.config.Keys)
+	if err != nil {
+		log.Fatalf("Invalid key policy: %v", err)
+	}
+	agent.keyPolicy = keyPolicy
+	if agent.statementPolicy, err = NewStatementPolicy(&agent.config.Statements); err != nil {
+		log.Fatalf("Invalid statement policy: %v", err)
+	}
+	if agent.ports, err = agent.config.InterfaceConfig.portAnalysers(); err != nil {
+		log.Fatalf("Invalid analysers: %v", err)
+	}
 
-	agent.logger.Info("Starting the agent at %v", agent.config.Port)
+	if *keyLogFile != "" {
+		agent.config.InterfaceConfig.KeyLog = *keyLogFile
+	}
+	if agent.config.InterfaceConfig.KeyLog != "" {
+		if agent.keyLog, err = NewKeyLog(agent.config.InterfaceConfig.KeyLog); err != nil {
+			log.Fatalf("Unable to read the key log: %v", err)
+		}
+	}
+	if *pcapFile != "" {
+		if err := agent.AnalyseFile(*pcapFile, os.Stdout); err != nil {
+			log.Fatalf("Unable to analyse %v: %v", *pcapFile, err)
+		}
+		return
+	}
+
+	// Refuse to start rather than fall back to plaintext when tls is configured but unusable.
+	agent.dialOption, err = agent.config.TLS.DialOption()
+	if err != nil {
+		log.Fatalf("Invalid tls configuration: %v", err)
+	}
+	// Accept the keepalive pings the coordinator uses to notice a rebooted or partitioned agent, the
+	// coordinator never pings more often than this.
+	serverOptions := []grpc.ServerOption{grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+		MinTime:             5 * time.Second,
+		PermitWithoutStream: true,
+	})}
+	serverOption, err := agent.config.TLS.ServerOption()
+	if err != nil {
+		log.Fatalf("Invalid tls configuration: %v", err)
+	}
+	if serverOption != nil {
+		serverOptions = append(serverOptions, serverOption)
+	}
+	authorizer, err := NewAuthorizer(agent)
+	if err != nil {
+		log.Fatalf("Invalid auth configuration: %v", err)
+	}
+	serverOptions = append(serverOptions, grpc.UnaryInterceptor(authorizer.UnaryInterceptor),
+		grpc.StreamInterceptor(authorizer.StreamInterceptor))
+
+	agent.logger.Info("Starting the agent at %v, reporting keys as %v", agent.config.Port, keyPolicy)
 
 	lis, err := net.Listen("tcp", fmt.Sprint(":", agent.config.Port))
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	s := grpc.NewServer()
+	s := grpc.NewServer(serverOptions...)
 
 	agent.Initialize()
 	pb.RegisterAgentServiceServer(s, agent)
 	// Register reflection service on gRPC server.
 	reflection.Register(s)
+
+	agent.cleanupOnTermination()
+	if agent.config.Admin != "" {
+		go agent.startAdminServer()
+	}
+	if agent.config.Coordinator != "" {
+		go agent.register()
+	}
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
-}
\ No newline at end of file
+}
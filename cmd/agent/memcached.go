@@ -0,0 +1,250 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	pb "../../rpc"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// MemcachedSession follows a connection speaking the binary memcached protocol, pairing requests
+// with their responses by opaque.
+type MemcachedSession struct {
+	currentRequests  map[uint32]*Command
+	currentResponses map[uint32]*Command
+	// clientCommand and serverCommand are the partly read commands of each direction, a server push
+	// can arrive while a request is still being read
+	clientCommand *Command
+	serverCommand *Command
+	bucket        string
+	staleKeys     map[string]uint32
+	// dcp and push follow the messages that are never paired as operations, once the connection
+	// turns out to carry any
+	dcp     *DCPConnection
+	push    *ServerPush
+	metrics *Metrics
+}
+
+type LatencyInfo struct {
+	Opaque           uint32
+	Latency          int64
+	Key              string
+	Opcode           string
+	Status           string
+	Bucket           string
+	Datatype         string
+	ValueSize        uint32
+	UncompressedSize uint32
+	// Specs are the paths of a multi-path subdoc operation with their own status.
+	Specs []SubdocSpec
+}
+
+// Operation is a request timed to the end of its response, captured at Timestamp in nanoseconds.
+type Operation struct {
+	LatencyInfo
+	Timestamp      int64
+	Vbucket        uint16
+	ServerDuration int64
+	PipelineDepth  int
+}
+
+// report adds the operation to the capture map and the hot keys, and to the slow ops when it is over
+// the threshold of its opcode. Keys follow the key policy.
+func (operation *Operation) report(results *Results) {
+	agent := results.agent
+	key := agent.keyPolicy.Apply(operation.Key)
+	info := &pb.AgentResultsResponse_CaptureInfo{
+		Opaque:           strconv.Itoa(int(operation.Opaque)),
+		Oplatency:        fmt.Sprintf("%v", operation.Latency),
+		Key:              key,
+		Opcode:           operation.Opcode,
+		Status:           operation.Status,
+		Bucket:           operation.Bucket,
+		Datatype:         operation.Datatype,
+		ValueSize:        operation.ValueSize,
+		UncompressedSize: operation.UncompressedSize,
+		PathCount:        uint32(len(operation.Specs)),
+	}
+	for _, spec := range operation.Specs {
+		info.Specs = append(info.Specs, &pb.AgentResultsResponse_SubdocSpec{
+			Opcode: spec.Opcode,
+			Path:   agent.keyPolicy.Apply(spec.Path),
+			Status: spec.Status,
+		})
+	}
+	results.CaptureMap[strconv.Itoa(int(operation.Opaque))+strconv.FormatUint(results.streamKey, 10)] = info
+	// raw keys are never held, and keys the policy cuts to the same prefix count as one
+	if operation.Key != "" {
+		agent.hotKeys.Track(operation.Bucket, key, operation.Latency)
+	}
+	if agent.config.SlowOps.isSlow(operation.Opcode, operation.Latency) {
+		results.SlowOps = append(results.SlowOps, &pb.AgentResultsResponse_SlowOp{
+			Timestamp:      operation.Timestamp,
+			Client:         results.stream.client,
+			Server:         results.stream.server,
+			Opaque:         operation.Opaque,
+			Opcode:         operation.Opcode,
+			Status:         operation.Status,
+			Vbucket:        uint32(operation.Vbucket),
+			Bucket:         operation.Bucket,
+			Key:            key,
+			ValueSize:      operation.ValueSize,
+			Latency:        operation.Latency,
+			ServerDuration: operation.ServerDuration,
+			PipelineDepth:  uint32(operation.PipelineDepth),
+		})
+	}
+}
+
+func NewMemcachedSession(metrics *Metrics) *MemcachedSession {
+	return &MemcachedSession{
+		currentRequests:  make(map[uint32]*Command),
+		currentResponses: make(map[uint32]*Command),
+		metrics:          metrics,
+	}
+}
+
+// Read parses every command in data, a packet can end in the middle of one and hold the start of
+// the next. Server pushes and DCP messages are never paired as operations, they go to the
+// *ServerPush or *DCPConnection of the connection, returned the first time it is needed.
+func (s *MemcachedSession) Read(data []byte, fromClient bool, timestamp int64) ([]Record, error) {
+	var records []Record
+	var err error
+	current := &s.serverCommand
+	if fromClient {
+		current = &s.clientCommand
+	}
+	buffer := bytes.NewBuffer(data)
+	for buffer.Len() > 0 {
+		if *current == nil {
+			*current = NewCommand(timestamp)
+		}
+
+		if err = (*current).ReadNewPacketData(buffer); err != nil {
+			if err == io.EOF {
+				err = nil
+			} else {
+				// the packet does not start a command we understand, drop it and wait for the next one
+				*current = nil
+			}
+			break
+		}
+		command := *current
+		*current = nil
+		if command.isServerPush() {
+			if s.push == nil {
+				s.push = NewServerPush()
+				records = append(records, s.push)
+			}
+			s.push.Handle(command)
+			s.metrics.serverPushes.WithLabelValues(command.opcode).Inc()
+		} else if isDCP(command.opcodeByte) {
+			if s.dcp == nil {
+				s.dcp = NewDCPConnection()
+				records = append(records, s.dcp)
+			}
+			s.dcp.Handle(command)
+		} else if command.isResponse() {
+			s.currentResponses[command.opaque] = command
+		} else {
+			s.addRequest(command)
+		}
+	}
+
+	return s.collect(records), err
+}
+
+func (s *MemcachedSession) collect(records []Record) []Record {
+	for opaque, response := range s.currentResponses {
+		if response.isComplete() {
+			if s.currentResponses[opaque].opcode == IGNORED {
+				delete(s.currentResponses, opaque)
+			}
+			if request, ok := s.currentRequests[opaque]; !ok {
+				delete(s.currentResponses, opaque)
+			} else {
+				if request.opcode == IGNORED {
+					delete(s.currentResponses, opaque)
+				} else if request.opcode == SELECT_BUCKET {
+					if response.status == 0 {
+						s.bucket = string(request.key)
+					}
+					s.removeRequest(opaque)
+					delete(s.currentResponses, opaque)
+				} else {
+					value := valueOf(request, response)
+					operation := &Operation{
+						LatencyInfo: LatencyInfo{
+							Opaque:           opaque,
+							Latency:          (response.captureTimeInNanos - request.captureTimeInNanos) / 1000,
+							Key:              string(request.key),
+							Opcode:           request.opcode,
+							Status:           statusName(response.status),
+							Bucket:           s.bucket,
+							Datatype:         datatypeName(value.datatype),
+							ValueSize:        value.valueSize,
+							UncompressedSize: value.uncompressedSize(),
+						},
+						Timestamp:      request.captureTimeInNanos,
+						Vbucket:        request.vbucket,
+						ServerDuration: response.serverDuration,
+						PipelineDepth:  request.pipelineDepth,
+					}
+					if request.subdoc != nil {
+						operation.Specs = subdocSpecs(request.subdoc, response.subdoc, response.status)
+					}
+					records = append(records, operation)
+					if response.status == STATUS_NOT_MY_VBUCKET {
+						records = append(records, s.notMyVbucket(request, response, operation))
+					} else if s.staleKeys != nil {
+						delete(s.staleKeys, operation.Key)
+					}
+					s.removeRequest(opaque)
+					delete(s.currentResponses, opaque)
+				}
+			}
+		}
+	}
+	return records
+}
+
+// valueOf returns the command carrying the value, the request for mutations and the response for reads.
+func valueOf(request *Command, response *Command) *Command {
+	if request.valueSize == 0 {
+		return response
+	}
+	return request
+}
+
+func (s *MemcachedSession) addRequest(request *Command) {
+	if _, ok := s.currentRequests[request.opaque]; !ok {
+		s.metrics.pendingRequests.Inc()
+	}
+	// the requests still waiting on this connection, including this one
+	request.pipelineDepth = len(s.currentRequests) + 1
+	s.currentRequests[request.opaque] = request
+}
+
+func (s *MemcachedSession) removeRequest(opaque uint32) {
+	if _, ok := s.currentRequests[opaque]; ok {
+		s.metrics.pendingRequests.Dec()
+		delete(s.currentRequests, opaque)
+	}
+}
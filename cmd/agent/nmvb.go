@@ -0,0 +1,101 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	pb "../../rpc"
+	"encoding/json"
+)
+
+const (
+	STATUS_NOT_MY_VBUCKET = 0x07
+	// keys retried elsewhere never come back to the connection, forget them past this many
+	MAX_STALE_KEYS = 10000
+)
+
+// NotMyVbucket is an operation sent to a server that no longer owns its vbucket, captured at
+// Timestamp in nanoseconds. Epoch and Revision are those of the cluster map in the response,
+// both 0 when the server left it out because the client already has it. Attempt counts the
+// NOT_MY_VBUCKET responses the key got in a row on the connection, retries on a stale map go past 1.
+type NotMyVbucket struct {
+	Timestamp int64
+	Bucket    string
+	Vbucket   uint16
+	Opcode    string
+	Latency   int64
+	Epoch     int64
+	Revision  int64
+	MapSize   uint32
+	Attempt   uint32
+}
+
+// clustermapRevision reads the epoch and revision of a cluster map, newer servers send
+// revEpoch along with rev.
+func clustermapRevision(value []byte) (int64, int64) {
+	var config struct {
+		Rev      int64 `json:"rev"`
+		RevEpoch int64 `json:"revEpoch"`
+	}
+	if err := json.Unmarshal(value, &config); err != nil {
+		return 0, 0
+	}
+	return config.RevEpoch, config.Rev
+}
+
+// staleKeyAttempt counts the NOT_MY_VBUCKET responses of a key, until it gets any other status.
+func (s *MemcachedSession) staleKeyAttempt(key string) uint32 {
+	if s.staleKeys == nil || len(s.staleKeys) >= MAX_STALE_KEYS {
+		s.staleKeys = make(map[string]uint32)
+	}
+	s.staleKeys[key]++
+	return s.staleKeys[key]
+}
+
+// notMyVbucket records the operation, the stream fills in the connection it was sent on.
+func (s *MemcachedSession) notMyVbucket(request *Command, response *Command, operation *Operation) *NotMyVbucket {
+	notMyVbucket := &NotMyVbucket{
+		Timestamp: response.captureTimeInNanos,
+		Bucket:    operation.Bucket,
+		Vbucket:   request.vbucket,
+		Opcode:    operation.Opcode,
+		Latency:   operation.Latency,
+		MapSize:   response.valueSize,
+		Attempt:   s.staleKeyAttempt(operation.Key),
+	}
+	// a compressed map can not be read without inflating it
+	if response.datatype&DATATYPE_SNAPPY == 0 && len(response.value) > 0 {
+		notMyVbucket.Epoch, notMyVbucket.Revision = clustermapRevision(response.value)
+	}
+	s.metrics.notMyVbuckets.WithLabelValues(notMyVbucket.Bucket).Inc()
+	return notMyVbucket
+}
+
+func (n *NotMyVbucket) report(results *Results) {
+	results.NotMyVbuckets = append(results.NotMyVbuckets, &pb.AgentResultsResponse_NotMyVbucket{
+		Timestamp: n.Timestamp,
+		Client:    results.stream.client,
+		Server:    results.stream.server,
+		Bucket:    n.Bucket,
+		Vbucket:   uint32(n.Vbucket),
+		Opcode:    n.Opcode,
+		Latency:   n.Latency,
+		Epoch:     n.Epoch,
+		Revision:  n.Revision,
+		MapSize:   n.MapSize,
+		Attempt:   n.Attempt,
+	})
+}
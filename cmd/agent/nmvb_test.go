@@ -0,0 +1,93 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func notMyVbuckets(records []Record) []*NotMyVbucket {
+	var notMyVbuckets []*NotMyVbucket
+	for _, record := range records {
+		if notMyVbucket, ok := record.(*NotMyVbucket); ok {
+			notMyVbuckets = append(notMyVbuckets, notMyVbucket)
+		}
+	}
+	return notMyVbuckets
+}
+
+func concatPackets(parts ...[]packetData) []packetData {
+	var packets []packetData
+	for _, part := range parts {
+		packets = append(packets, part...)
+	}
+	return packets
+}
+
+// getOnVbucket is a get of key on vbucket 0x102.
+func getOnVbucket(key string, opaque uint32) []byte {
+	frame := packet(MAGIC_REQUEST, 0x00, key, nil, nil, opaque, 0)
+	frame[6], frame[7] = 0x01, 0x02
+	return frame
+}
+
+func TestNotMyVbucket(t *testing.T) {
+	clustermap := []byte(`{"rev":1042,"revEpoch":2,"name":"travel","nodes":[{"hostname":"$HOST:8091"}]}`)
+	response := func(opaque uint32, status uint16, value []byte) []byte {
+		return packet(MAGIC_RESPONSE, 0x00, "", nil, value, opaque, status)
+	}
+	session := NewMemcachedSession(newMetrics())
+	records, errors := readAll(session, concatPackets(
+		[]packetData{{getOnVbucket("k1", 1), true, 1000}},
+		// the map is read even when it arrives a byte at a time
+		bytewise(response(1, STATUS_NOT_MY_VBUCKET, clustermap), false, 3000),
+		// servers leave the map out when the client already has it
+		[]packetData{{getOnVbucket("k1", 2), true, 4000}, {response(2, STATUS_NOT_MY_VBUCKET, nil), false, 5000}},
+		[]packetData{{getOnVbucket("k1", 3), true, 6000}, {response(3, 0, []byte("v")), false, 7000}},
+		// a compressed map is counted but not read
+		[]packetData{{getOnVbucket("k1", 4), true, 8000},
+			{withDatatype(response(4, STATUS_NOT_MY_VBUCKET, clustermap), DATATYPE_SNAPPY), false, 9000}},
+	))
+	if errors != 0 || len(operations(records)) != 4 {
+		t.Fatalf("expected every response to be an operation, got %+v and %v errors", records, errors)
+	}
+	expected := []NotMyVbucket{
+		{Timestamp: 3000, Vbucket: 0x102, Opcode: "get", Latency: 2, Epoch: 2, Revision: 1042, MapSize: uint32(len(clustermap)), Attempt: 1},
+		{Timestamp: 5000, Vbucket: 0x102, Opcode: "get", Latency: 1, Attempt: 2},
+		// the success in between starts the count again
+		{Timestamp: 9000, Vbucket: 0x102, Opcode: "get", Latency: 1, MapSize: uint32(len(clustermap)), Attempt: 1},
+	}
+	found := notMyVbuckets(records)
+	if len(found) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, found)
+	}
+	for i, notMyVbucket := range found {
+		if *notMyVbucket != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], *notMyVbucket)
+		}
+	}
+}
+
+func TestNotMyVbucketForgetsStaleKeys(t *testing.T) {
+	session := NewMemcachedSession(newMetrics())
+	for i := 0; i < MAX_STALE_KEYS; i++ {
+		session.staleKeyAttempt(string(rune(i)))
+	}
+	if attempt := session.staleKeyAttempt("k1"); attempt != 1 || len(session.staleKeys) != 1 {
+		t.Errorf("expected the stale keys to start over, got attempt %v of %v keys", attempt, len(session.staleKeys))
+	}
+}
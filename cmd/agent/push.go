@@ -0,0 +1,126 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	pb "../../rpc"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	SERVER_CLUSTERMAP_CHANGE_NOTIFICATION = 0x01
+	// a connection flapping between cluster maps should not grow without bound
+	MAX_CLUSTERMAP_NOTIFICATIONS = 1000
+)
+
+var serverOpcodes = map[uint8]string{
+	0x01: "clustermap_change_notification",
+	0x02: "authenticate",
+	0x03: "active_external_users",
+	0x04: "get_authorization",
+}
+
+func serverOpcodeName(opcode uint8) string {
+	if name, ok := serverOpcodes[opcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("server_0x%02x", opcode)
+}
+
+// ClustermapNotification is a new cluster map pushed by the server, captured at Timestamp in
+// nanoseconds. The epoch is 0 for servers that only send the revision. Size is 0 for brief
+// notifications that leave fetching the map to the client.
+type ClustermapNotification struct {
+	Timestamp int64
+	Bucket    string
+	Epoch     int64
+	Revision  int64
+	Size      uint32
+}
+
+// ServerPush follows the requests a server initiates on a duplex connection. Their opaques are
+// the server's own, so they are never paired with the client's operations.
+type ServerPush struct {
+	Requests  uint64
+	Responses uint64
+	// ResponseLatency is the cumulative time in microseconds the client took to answer
+	ResponseLatency      int64
+	Notifications        []ClustermapNotification
+	DroppedNotifications uint32
+	pending              map[uint32]int64
+}
+
+func NewServerPush() *ServerPush {
+	return &ServerPush{pending: make(map[uint32]int64)}
+}
+
+func (p *ServerPush) report(results *Results) {
+	connection := &pb.AgentResultsResponse_ServerPushConnection{
+		Client:               results.stream.client,
+		Server:               results.stream.server,
+		Requests:             p.Requests,
+		Responses:            p.Responses,
+		ResponseLatency:      p.ResponseLatency,
+		DroppedNotifications: p.DroppedNotifications,
+	}
+	for _, notification := range p.Notifications {
+		connection.Notifications = append(connection.Notifications, &pb.AgentResultsResponse_ClustermapNotification{
+			Timestamp: notification.Timestamp,
+			Bucket:    notification.Bucket,
+			Epoch:     notification.Epoch,
+			Revision:  notification.Revision,
+			Size:      notification.Size,
+		})
+	}
+	results.ServerPush = append(results.ServerPush, connection)
+}
+
+func (p *ServerPush) Handle(c *Command) {
+	if c.commandType == SERVER_RESPONSE {
+		p.Responses++
+		if requested, ok := p.pending[c.opaque]; ok {
+			p.ResponseLatency += (c.captureTimeInNanos - requested) / 1000
+			delete(p.pending, c.opaque)
+		}
+		return
+	}
+	p.Requests++
+	// clients do not answer cluster map notifications
+	if c.opcodeByte != SERVER_CLUSTERMAP_CHANGE_NOTIFICATION {
+		p.pending[c.opaque] = c.captureTimeInNanos
+		return
+	}
+	if len(p.Notifications) >= MAX_CLUSTERMAP_NOTIFICATIONS {
+		p.DroppedNotifications++
+		return
+	}
+	notification := ClustermapNotification{
+		Timestamp: c.captureTimeInNanos,
+		Bucket:    string(c.key),
+		Size:      c.valueSize,
+	}
+	// older servers send a 32 bit revision, newer ones the epoch and the revision as 64 bits
+	switch len(c.extras) {
+	case 4:
+		notification.Revision = int64(binary.BigEndian.Uint32(c.extras))
+	case 16:
+		notification.Epoch = int64(binary.BigEndian.Uint64(c.extras))
+		notification.Revision = int64(binary.BigEndian.Uint64(c.extras[8:]))
+	}
+	p.Notifications = append(p.Notifications, notification)
+}
@@ -0,0 +1,81 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func serverPushes(records []Record) []*ServerPush {
+	var pushes []*ServerPush
+	for _, record := range records {
+		if push, ok := record.(*ServerPush); ok {
+			pushes = append(pushes, push)
+		}
+	}
+	return pushes
+}
+
+func TestServerPush(t *testing.T) {
+	// newer servers send the epoch and revision, older ones only a 32 bit revision
+	notifications := concat(
+		packet(MAGIC_SERVER_REQUEST, SERVER_CLUSTERMAP_CHANGE_NOTIFICATION, "travel", uint64s(3, 1234), []byte(`{"rev":1234}`), 9, 0),
+		packet(MAGIC_SERVER_REQUEST, SERVER_CLUSTERMAP_CHANGE_NOTIFICATION, "beer", []byte{0, 0, 0, 7}, nil, 10, 0),
+		packet(MAGIC_SERVER_REQUEST, 0x04, "", nil, nil, 11, 0))
+	packets := []packetData{{packet(MAGIC_REQUEST, 0x00, "k1", nil, nil, 1, 0), true, 1000}}
+	for i := range notifications {
+		packets = append(packets, packetData{notifications[i : i+1], false, 2000})
+	}
+	packets = append(packets,
+		packetData{packet(MAGIC_SERVER_RESPONSE, 0x04, "", nil, nil, 11, 0), true, 7000},
+		packetData{packet(MAGIC_RESPONSE, 0x00, "", make([]byte, 4), []byte("v"), 1, 0), false, 10000})
+
+	records, errors := readAll(NewMemcachedSession(newMetrics()), packets)
+	pushes := serverPushes(records)
+	if errors != 0 || len(pushes) != 1 || len(operations(records)) != 1 {
+		t.Fatalf("expected one push connection and one operation, got %+v and %v errors", records, errors)
+	}
+	push := pushes[0]
+	// only the authorization request is answered, cluster map notifications are not
+	if push.Requests != 3 || push.Responses != 1 || push.ResponseLatency != 5 || len(push.pending) != 0 {
+		t.Errorf("unexpected push connection %+v", push)
+	}
+	expected := []ClustermapNotification{
+		{Timestamp: 2000, Bucket: "travel", Epoch: 3, Revision: 1234, Size: 12},
+		{Timestamp: 2000, Bucket: "beer", Revision: 7},
+	}
+	if len(push.Notifications) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, push.Notifications)
+	}
+	for i, notification := range push.Notifications {
+		if notification != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], notification)
+		}
+	}
+}
+
+func TestServerPushDropsNotifications(t *testing.T) {
+	push := NewServerPush()
+	notification := &Command{commandType: SERVER_REQUEST, opcodeByte: SERVER_CLUSTERMAP_CHANGE_NOTIFICATION}
+	for i := 0; i < MAX_CLUSTERMAP_NOTIFICATIONS+5; i++ {
+		push.Handle(notification)
+	}
+	if len(push.Notifications) != MAX_CLUSTERMAP_NOTIFICATIONS || push.DroppedNotifications != 5 {
+		t.Errorf("expected %v notifications and 5 dropped, got %v and %v", MAX_CLUSTERMAP_NOTIFICATIONS,
+			len(push.Notifications), push.DroppedNotifications)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	pb "../../rpc"
+	"../../tlsconfig"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// advertiseAddress is the address the coordinator uses to reach this agent.
+func (agent *Agent) advertiseAddress() string {
+	if agent.config.Advertise != "" {
+		return agent.config.Advertise
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "127.0.0.1"
+	}
+	return fmt.Sprint(hostname, ":", agent.config.Port)
+}
+
+func (agent *Agent) coordinatorClient() (*grpc.ClientConn, pb.CoordinatorServiceClient, error) {
+	opts := []grpc.DialOption{agent.dialOption}
+	if agent.config.Auth.Token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tlsconfig.TokenCredentials(agent.config.Auth.Token)))
+	}
+	conn, err := grpc.Dial(agent.config.Coordinator, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, pb.NewCoordinatorServiceClient(conn), nil
+}
+
+// register announces the agent to the coordinator, retrying until the coordinator is reachable.
+func (agent *Agent) register() {
+	backoff := time.Second
+	for {
+		conn, client, err := agent.coordinatorClient()
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_, err = client.RegisterAgent(ctx, &pb.AgentRegisterRequest{Address: agent.advertiseAddress()})
+			cancel()
+			conn.Close()
+		}
+		if err == nil {
+			agent.logger.Info("Registered with the coordinator %v as %v", agent.config.Coordinator, agent.advertiseAddress())
+			return
+		}
+		agent.logger.Error("Unable to register with the coordinator %v due to %v", agent.config.Coordinator, err)
+		time.Sleep(backoff)
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+func (agent *Agent) deregister() {
+	conn, client, err := agent.coordinatorClient()
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err = client.DeregisterAgent(ctx, &pb.AgentDeregisterRequest{Address: agent.advertiseAddress()})
+		cancel()
+		conn.Close()
+	}
+	if err != nil {
+		agent.logger.Error("Unable to deregister from the coordinator %v due to %v", agent.config.Coordinator, err)
+	}
+}
+
+func (agent *Agent) cleanupOnTermination() {
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ch
+		if agent.config.Coordinator != "" {
+			agent.deregister()
+		}
+		os.Exit(0)
+	}()
+}
@@ -1,4 +1,5 @@
 // +build linux
+
 /*
 * Copyright (c) 2017 Couchbase, Inc.
 *
@@ -20,6 +21,7 @@ package sniffers
 import (
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/afpacket"
+	"github.com/google/gopacket/layers"
 	"time"
 )
 
@@ -60,5 +62,14 @@ func (h *AfpacketHandle) Close() {
 }
 
 func (h *AfpacketHandle) GetPacketSource() *gopacket.PacketSource {
-	return gopacket.NewPacketSource(TPacket, layers.LinkTypeEthernet)
+	return gopacket.NewPacketSource(h.TPacket, layers.LinkTypeEthernet)
+}
+
+func (h *AfpacketHandle) Stats() (*CaptureStats, error) {
+	// the handle uses TPACKET_V3 where available, the counters are accumulated across reads
+	_, stats, err := h.TPacket.SocketStats()
+	if err != nil {
+		return nil, err
+	}
+	return &CaptureStats{Received: uint64(stats.Packets()), Dropped: uint64(stats.Drops())}, nil
 }
@@ -1,4 +1,5 @@
 // +build !linux
+
 /*
 * Copyright (c) 2017 Couchbase, Inc.
 *
@@ -39,5 +40,9 @@ func (h *AfpacketHandle) GetPacketSource() *gopacket.PacketSource {
 	return nil
 }
 
+func (h *AfpacketHandle) Stats() (*CaptureStats, error) {
+	return nil, fmt.Errorf("Afpacket sniffing is only available on Linux")
+}
+
 func (h *AfpacketHandle) Close() {
 }
@@ -1,4 +1,5 @@
 // +build linux,havepfring
+
 /*
 * Copyright (c) 2017 Couchbase, Inc.
 *
@@ -20,6 +21,7 @@ package sniffers
 import (
 	"fmt"
 	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pfring"
 )
 
@@ -55,7 +57,15 @@ func (h *PfringHandle) Enable() (_ error) {
 }
 
 func (h *PfringHandle) GetPacketSource() *gopacket.PacketSource {
-	return gopacket.NewPacketSource(Ring, layers.LinkTypeEthernet)
+	return gopacket.NewPacketSource(h.Ring, layers.LinkTypeEthernet)
+}
+
+func (h *PfringHandle) Stats() (*CaptureStats, error) {
+	stats, err := h.Ring.Stats()
+	if err != nil {
+		return nil, err
+	}
+	return &CaptureStats{Received: stats.Received, Dropped: stats.Dropped}, nil
 }
 
 func (h *PfringHandle) Close() {
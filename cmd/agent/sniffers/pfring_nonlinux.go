@@ -1,4 +1,5 @@
-// +build !linux
+// +build !linux !havepfring
+
 /*
 * Copyright (c) 2017 Couchbase, Inc.
 *
@@ -41,5 +42,9 @@ func (h *PfringHandle) GetPacketSource() *gopacket.PacketSource {
 	return nil
 }
 
+func (h *PfringHandle) Stats() (*CaptureStats, error) {
+	return nil, fmt.Errorf("PF_RING sniffing is only available on Linux")
+}
+
 func (h *PfringHandle) Close() {
 }
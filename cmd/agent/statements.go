@@ -0,0 +1,136 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	STATEMENT_POLICY_PLAIN    = "plain"
+	STATEMENT_POLICY_LITERALS = "literals"
+	STATEMENT_POLICY_REDACT   = "redact"
+)
+
+// StatementPolicy decides what is left of a N1QL or analytics statement and its client_context_id
+// before they leave the agent.
+type StatementPolicy struct {
+	name      string
+	maxLength int
+}
+
+func NewStatementPolicy(config *StatementsConfig) (*StatementPolicy, error) {
+	policy := &StatementPolicy{name: config.Policy, maxLength: config.MaxLength}
+	switch config.Policy {
+	case "":
+		policy.name = STATEMENT_POLICY_PLAIN
+	case STATEMENT_POLICY_PLAIN, STATEMENT_POLICY_LITERALS, STATEMENT_POLICY_REDACT:
+	default:
+		return nil, fmt.Errorf("Unknown statement policy %v", config.Policy)
+	}
+	if config.MaxLength < 0 {
+		return nil, fmt.Errorf("Invalid statement maxlength %v", config.MaxLength)
+	}
+	return policy, nil
+}
+
+func (p *StatementPolicy) Apply(statement string) string {
+	switch p.name {
+	case STATEMENT_POLICY_REDACT:
+		return ""
+	case STATEMENT_POLICY_LITERALS:
+		statement = stripLiterals(statement)
+	}
+	if p.maxLength > 0 {
+		return truncate(statement, p.maxLength)
+	}
+	return statement
+}
+
+// ClientContextId is only dropped along with the statement, it is what ties a request to the
+// application's own logs.
+func (p *StatementPolicy) ClientContextId(id string) string {
+	if p.name == STATEMENT_POLICY_REDACT {
+		return ""
+	}
+	return id
+}
+
+func (p *StatementPolicy) String() string {
+	return p.name
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// stripLiterals replaces the strings and numbers of a statement with ?, so that statements only
+// differing by their values look the same. Identifiers in backticks and named or positional
+// parameters are kept.
+func stripLiterals(statement string) string {
+	var stripped strings.Builder
+	for i := 0; i < len(statement); {
+		c := statement[i]
+		switch {
+		case c == '\'' || c == '"':
+			// quotes are escaped by a backslash or by doubling them
+			i++
+			for i < len(statement) {
+				if statement[i] == '\\' {
+					i += 2
+					continue
+				}
+				if statement[i] == c {
+					if i+1 < len(statement) && statement[i+1] == c {
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+			i++
+			stripped.WriteByte('?')
+		case c == '`':
+			end := strings.IndexByte(statement[i+1:], '`')
+			if end < 0 {
+				stripped.WriteString(statement[i:])
+				return stripped.String()
+			}
+			stripped.WriteString(statement[i : i+end+2])
+			i += end + 2
+		case c >= '0' && c <= '9':
+			for i < len(statement) && (statement[i] >= '0' && statement[i] <= '9' || statement[i] == '.') {
+				i++
+			}
+			stripped.WriteByte('?')
+		case isIdentifierByte(c):
+			// the whole identifier is copied so that its digits are not taken for a number
+			start := i
+			for i < len(statement) && isIdentifierByte(statement[i]) {
+				i++
+			}
+			stripped.WriteString(statement[start:i])
+		default:
+			stripped.WriteByte(c)
+			i++
+		}
+	}
+	return stripped.String()
+}
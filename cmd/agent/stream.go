@@ -17,69 +17,49 @@
 package main
 
 import (
-	"bytes"
 	"sync"
 )
 
 type Stream struct {
-	mutex            *sync.Mutex
-	currentRequests  map[uint32]*Command
-	currentResponses map[uint32]*Command
-	currentCommand   *Command
-	src              string
-	dst              string
-	latencyInfo      []LatencyInfo
+	mutex  *sync.Mutex
+	src    string
+	dst    string
+	client string
+	server string
+	// analyser follows the protocol of the connection, it is only set once detected on ports
+	// left to auto detection
+	analyser Analyser
+	detect   bool
+	tls      *TLSSession
+	records  []Record
+	metrics  *Metrics
 }
 
-type LatencyInfo struct {
-	Opaque  uint32
-	Latency int64
-	Key     string
-}
-
-func (stream *Stream) collect() {
-	for opaque, response := range stream.currentResponses {
-		if response.isComplete() {
-			if stream.currentResponses[opaque].opcode == IGNORED {
-				delete(stream.currentResponses, opaque)
-			}
-			if request, ok := stream.currentRequests[opaque]; !ok {
-				delete(stream.currentResponses, opaque)
-			} else {
-				if request.opcode == IGNORED {
-					delete(stream.currentResponses, opaque)
-				} else {
-					latencyInfo := LatencyInfo{
-						Opaque:  opaque,
-						Latency: (response.captureTimeInNanos - request.captureTimeInNanos) / 1000,
-						Key:     string(request.key),
-					}
-					stream.latencyInfo = append(stream.latencyInfo, latencyInfo)
-					delete(stream.currentRequests, opaque)
-					delete(stream.currentResponses, opaque)
-				}
-			}
-		}
+// HandlePacket reads the payload of a packet captured at timestamp, in nanoseconds. Connections on
+// a TLS port go through their TLS session first and only the application data it decrypts is
+// analysed.
+func (stream *Stream) HandlePacket(data []byte, fromClient bool, timestamp int64) {
+	if stream.tls == nil {
+		stream.analyse(data, fromClient, timestamp)
+		return
+	}
+	for _, plaintext := range stream.tls.Read(data, fromClient, timestamp) {
+		stream.analyse(plaintext, fromClient, timestamp)
 	}
 }
 
-func (stream *Stream) HandlePacket(data []byte) {
-	if len(data) > 0 {
-		if stream.currentCommand == nil {
-			stream.currentCommand = NewCommand()
-		}
-
-		if err := stream.currentCommand.ReadNewPacketData(bytes.NewBuffer(data)); err != nil {
+func (stream *Stream) analyse(data []byte, fromClient bool, timestamp int64) {
+	if stream.analyser == nil {
+		if !stream.detect {
 			return
 		}
-		if stream.currentCommand.isComplete() && stream.currentCommand.isResponse() {
-			stream.currentResponses[stream.currentCommand.opaque] = stream.currentCommand
-			stream.currentCommand = nil
-		} else if stream.currentCommand.isComplete() && !stream.currentCommand.isResponse() {
-			stream.currentRequests[stream.currentCommand.opaque] = stream.currentCommand
-			stream.currentCommand = nil
+		if stream.analyser = detectAnalyser(data, fromClient, stream.metrics); stream.analyser == nil {
+			return
 		}
 	}
-
-	stream.collect()
+	records, err := stream.analyser.Read(data, fromClient, timestamp)
+	if err != nil {
+		stream.metrics.parseErrors.Inc()
+	}
+	stream.records = append(stream.records, records...)
 }
@@ -0,0 +1,179 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	SUBDOC_MULTI_LOOKUP   = "subdoc_multi_lookup"
+	SUBDOC_MULTI_MUTATION = "subdoc_multi_mutation"
+	// a multi mutation that failed only reports the index and status of the first failing spec
+	STATUS_SUBDOC_MULTI_PATH_FAILURE = 0xcc
+)
+
+// Opcodes of the specs inside a multi lookup or multi mutation.
+var subdocOpcodes = map[uint8]string{
+	0x00: "get_doc",
+	0x01: "set_doc",
+	0x04: "delete_doc",
+	0xc5: "get",
+	0xc6: "exists",
+	0xc7: "dict_add",
+	0xc8: "dict_upsert",
+	0xc9: "delete",
+	0xca: "replace",
+	0xcb: "array_push_last",
+	0xcc: "array_push_first",
+	0xcd: "array_insert",
+	0xce: "array_add_unique",
+	0xcf: "counter",
+	0xd2: "get_count",
+}
+
+func subdocOpcodeName(opcode uint8) string {
+	if name, ok := subdocOpcodes[opcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", opcode)
+}
+
+// SubdocSpec is one path of a multi-path operation, Status is empty until the response is seen.
+type SubdocSpec struct {
+	Opcode string
+	Path   string
+	Status string
+}
+
+type subdocResult struct {
+	index  int
+	status uint16
+}
+
+// subdocDecoder reads the specs of a multi-path request, or the results of its response, as the
+// value arrives. Only the spec headers and paths are kept, the values in between are skipped.
+type subdocDecoder struct {
+	mutation bool
+	response bool
+	status   uint16
+	pending  []byte
+	skip     int
+	specs    []SubdocSpec
+	results  []subdocResult
+}
+
+func newSubdocDecoder(opcode string, commandType CommandType, status uint16) *subdocDecoder {
+	return &subdocDecoder{
+		mutation: opcode == SUBDOC_MULTI_MUTATION,
+		response: commandType == RESPONSE,
+		status:   status,
+	}
+}
+
+// headerLength is the size of the fixed part of each entry in the value.
+func (d *subdocDecoder) headerLength() int {
+	switch {
+	case !d.response && d.mutation:
+		return 8 // opcode, flags, path length, value length
+	case !d.response:
+		return 4 // opcode, flags, path length
+	case d.mutation && d.status == STATUS_SUBDOC_MULTI_PATH_FAILURE:
+		return 3 // index, status
+	case d.mutation:
+		return 7 // index, status, value length
+	}
+	return 6 // status, value length
+}
+
+// entryLength is the size of the current entry up to the value it carries, once its header is known.
+func (d *subdocDecoder) entryLength() int {
+	length := d.headerLength()
+	if !d.response && len(d.pending) >= length {
+		length += int(binary.BigEndian.Uint16(d.pending[2:]))
+	}
+	return length
+}
+
+func (d *subdocDecoder) feed(data []byte) {
+	for len(data) > 0 {
+		if d.skip > 0 {
+			n := d.skip
+			if n > len(data) {
+				n = len(data)
+			}
+			d.skip -= n
+			data = data[n:]
+			continue
+		}
+		needed := d.entryLength() - len(d.pending)
+		if needed > len(data) {
+			d.pending = append(d.pending, data...)
+			return
+		}
+		d.pending = append(d.pending, data[:needed]...)
+		data = data[needed:]
+		if len(d.pending) == d.entryLength() {
+			d.readEntry()
+			d.pending = d.pending[:0]
+		}
+	}
+}
+
+func (d *subdocDecoder) readEntry() {
+	entry := d.pending
+	switch {
+	case !d.response:
+		spec := SubdocSpec{Opcode: subdocOpcodeName(entry[0])}
+		if d.mutation {
+			d.skip = int(binary.BigEndian.Uint32(entry[4:]))
+			spec.Path = string(entry[8:])
+		} else {
+			spec.Path = string(entry[4:])
+		}
+		d.specs = append(d.specs, spec)
+	case d.mutation:
+		d.results = append(d.results, subdocResult{index: int(entry[0]), status: binary.BigEndian.Uint16(entry[1:])})
+		if len(entry) > 3 {
+			d.skip = int(binary.BigEndian.Uint32(entry[3:]))
+		}
+	default:
+		d.results = append(d.results, subdocResult{index: len(d.results), status: binary.BigEndian.Uint16(entry)})
+		d.skip = int(binary.BigEndian.Uint32(entry[2:]))
+	}
+}
+
+// subdocSpecs pairs the specs of a request with the results of its response. A spec without a
+// result of its own takes the status of the whole operation, as mutations only report the specs
+// returning a value, or the first one that failed.
+func subdocSpecs(request *subdocDecoder, response *subdocDecoder, status uint16) []SubdocSpec {
+	specs := make([]SubdocSpec, len(request.specs))
+	copy(specs, request.specs)
+	for i := range specs {
+		specs[i].Status = statusName(status)
+	}
+	if response == nil {
+		return specs
+	}
+	for _, result := range response.results {
+		if result.index < len(specs) {
+			specs[result.index].Status = statusName(result.status)
+		}
+	}
+	return specs
+}
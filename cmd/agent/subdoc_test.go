@@ -0,0 +1,106 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func lookupSpec(opcode uint8, path string) []byte {
+	spec := []byte{opcode, 0, 0, 0}
+	binary.BigEndian.PutUint16(spec[2:], uint16(len(path)))
+	return append(spec, path...)
+}
+
+func mutationSpec(opcode uint8, path string, value string) []byte {
+	spec := []byte{opcode, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(spec[2:], uint16(len(path)))
+	binary.BigEndian.PutUint32(spec[4:], uint32(len(value)))
+	return append(append(spec, path...), value...)
+}
+
+func lookupResult(status uint16, value string) []byte {
+	result := make([]byte, 6)
+	binary.BigEndian.PutUint16(result, status)
+	binary.BigEndian.PutUint32(result[2:], uint32(len(value)))
+	return append(result, value...)
+}
+
+// bytewise splits a frame into its header then one packet per byte, which goes through every
+// partial state of the spec decoder.
+func bytewise(frame []byte, fromClient bool, timestamp int64) []packetData {
+	packets := []packetData{{frame[:HEADER_LENGTH], fromClient, timestamp}}
+	for i := HEADER_LENGTH; i < len(frame); i++ {
+		packets = append(packets, packetData{frame[i : i+1], fromClient, timestamp})
+	}
+	return packets
+}
+
+func concat(parts ...[]byte) []byte {
+	var joined []byte
+	for _, part := range parts {
+		joined = append(joined, part...)
+	}
+	return joined
+}
+
+func TestSubdocSpecs(t *testing.T) {
+	lookup := packet(MAGIC_REQUEST, 0xd0, "doc", []byte{0},
+		concat(lookupSpec(0xc5, "name"), lookupSpec(0xc6, "address.city"), lookupSpec(0x00, "")), 1, 0)
+	mutation := packet(MAGIC_REQUEST, 0xd1, "doc", nil,
+		concat(mutationSpec(0xc8, "count", "12"), mutationSpec(0xcf, "hits", "1")), 1, 0)
+
+	tests := []struct {
+		name     string
+		request  []packetData
+		response []packetData
+		specs    []SubdocSpec
+	}{
+		{"lookup with a missing path",
+			bytewise(lookup, true, 1000),
+			bytewise(packet(MAGIC_RESPONSE, 0xd0, "", nil, concat(lookupResult(0, `"bob"`), lookupResult(0xc0, ""),
+				lookupResult(0, `{"a":1}`)), 1, STATUS_SUBDOC_MULTI_PATH_FAILURE), false, 2000),
+			[]SubdocSpec{{"get", "name", "success"}, {"exists", "address.city", "subdoc_path_not_found"},
+				{"get_doc", "", "success"}}},
+		// a failed mutation only has the index and status of the spec that failed
+		{"failed mutation",
+			[]packetData{{mutation, true, 1000}},
+			[]packetData{{packet(MAGIC_RESPONSE, 0xd1, "", nil, []byte{1, 0, 0xc7}, 1, STATUS_SUBDOC_MULTI_PATH_FAILURE), false, 2000}},
+			[]SubdocSpec{{"dict_upsert", "count", "subdoc_multi_path_failure"}, {"counter", "hits", "subdoc_num_range"}}},
+		// a successful mutation only has results for the specs that return a value
+		{"mutation",
+			[]packetData{{mutation, true, 1000}},
+			[]packetData{{packet(MAGIC_RESPONSE, 0xd1, "", nil, []byte{1, 0, 0, 0, 0, 0, 2, '1', '3'}, 1, 0), false, 2000}},
+			[]SubdocSpec{{"dict_upsert", "count", "success"}, {"counter", "hits", "success"}}},
+		{"missing document",
+			[]packetData{{packet(MAGIC_REQUEST, 0xd0, "gone", nil, lookupSpec(0xc5, "x"), 1, 0), true, 1000}},
+			[]packetData{{packet(MAGIC_RESPONSE, 0xd0, "", nil, nil, 1, 1), false, 2000}},
+			[]SubdocSpec{{"get", "x", "key_not_found"}}},
+	}
+	for _, test := range tests {
+		records, errors := readAll(NewMemcachedSession(newMetrics()), append(test.request, test.response...))
+		ops := operations(records)
+		if errors != 0 || len(ops) != 1 {
+			t.Fatalf("%v: expected one operation, got %+v and %v errors", test.name, records, errors)
+		}
+		if fmt.Sprint(ops[0].Specs) != fmt.Sprint(test.specs) {
+			t.Errorf("%v: expected specs %+v, got %+v", test.name, test.specs, ops[0].Specs)
+		}
+	}
+}
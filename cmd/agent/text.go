@@ -0,0 +1,312 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+const (
+	// keys are at most 250 bytes, a longer line is not the text protocol
+	MAX_TEXT_LINE = 2048
+	TEXT_NOREPLY  = "noreply"
+)
+
+var errTextLineTooLong = errors.New("text protocol line too long")
+var errTextCommand = errors.New("unknown text protocol command")
+
+// Text protocol commands that are timed, as the binary opcode they do the work of. cas is a set
+// with a CAS value, gets a get returning it.
+var textCommands = map[string]string{
+	"get":     GET,
+	"gets":    GET,
+	"gat":     GAT,
+	"gats":    GAT,
+	"set":     SET,
+	"cas":     SET,
+	"add":     ADD,
+	"replace": REPLACE,
+	"append":  APPEND,
+	"prepend": PREPEND,
+	"delete":  DELETE,
+	"incr":    INCREMENT,
+	"decr":    DECREMENT,
+	"touch":   TOUCH,
+}
+
+// Commands answered but not timed, they are only followed to keep requests and responses in step.
+var textAdminCommands = map[string]bool{
+	"stats":          true,
+	"version":        true,
+	"flush_all":      true,
+	"verbosity":      true,
+	"cache_memlimit": true,
+}
+
+// Single line responses and the binary status they stand for, incr and decr answer with the new
+// value instead. The errors can answer any command.
+var textStatuses = map[string]string{
+	"STORED":       "success",
+	"DELETED":      "success",
+	"TOUCHED":      "success",
+	"NOT_FOUND":    "key_not_found",
+	"EXISTS":       "key_exists",
+	"NOT_STORED":   "not_stored",
+	"ERROR":        "unknown_command",
+	"CLIENT_ERROR": "invalid_arguments",
+	"SERVER_ERROR": "internal_error",
+}
+
+func isTextError(response string) bool {
+	return response == "ERROR" || response == "CLIENT_ERROR" || response == "SERVER_ERROR"
+}
+
+type textRequest struct {
+	opcode    string
+	keys      [][]byte
+	valueSize uint32
+	timestamp int64
+	// admin commands are not timed, stats answers with many lines up to END
+	admin     bool
+	multiLine bool
+	// hits are the values a retrieval got so far, by the index of their key
+	hits map[int]*textHit
+}
+
+type textHit struct {
+	valueSize uint32
+	timestamp int64
+}
+
+// textReader splits one direction of a connection into lines, skipping the data blocks that
+// follow storage commands and VALUE lines.
+type textReader struct {
+	line []byte
+	skip int
+}
+
+// next returns the next complete line without its \r\n, false when the data ends first.
+func (r *textReader) next(data *bytes.Buffer) ([]byte, bool, error) {
+	if r.skip > 0 {
+		r.skip -= len(data.Next(r.skip))
+	}
+	for data.Len() > 0 {
+		b, _ := data.ReadByte()
+		if b == '\n' {
+			line := bytes.TrimSuffix(r.line, []byte("\r"))
+			r.line = nil
+			return line, true, nil
+		}
+		if len(r.line) >= MAX_TEXT_LINE {
+			r.line = nil
+			return nil, false, errTextLineTooLong
+		}
+		r.line = append(r.line, b)
+	}
+	return nil, false, nil
+}
+
+// TextSession follows a connection speaking the ASCII memcached protocol to the bucket of its
+// port. It has no opaque, responses come in the order of the requests and operations are numbered
+// as they complete.
+type TextSession struct {
+	client   textReader
+	server   textReader
+	requests []*textRequest
+	ops      uint32
+	bucket   string
+	metrics  *Metrics
+}
+
+func NewTextSession(bucket string, metrics *Metrics) *TextSession {
+	return &TextSession{bucket: bucket, metrics: metrics}
+}
+
+// dataLength reads the length of the data block announced in a storage command or VALUE line.
+func dataLength(field []byte) (int, bool) {
+	n, err := strconv.Atoi(string(field))
+	return n, err == nil && n >= 0
+}
+
+// Read parses the text protocol, each finished operation is returned like a binary one.
+func (text *TextSession) Read(data []byte, fromClient bool, timestamp int64) ([]Record, error) {
+	reader := &text.server
+	if fromClient {
+		reader = &text.client
+	}
+	var records []Record
+	var lineErr error
+	buffer := bytes.NewBuffer(data)
+	for {
+		line, ok, err := reader.next(buffer)
+		if err != nil {
+			return records, err
+		}
+		if !ok {
+			return records, lineErr
+		}
+		if fromClient {
+			if err := text.request(line, timestamp); err != nil {
+				lineErr = err
+			}
+		} else {
+			records = append(records, text.response(line, timestamp)...)
+		}
+	}
+}
+
+func (text *TextSession) request(line []byte, timestamp int64) error {
+	fields := bytes.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	command := string(fields[0])
+	noreply := string(fields[len(fields)-1]) == TEXT_NOREPLY
+	request := &textRequest{timestamp: timestamp}
+	opcode, ok := textCommands[command]
+	switch {
+	case ok:
+		request.opcode = opcode
+	case textAdminCommands[command]:
+		request.admin = true
+		request.multiLine = command == "stats"
+	case command == "quit":
+		return nil
+	default:
+		// most likely a data block the capture started in the middle of
+		return errTextCommand
+	}
+
+	switch opcode {
+	case GET:
+		request.keys = fields[1:]
+	case GAT:
+		if len(fields) > 2 {
+			request.keys = fields[2:]
+		}
+	case SET, ADD, REPLACE, APPEND, PREPEND:
+		// <command> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply] followed by the data
+		if len(fields) < 5 {
+			break
+		}
+		request.keys = fields[1:2]
+		if n, ok := dataLength(fields[4]); ok {
+			request.valueSize = uint32(n)
+			text.client.skip = n + 2
+		}
+	case DELETE, INCREMENT, DECREMENT, TOUCH:
+		if len(fields) > 1 {
+			request.keys = fields[1:2]
+		}
+	}
+	if noreply {
+		return nil
+	}
+	if !request.admin && len(request.keys) == 0 {
+		// the server answers ERROR without timing anything
+		request.admin = true
+	}
+	text.requests = append(text.requests, request)
+	text.metrics.pendingRequests.Inc()
+	return nil
+}
+
+// response returns the operations the line completed.
+func (text *TextSession) response(line []byte, timestamp int64) []Record {
+	var records []Record
+	fields := bytes.Fields(line)
+	if len(fields) == 0 {
+		return records
+	}
+	response := string(fields[0])
+	var length int
+	if response == "VALUE" && len(fields) >= 4 {
+		// VALUE <key> <flags> <bytes> [<cas unique>] followed by the data
+		var ok bool
+		if length, ok = dataLength(fields[3]); ok {
+			text.server.skip = length + 2
+		}
+	}
+	if len(text.requests) == 0 {
+		return records
+	}
+	request := text.requests[0]
+	failed := isTextError(response)
+
+	switch {
+	case request.admin:
+		if request.multiLine && response != "END" && !failed {
+			return records
+		}
+	case request.opcode == GET || request.opcode == GAT:
+		if response == "VALUE" && len(fields) >= 4 {
+			for i, key := range request.keys {
+				if _, ok := request.hits[i]; !ok && bytes.Equal(key, fields[1]) {
+					if request.hits == nil {
+						request.hits = make(map[int]*textHit)
+					}
+					request.hits[i] = &textHit{valueSize: uint32(length), timestamp: timestamp}
+					break
+				}
+			}
+			return records
+		}
+		if response != "END" && !failed {
+			return records
+		}
+		for i, key := range request.keys {
+			if hit, ok := request.hits[i]; ok {
+				records = append(records, text.operation(request, string(key), "success", hit.valueSize, hit.timestamp))
+			} else if failed {
+				records = append(records, text.operation(request, string(key), textStatuses[response], 0, timestamp))
+			} else {
+				records = append(records, text.operation(request, string(key), "key_not_found", 0, timestamp))
+			}
+		}
+	default:
+		status, ok := textStatuses[response]
+		if !ok {
+			// incr and decr answer with the new value
+			status = "success"
+		}
+		records = append(records, text.operation(request, string(request.keys[0]), status, request.valueSize, timestamp))
+	}
+	text.requests = text.requests[1:]
+	text.metrics.pendingRequests.Dec()
+	return records
+}
+
+func (text *TextSession) operation(request *textRequest, key string, status string, valueSize uint32, timestamp int64) *Operation {
+	text.ops++
+	return &Operation{
+		LatencyInfo: LatencyInfo{
+			Opaque:           text.ops,
+			Latency:          (timestamp - request.timestamp) / 1000,
+			Key:              key,
+			Opcode:           request.opcode,
+			Status:           status,
+			Bucket:           text.bucket,
+			Datatype:         datatypeName(0),
+			ValueSize:        valueSize,
+			UncompressedSize: valueSize,
+		},
+		Timestamp:     request.timestamp,
+		PipelineDepth: len(text.requests),
+	}
+}
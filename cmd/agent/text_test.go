@@ -0,0 +1,78 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// textPackets sends client then server a byte at a time, responses 1ms apart.
+func textPackets(client string, server string) []packetData {
+	var packets []packetData
+	for i := range client {
+		packets = append(packets, packetData{[]byte(client[i : i+1]), true, 1000})
+	}
+	for i := range server {
+		packets = append(packets, packetData{[]byte(server[i : i+1]), false, 1000 + int64(i+1)*1000})
+	}
+	return packets
+}
+
+func TestTextProtocol(t *testing.T) {
+	tests := []struct {
+		name       string
+		client     string
+		server     string
+		operations []string
+		errors     int
+	}{
+		{"storage and retrieval",
+			"set k1 0 0 5\r\nhello\r\nset q 0 0 1 noreply\r\nx\r\ncas k1 0 0 2 99\r\nab\r\nget k1\r\n",
+			"STORED\r\nEXISTS\r\nVALUE k1 0 5\r\nhello\r\nEND\r\n",
+			[]string{"set k1 success 5", "set k1 key_exists 2", "get k1 success 5"}, 0},
+		// the keys are reported in the order of the request, not of the values
+		{"multi-key get",
+			"gets k1 k2 k3\r\n",
+			"VALUE k3 0 2 7\r\nab\r\nVALUE k1 0 3 8\r\nabc\r\nEND\r\n",
+			[]string{"get k1 success 3", "get k2 key_not_found 0", "get k3 success 2"}, 0},
+		{"admin commands in between",
+			"stats\r\nincr n 1\r\nversion\r\ndelete k9\r\n",
+			"STAT pid 1\r\nSTAT uptime 2\r\nEND\r\n7\r\nVERSION 1.6\r\nNOT_FOUND\r\n",
+			[]string{"increment n success 0", "delete k9 key_not_found 0"}, 0},
+		{"errors",
+			"bogus\r\ngat 10 k1\r\n" + strings.Repeat("x", MAX_TEXT_LINE+1) + "\r\n",
+			"SERVER_ERROR out of memory\r\n",
+			[]string{"gat k1 internal_error 0"}, 2},
+	}
+	for _, test := range tests {
+		session := NewTextSession("default", newMetrics())
+		records, errors := readAll(session, textPackets(test.client, test.server))
+		var found []string
+		for _, op := range operations(records) {
+			found = append(found, fmt.Sprintf("%v %v %v %v", op.Opcode, op.Key, op.Status, op.ValueSize))
+			if op.Bucket != "default" {
+				t.Errorf("%v: expected the bucket of the port, got %v", test.name, op.Bucket)
+			}
+		}
+		if fmt.Sprint(found) != fmt.Sprint(test.operations) || errors != test.errors || len(session.requests) != 0 {
+			t.Errorf("%v: expected %q and %v errors, got %q and %v errors with %v requests left", test.name,
+				test.operations, test.errors, found, errors, len(session.requests))
+		}
+	}
+}
@@ -0,0 +1,644 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TLS_RECORD_CHANGE_CIPHER_SPEC    = 20
+	TLS_RECORD_ALERT                 = 21
+	TLS_RECORD_HANDSHAKE             = 22
+	TLS_RECORD_APPLICATION_DATA      = 23
+	TLS_RECORD_HEADER_LENGTH         = 5
+	TLS_MAX_RECORD_LENGTH            = 16384 + 2048
+	TLS_HANDSHAKE_CLIENT_HELLO       = 1
+	TLS_HANDSHAKE_SERVER_HELLO       = 2
+	TLS_EXTENSION_PRE_SHARED_KEY     = 41
+	TLS_EXTENSION_SUPPORTED_VERSIONS = 43
+	TLS_VERSION_12                   = 0x0303
+	TLS_VERSION_13                   = 0x0304
+	// TLS 1.3 handshake records are skipped until the traffic key opens one, give up after these many
+	TLS_MAX_HANDSHAKE_RECORDS = 16
+	// A missing secret has the key log read again at most this often
+	KEYLOG_RELOAD_INTERVAL = 100 * time.Millisecond
+)
+
+const (
+	TLS_FROM_CLIENT = 0
+	TLS_FROM_SERVER = 1
+)
+
+// Decryption state of a TLS connection as reported to the coordinator.
+const (
+	TLS_HANDSHAKE          = "handshake"
+	TLS_DECRYPTED          = "decrypted"
+	TLS_NO_KEYS            = "no_keys"
+	TLS_NO_HANDSHAKE       = "no_handshake"
+	TLS_UNSUPPORTED_CIPHER = "unsupported_cipher"
+	TLS_DECRYPT_FAILED     = "failed"
+)
+
+const TLS_ENCRYPTED_ALERT = "encrypted"
+
+var tlsAlertLevels = map[byte]string{
+	1: "warning",
+	2: "fatal",
+}
+
+var tlsAlerts = map[byte]string{
+	0:   "close_notify",
+	10:  "unexpected_message",
+	20:  "bad_record_mac",
+	22:  "record_overflow",
+	40:  "handshake_failure",
+	42:  "bad_certificate",
+	43:  "unsupported_certificate",
+	44:  "certificate_revoked",
+	45:  "certificate_expired",
+	46:  "certificate_unknown",
+	47:  "illegal_parameter",
+	48:  "unknown_ca",
+	49:  "access_denied",
+	50:  "decode_error",
+	51:  "decrypt_error",
+	70:  "protocol_version",
+	71:  "insufficient_security",
+	80:  "internal_error",
+	86:  "inappropriate_fallback",
+	90:  "user_canceled",
+	109: "missing_extension",
+	110: "unsupported_extension",
+	112: "unrecognized_name",
+	116: "certificate_required",
+	120: "no_application_protocol",
+}
+
+func tlsAlertName(alert []byte) string {
+	if len(alert) < 2 {
+		return TLS_ENCRYPTED_ALERT
+	}
+	level, ok := tlsAlertLevels[alert[0]]
+	if !ok {
+		level = fmt.Sprint(alert[0])
+	}
+	description, ok := tlsAlerts[alert[1]]
+	if !ok {
+		description = fmt.Sprint(alert[1])
+	}
+	return level + ":" + description
+}
+
+type tlsCipherSuite struct {
+	keyLength int
+	hash      func() hash.Hash
+}
+
+// The AES-GCM suites are the ones that can be decrypted, which is what the servers negotiate by default.
+var tlsCipherSuites = map[uint16]tlsCipherSuite{
+	0x009c: {16, sha256.New},    // TLS_RSA_WITH_AES_128_GCM_SHA256
+	0x009d: {32, sha512.New384}, // TLS_RSA_WITH_AES_256_GCM_SHA384
+	0xc02b: {16, sha256.New},    // TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+	0xc02c: {32, sha512.New384}, // TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384
+	0xc02f: {16, sha256.New},    // TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	0xc030: {32, sha512.New384}, // TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384
+	0x1301: {16, sha256.New},    // TLS_AES_128_GCM_SHA256
+	0x1302: {32, sha512.New384}, // TLS_AES_256_GCM_SHA384
+}
+
+// KeyLog holds the secrets of an SSLKEYLOGFILE as written by the SDKs or a test harness, indexed by
+// label and client random. Clients append to it as they connect so the lines added since it was
+// last read are read whenever a secret is missing, at most once every KEYLOG_RELOAD_INTERVAL.
+type KeyLog struct {
+	mutex *sync.Mutex
+	file  string
+	// offset is where the lines not read yet start, a file shorter than that is read again
+	offset   int64
+	loadedAt time.Time
+	secrets  map[string][]byte
+}
+
+func NewKeyLog(file string) (*KeyLog, error) {
+	keyLog := &KeyLog{
+		mutex:   &sync.Mutex{},
+		file:    file,
+		secrets: make(map[string][]byte),
+	}
+	return keyLog, keyLog.load()
+}
+
+func (k *KeyLog) load() error {
+	k.loadedAt = time.Now()
+	info, err := os.Stat(k.file)
+	if err != nil {
+		return err
+	}
+	if info.Size() == k.offset {
+		return nil
+	}
+	if info.Size() < k.offset {
+		k.offset = 0
+	}
+	f, err := os.Open(k.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(k.offset, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			// a line still being written is read with the next one
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		k.offset += int64(len(line))
+		fields := strings.Fields(line)
+		if len(fields) != 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		secret, err := hex.DecodeString(fields[2])
+		if err != nil {
+			continue
+		}
+		k.secrets[fields[0]+" "+strings.ToLower(fields[1])] = secret
+	}
+}
+
+// Secret returns the secret logged under label for the session with clientRandom, nil if unknown.
+func (k *KeyLog) Secret(label string, clientRandom []byte) []byte {
+	if k == nil {
+		return nil
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	id := label + " " + hex.EncodeToString(clientRandom)
+	if secret, ok := k.secrets[id]; ok {
+		return secret
+	}
+	// a burst of connections without keys in the file would otherwise each read it again
+	if time.Since(k.loadedAt) < KEYLOG_RELOAD_INTERVAL {
+		return nil
+	}
+	if err := k.load(); err != nil {
+		return nil
+	}
+	return k.secrets[id]
+}
+
+// hkdfExpandLabel derives TLS 1.3 traffic keys as in RFC 8446 section 7.1.
+func hkdfExpandLabel(hash func() hash.Hash, secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+	info := []byte{byte(length >> 8), byte(length), byte(len(label))}
+	info = append(info, label...)
+	info = append(info, 0)
+	var out, block []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		mac := hmac.New(hash, secret)
+		mac.Write(block)
+		mac.Write(info)
+		mac.Write([]byte{counter})
+		block = mac.Sum(nil)
+		out = append(out, block...)
+	}
+	return out[:length]
+}
+
+// tls12PRF is the TLS 1.2 pseudorandom function of RFC 5246 section 5.
+func tls12PRF(hash func() hash.Hash, secret []byte, label string, seed []byte, length int) []byte {
+	labelSeed := append([]byte(label), seed...)
+	var out []byte
+	a := labelSeed
+	for len(out) < length {
+		mac := hmac.New(hash, secret)
+		mac.Write(a)
+		a = mac.Sum(nil)
+		mac = hmac.New(hash, secret)
+		mac.Write(a)
+		mac.Write(labelSeed)
+		out = append(out, mac.Sum(nil)...)
+	}
+	return out[:length]
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// tlsDecrypter opens the records of one direction of a connection.
+type tlsDecrypter struct {
+	aead             cipher.AEAD
+	iv               []byte
+	seq              uint64
+	version          uint16
+	handshake        bool
+	handshakeRecords int
+}
+
+// decrypt returns the content type and plaintext of a record, a content type of 0 for a TLS 1.3
+// handshake record the traffic key does not open.
+func (d *tlsDecrypter) decrypt(header []byte, payload []byte) (byte, []byte, error) {
+	if d.version == TLS_VERSION_12 {
+		explicitNonceLength := 8
+		if len(payload) < explicitNonceLength+d.aead.Overhead() {
+			return 0, nil, fmt.Errorf("Record too short")
+		}
+		nonce := append(append([]byte{}, d.iv...), payload[:explicitNonceLength]...)
+		additional := make([]byte, 13)
+		binary.BigEndian.PutUint64(additional, d.seq)
+		copy(additional[8:], header[:3])
+		binary.BigEndian.PutUint16(additional[11:], uint16(len(payload)-explicitNonceLength-d.aead.Overhead()))
+		plaintext, err := d.aead.Open(nil, nonce, payload[explicitNonceLength:], additional)
+		if err != nil {
+			return 0, nil, err
+		}
+		d.seq++
+		return header[0], plaintext, nil
+	}
+
+	nonce := append([]byte{}, d.iv...)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(d.seq >> uint(8*i))
+	}
+	plaintext, err := d.aead.Open(nil, nonce, payload, header)
+	if err != nil {
+		if d.handshake && d.handshakeRecords < TLS_MAX_HANDSHAKE_RECORDS {
+			d.handshakeRecords++
+			return 0, nil, nil
+		}
+		return 0, nil, err
+	}
+	d.handshake = false
+	d.seq++
+	// the real content type follows the content and precedes the padding
+	i := len(plaintext) - 1
+	for i >= 0 && plaintext[i] == 0 {
+		i--
+	}
+	if i < 0 {
+		return 0, nil, fmt.Errorf("Record without content type")
+	}
+	return plaintext[i], plaintext[:i], nil
+}
+
+// TLSConnection is what a TLS connection looked like over a capture window, latencies are in
+// microseconds.
+type TLSConnection struct {
+	Client            string
+	Server            string
+	Version           string
+	CipherSuite       string
+	Decryption        string
+	Records           uint64
+	Exchanges         uint64
+	Latency           int64
+	MaxLatency        int64
+	HandshakeDuration int64
+	Resumed           bool
+	Alerts            uint32
+	LastAlert         string
+	ExchangeLatencies []int64
+}
+
+// TLSSession follows the records of a connection on the TLS port. Only the record headers and the
+// hellos are needed to time the handshake, see resumptions and alerts, and time client records
+// against the server records that answer them. Application data is also decrypted when the key log
+// has the session's secrets.
+type TLSSession struct {
+	keyLog        *KeyLog
+	buffers       [2][]byte
+	synced        [2]bool
+	encrypted     [2]bool
+	decrypters    [2]*tlsDecrypter
+	appRecords    [2]uint64
+	clientRandom  []byte
+	serverRandom  []byte
+	version       uint16
+	cipherSuite   uint16
+	pskAccepted   bool
+	decryption    string
+	clientHelloAt int64
+	exchangeStart int64
+	stats         TLSConnection
+}
+
+func NewTLSSession(keyLog *KeyLog) *TLSSession {
+	return &TLSSession{keyLog: keyLog}
+}
+
+func tlsDirection(fromClient bool) int {
+	if fromClient {
+		return TLS_FROM_CLIENT
+	}
+	return TLS_FROM_SERVER
+}
+
+func looksLikeRecord(data []byte) bool {
+	return len(data) >= TLS_RECORD_HEADER_LENGTH &&
+		data[0] >= TLS_RECORD_CHANGE_CIPHER_SPEC && data[0] <= TLS_RECORD_APPLICATION_DATA &&
+		data[1] == 3 && data[2] <= 4 &&
+		int(binary.BigEndian.Uint16(data[3:])) <= TLS_MAX_RECORD_LENGTH
+}
+
+// Read takes the payload of a packet in either direction and returns the application data of the
+// records it completes, which is nothing while the session can not be decrypted.
+func (s *TLSSession) Read(data []byte, fromClient bool, timestamp int64) [][]byte {
+	direction := tlsDirection(fromClient)
+	if !s.synced[direction] {
+		// a capture that starts in the middle of a connection picks up at a packet starting a record
+		if !looksLikeRecord(data) {
+			return nil
+		}
+		s.synced[direction] = true
+	}
+	s.buffers[direction] = append(s.buffers[direction], data...)
+
+	var plaintexts [][]byte
+	for len(s.buffers[direction]) >= TLS_RECORD_HEADER_LENGTH {
+		buffer := s.buffers[direction]
+		if !looksLikeRecord(buffer) {
+			// lost track of the record boundaries, wait for a packet starting a record
+			s.buffers[direction] = nil
+			s.synced[direction] = false
+			break
+		}
+		length := TLS_RECORD_HEADER_LENGTH + int(binary.BigEndian.Uint16(buffer[3:]))
+		if len(buffer) < length {
+			break
+		}
+		s.buffers[direction] = buffer[length:]
+		if plaintext := s.handleRecord(direction, buffer[:length], timestamp); len(plaintext) > 0 {
+			plaintexts = append(plaintexts, plaintext)
+		}
+	}
+	return plaintexts
+}
+
+func (s *TLSSession) handleRecord(direction int, record []byte, timestamp int64) []byte {
+	s.stats.Records++
+	header, payload := record[:TLS_RECORD_HEADER_LENGTH], record[TLS_RECORD_HEADER_LENGTH:]
+	switch header[0] {
+	case TLS_RECORD_HANDSHAKE:
+		if !s.encrypted[direction] {
+			s.readHandshake(payload, timestamp)
+			return nil
+		}
+	case TLS_RECORD_ALERT:
+		if !s.encrypted[direction] {
+			s.alert(payload)
+			return nil
+		}
+	case TLS_RECORD_CHANGE_CIPHER_SPEC:
+		// TLS 1.3 only sends it for middlebox compatibility
+		if s.version != TLS_VERSION_13 {
+			// an abbreviated TLS 1.2 handshake has the server change cipher spec first
+			if direction == TLS_FROM_SERVER && !s.encrypted[TLS_FROM_CLIENT] {
+				s.stats.Resumed = true
+			}
+			s.startEncryption(direction, timestamp)
+		}
+		return nil
+	case TLS_RECORD_APPLICATION_DATA:
+		if !s.encrypted[direction] {
+			s.startEncryption(direction, timestamp)
+		}
+	}
+
+	contentType := header[0]
+	var plaintext []byte
+	if decrypter := s.decrypters[direction]; decrypter != nil {
+		var err error
+		if contentType, plaintext, err = decrypter.decrypt(header, payload); err != nil {
+			s.decryption = TLS_DECRYPT_FAILED
+			s.decrypters = [2]*tlsDecrypter{}
+			contentType, plaintext = header[0], nil
+		}
+	}
+	if contentType == TLS_RECORD_ALERT {
+		// an alert the keys did not open reads as encrypted
+		s.alert(plaintext)
+	}
+	if contentType != TLS_RECORD_APPLICATION_DATA {
+		return nil
+	}
+	s.appRecords[direction]++
+	// without keys the first TLS 1.3 client record is its Finished message rather than a request
+	if s.version == TLS_VERSION_13 && s.decrypters[direction] == nil &&
+		direction == TLS_FROM_CLIENT && s.appRecords[direction] == 1 {
+		return plaintext
+	}
+	s.time(direction, timestamp)
+	return plaintext
+}
+
+// time pairs the first client record after a response with the server record that follows it,
+// which is the request and its response for a request-response protocol without pipelining.
+func (s *TLSSession) time(direction int, timestamp int64) {
+	if direction == TLS_FROM_CLIENT {
+		if s.exchangeStart == 0 {
+			s.exchangeStart = timestamp
+		}
+		return
+	}
+	if s.exchangeStart == 0 {
+		return
+	}
+	latency := (timestamp - s.exchangeStart) / 1000
+	s.exchangeStart = 0
+	s.stats.ExchangeLatencies = append(s.stats.ExchangeLatencies, latency)
+	s.stats.Exchanges++
+	s.stats.Latency += latency
+	if latency > s.stats.MaxLatency {
+		s.stats.MaxLatency = latency
+	}
+}
+
+func (s *TLSSession) alert(alert []byte) {
+	s.stats.Alerts++
+	s.stats.LastAlert = tlsAlertName(alert)
+}
+
+// startEncryption switches a direction to encrypted records, the handshake is over once both are.
+func (s *TLSSession) startEncryption(direction int, timestamp int64) {
+	s.encrypted[direction] = true
+	s.setupDecrypter(direction)
+	if s.encrypted[TLS_FROM_CLIENT] && s.encrypted[TLS_FROM_SERVER] && s.clientHelloAt != 0 {
+		s.stats.HandshakeDuration = (timestamp - s.clientHelloAt) / 1000
+	}
+}
+
+// readHandshake picks the randoms, version, cipher suite and whether a pre-shared key resumed the
+// session from the hellos.
+func (s *TLSSession) readHandshake(payload []byte, timestamp int64) {
+	for len(payload) >= 4 {
+		messageType := payload[0]
+		length := int(payload[1])<<16 | int(payload[2])<<8 | int(payload[3])
+		if len(payload) < 4+length {
+			return
+		}
+		body := payload[4 : 4+length]
+		payload = payload[4+length:]
+		if len(body) < 34 {
+			continue
+		}
+		switch messageType {
+		case TLS_HANDSHAKE_CLIENT_HELLO:
+			s.clientRandom = append([]byte{}, body[2:34]...)
+			// a hello retry request has the client say hello again
+			if s.clientHelloAt == 0 {
+				s.clientHelloAt = timestamp
+			}
+		case TLS_HANDSHAKE_SERVER_HELLO:
+			s.serverRandom = append([]byte{}, body[2:34]...)
+			s.version = binary.BigEndian.Uint16(body)
+			rest := body[34:]
+			if len(rest) < 1 || len(rest) < 1+int(rest[0])+3 {
+				continue
+			}
+			rest = rest[1+int(rest[0]):]
+			s.cipherSuite = binary.BigEndian.Uint16(rest)
+			rest = rest[3:]
+			if len(rest) < 2 {
+				continue
+			}
+			rest = rest[2:]
+			for len(rest) >= 4 {
+				extension := binary.BigEndian.Uint16(rest)
+				extensionLength := int(binary.BigEndian.Uint16(rest[2:]))
+				if len(rest) < 4+extensionLength {
+					break
+				}
+				if extension == TLS_EXTENSION_SUPPORTED_VERSIONS && extensionLength == 2 {
+					s.version = binary.BigEndian.Uint16(rest[4:])
+				}
+				if extension == TLS_EXTENSION_PRE_SHARED_KEY {
+					s.pskAccepted = true
+				}
+				rest = rest[4+extensionLength:]
+			}
+		}
+	}
+}
+
+func (s *TLSSession) fail(decryption string) {
+	if s.decryption == "" || s.decryption == TLS_DECRYPTED {
+		s.decryption = decryption
+	}
+}
+
+// setupDecrypter derives the keys for one direction once it switches to encrypted records.
+func (s *TLSSession) setupDecrypter(direction int) {
+	if s.clientRandom == nil || s.serverRandom == nil {
+		s.fail(TLS_NO_HANDSHAKE)
+		return
+	}
+	suite, ok := tlsCipherSuites[s.cipherSuite]
+	if !ok {
+		s.fail(TLS_UNSUPPORTED_CIPHER)
+		return
+	}
+
+	var key, iv []byte
+	if s.version == TLS_VERSION_13 {
+		label := "SERVER_TRAFFIC_SECRET_0"
+		if direction == TLS_FROM_CLIENT {
+			label = "CLIENT_TRAFFIC_SECRET_0"
+		}
+		secret := s.keyLog.Secret(label, s.clientRandom)
+		if secret == nil {
+			s.fail(TLS_NO_KEYS)
+			return
+		}
+		key = hkdfExpandLabel(suite.hash, secret, "key", suite.keyLength)
+		iv = hkdfExpandLabel(suite.hash, secret, "iv", 12)
+	} else {
+		masterSecret := s.keyLog.Secret("CLIENT_RANDOM", s.clientRandom)
+		if masterSecret == nil {
+			s.fail(TLS_NO_KEYS)
+			return
+		}
+		seed := append(append([]byte{}, s.serverRandom...), s.clientRandom...)
+		// the key block holds the client key, the server key, then the client and server salts
+		keyBlock := tls12PRF(suite.hash, masterSecret, "key expansion", seed, 2*suite.keyLength+8)
+		if direction == TLS_FROM_CLIENT {
+			key, iv = keyBlock[:suite.keyLength], keyBlock[2*suite.keyLength:2*suite.keyLength+4]
+		} else {
+			key, iv = keyBlock[suite.keyLength:2*suite.keyLength], keyBlock[2*suite.keyLength+4:]
+		}
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		s.fail(TLS_DECRYPT_FAILED)
+		return
+	}
+	s.decrypters[direction] = &tlsDecrypter{
+		aead:      aead,
+		iv:        iv,
+		version:   s.version,
+		handshake: s.version == TLS_VERSION_13,
+	}
+	if s.decryption == "" {
+		s.decryption = TLS_DECRYPTED
+	}
+}
+
+// Stats returns the connection as seen so far.
+func (s *TLSSession) Stats() TLSConnection {
+	stats := s.stats
+	stats.Decryption = s.decryption
+	if stats.Decryption == "" && s.clientRandom != nil {
+		stats.Decryption = TLS_HANDSHAKE
+	} else if stats.Decryption == "" {
+		stats.Decryption = TLS_NO_HANDSHAKE
+	}
+	switch s.version {
+	case TLS_VERSION_12:
+		stats.Version = "1.2"
+	case TLS_VERSION_13:
+		stats.Version = "1.3"
+		stats.Resumed = s.pskAccepted
+	}
+	if s.cipherSuite != 0 {
+		stats.CipherSuite = tls.CipherSuiteName(s.cipherSuite)
+	}
+	return stats
+}
@@ -0,0 +1,414 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// The traffic keys of the simple 1-RTT handshake in RFC 8448 section 3.
+func TestHkdfExpandLabel(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		key    string
+		iv     string
+	}{
+		{"server handshake", "b67b7d690cc16c4e75e54213cb2d37b4e9c912bcded9105d42befd59d391ad38",
+			"3fce516009c21727d0f2e4e86ee403bc", "5d313eb2671276ee13000b30"},
+		{"server application", "a11af9f05531f856ad47116b45a950328204b4f44bfb6b3a4b4f1f3fcb631643",
+			"9f02283b6c9c07efc26bb9f2ac92e356", "cf782b88dd83549aadf1e984"},
+	}
+	for _, test := range tests {
+		secret := unhex(t, test.secret)
+		if key := hkdfExpandLabel(sha256.New, secret, "key", 16); !bytes.Equal(key, unhex(t, test.key)) {
+			t.Errorf("%v: expected key %v, got %x", test.name, test.key, key)
+		}
+		if iv := hkdfExpandLabel(sha256.New, secret, "iv", 12); !bytes.Equal(iv, unhex(t, test.iv)) {
+			t.Errorf("%v: expected iv %v, got %x", test.name, test.iv, iv)
+		}
+	}
+}
+
+// The commonly used TLS 1.2 SHA-256 PRF test vector.
+func TestTLS12PRF(t *testing.T) {
+	expected := "e3f229ba727be17b8d122620557cd453c2aab21d07c3d495329b52d4e61edb5a6b301791e90d35c9c9a46b4e14baf9af" +
+		"0fa022f7077def17abfd3797c0564bab4fbc91666e9def9b97fce34f796789baa48082d122ee42c5a72e5a5110fff70187347b66"
+	out := tls12PRF(sha256.New, unhex(t, "9bbe436ba940f017b17652849a71db35"), "test label",
+		unhex(t, "a0ba9f936cda311827a6f796ffd5198c"), 100)
+	if !bytes.Equal(out, unhex(t, expected)) {
+		t.Errorf("expected %v, got %x", expected, out)
+	}
+}
+
+func TestTLS12Decrypt(t *testing.T) {
+	key, salt := bytes.Repeat([]byte{1}, 16), []byte{2, 3, 4, 5}
+	aead, _ := newGCM(key)
+	decrypter := &tlsDecrypter{aead: aead, iv: salt, version: TLS_VERSION_12}
+	for seq, content := range []string{"first", "second"} {
+		// the nonce is the salt and the explicit part sent with the record, the additional data
+		// the sequence number, type, version and plaintext length
+		explicit := []byte{0, 0, 0, 0, 0, 0, 0, byte(seq + 7)}
+		additional := make([]byte, 13)
+		binary.BigEndian.PutUint64(additional, uint64(seq))
+		additional[8], additional[9], additional[10] = TLS_RECORD_APPLICATION_DATA, 3, 3
+		binary.BigEndian.PutUint16(additional[11:], uint16(len(content)))
+		payload := append(explicit, aead.Seal(nil, append(append([]byte{}, salt...), explicit...), []byte(content), additional)...)
+		header := []byte{TLS_RECORD_APPLICATION_DATA, 3, 3, byte(len(payload) >> 8), byte(len(payload))}
+
+		contentType, plaintext, err := decrypter.decrypt(header, payload)
+		if err != nil || contentType != TLS_RECORD_APPLICATION_DATA || string(plaintext) != content {
+			t.Errorf("record %v: got %v %q %v", seq, contentType, plaintext, err)
+		}
+	}
+}
+
+func TestTLS13Decrypt(t *testing.T) {
+	key, iv := bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{2}, 12)
+	aead, _ := newGCM(key)
+	decrypter := &tlsDecrypter{aead: aead, iv: iv, version: TLS_VERSION_13, handshake: true}
+	header := func(length int) []byte {
+		return []byte{TLS_RECORD_APPLICATION_DATA, 3, 3, byte(length >> 8), byte(length)}
+	}
+
+	// records under the handshake key do not open with the traffic key and are skipped
+	handshake := bytes.Repeat([]byte{9}, 40)
+	for i := 0; i < TLS_MAX_HANDSHAKE_RECORDS; i++ {
+		if contentType, _, err := decrypter.decrypt(header(len(handshake)), handshake); contentType != 0 || err != nil {
+			t.Fatalf("handshake record %v: got %v %v", i, contentType, err)
+		}
+	}
+
+	// the content type follows the content, then the padding
+	inner := append([]byte("data"), TLS_RECORD_APPLICATION_DATA, 0, 0, 0)
+	nonce := append([]byte{}, iv...)
+	sealed := aead.Seal(nil, nonce, inner, header(len(inner)+aead.Overhead()))
+	contentType, plaintext, err := decrypter.decrypt(header(len(sealed)), sealed)
+	if err != nil || contentType != TLS_RECORD_APPLICATION_DATA || string(plaintext) != "data" {
+		t.Fatalf("got %v %q %v", contentType, plaintext, err)
+	}
+	// once a record opened, one that does not is an error
+	if _, _, err := decrypter.decrypt(header(len(handshake)), handshake); err == nil {
+		t.Error("expected a record that does not open to fail after the handshake")
+	}
+
+	decrypter = &tlsDecrypter{aead: aead, iv: iv, version: TLS_VERSION_13, handshake: true}
+	for i := 0; i < TLS_MAX_HANDSHAKE_RECORDS; i++ {
+		decrypter.decrypt(header(len(handshake)), handshake)
+	}
+	if _, _, err := decrypter.decrypt(header(len(handshake)), handshake); err == nil {
+		t.Errorf("expected to give up after %v handshake records", TLS_MAX_HANDSHAKE_RECORDS)
+	}
+}
+
+func TestKeyLogReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "keys.log")
+	random := bytes.Repeat([]byte{0xab}, 32)
+	line := func(label string, secret byte) string {
+		return fmt.Sprintf("%v %x %x\n", label, random, bytes.Repeat([]byte{secret}, 48))
+	}
+	ioutil.WriteFile(file, []byte("# comment\n"+line("CLIENT_RANDOM", 1)), 0600)
+	keyLog, err := NewKeyLog(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret := keyLog.Secret("CLIENT_RANDOM", random); len(secret) != 48 || secret[0] != 1 {
+		t.Fatalf("expected the logged secret, got %x", secret)
+	}
+
+	f, _ := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0600)
+	defer f.Close()
+	traffic := line("CLIENT_TRAFFIC_SECRET_0", 2)
+	f.WriteString(traffic[:20])
+	if secret := keyLog.Secret("CLIENT_TRAFFIC_SECRET_0", random); secret != nil {
+		t.Fatalf("expected no reload right after the last one, got %x", secret)
+	}
+	keyLog.loadedAt = keyLog.loadedAt.Add(-KEYLOG_RELOAD_INTERVAL)
+	if secret := keyLog.Secret("CLIENT_TRAFFIC_SECRET_0", random); secret != nil {
+		t.Fatalf("expected a partly written line to wait, got %x", secret)
+	}
+	f.WriteString(traffic[20:])
+	keyLog.loadedAt = keyLog.loadedAt.Add(-KEYLOG_RELOAD_INTERVAL)
+	if secret := keyLog.Secret("CLIENT_TRAFFIC_SECRET_0", random); len(secret) != 48 || secret[0] != 2 {
+		t.Fatalf("expected the appended secret, got %x", secret)
+	}
+}
+
+// recordedConn records what the server side of a connection reads and writes.
+type recordedConn struct {
+	net.Conn
+	mutex   *sync.Mutex
+	packets *[]packetData
+}
+
+func (c recordedConn) record(data []byte, fromClient bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	*c.packets = append(*c.packets, packetData{append([]byte{}, data...), fromClient, int64(len(*c.packets)+1) * 1000})
+}
+
+func (c recordedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.record(b[:n], true)
+	return n, err
+}
+
+func (c recordedConn) Write(b []byte) (int, error) {
+	c.record(b, false)
+	return c.Conn.Write(b)
+}
+
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// exchangeTLS has a crypto/tls client send requests that the server answers, and returns what went
+// over the connection along with the key log the client wrote.
+func exchangeTLS(t *testing.T, config *tls.Config, keyLog string, requests []string) []packetData {
+	keys, err := os.Create(keyLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keys.Close()
+	clientConn, serverConn := net.Pipe()
+	var packets []packetData
+	server := tls.Server(recordedConn{serverConn, &sync.Mutex{}, &packets}, config)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		buffer := make([]byte, 1024)
+		for {
+			n, err := server.Read(buffer)
+			if err != nil {
+				return
+			}
+			server.Write(append([]byte("response to "), buffer[:n]...))
+		}
+	}()
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true, KeyLogWriter: keys,
+		MaxVersion: config.MaxVersion, CipherSuites: config.CipherSuites})
+	buffer := make([]byte, 1024)
+	for _, request := range requests {
+		if _, err := client.Write([]byte(request)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Read(buffer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client.Close()
+	<-done
+	server.Close()
+	return packets
+}
+
+func TestTLSSessionDecryptsCryptoTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyLogFile := filepath.Join(dir, "keys.log")
+	certificate := testCertificate(t)
+	requests := []string{"first", "second", "third"}
+
+	tests := []struct {
+		name    string
+		version uint16
+		suite   uint16
+	}{
+		{"TLS 1.2 AES-128-GCM", tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		{"TLS 1.2 AES-256-GCM", tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+		{"TLS 1.3", tls.VersionTLS13, 0},
+	}
+	for _, test := range tests {
+		config := &tls.Config{Certificates: []tls.Certificate{certificate}, MaxVersion: test.version}
+		if test.suite != 0 {
+			config.CipherSuites = []uint16{test.suite}
+		}
+		packets := exchangeTLS(t, config, keyLogFile, requests)
+		keyLog, err := NewKeyLog(keyLogFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		session := NewTLSSession(keyLog)
+		var fromClient, fromServer []string
+		for _, p := range packets {
+			for _, plaintext := range session.Read(p.data, p.fromClient, p.timestamp) {
+				if p.fromClient {
+					fromClient = append(fromClient, string(plaintext))
+				} else {
+					fromServer = append(fromServer, string(plaintext))
+				}
+			}
+		}
+		stats := session.Stats()
+		if stats.Decryption != TLS_DECRYPTED || stats.Exchanges != uint64(len(requests)) {
+			t.Errorf("%v: unexpected stats %+v", test.name, stats)
+		}
+		if fmt.Sprint(fromClient) != fmt.Sprint(requests) || len(fromServer) != len(requests) ||
+			fromServer[0] != "response to first" {
+			t.Errorf("%v: unexpected plaintexts %q and %q", test.name, fromClient, fromServer)
+		}
+
+		// without keys the TLS 1.3 closing alerts look like one more exchange
+		session = NewTLSSession(nil)
+		for _, p := range packets {
+			session.Read(p.data, p.fromClient, p.timestamp)
+		}
+		if stats := session.Stats(); stats.Decryption != TLS_NO_KEYS || stats.Exchanges < uint64(len(requests)) {
+			t.Errorf("%v: unexpected stats without keys %+v", test.name, stats)
+		}
+	}
+}
+
+func tlsRecord(contentType byte, payload []byte) []byte {
+	return append([]byte{contentType, 3, 3, byte(len(payload) >> 8), byte(len(payload))}, payload...)
+}
+
+func tlsHandshakeMessage(messageType byte, body []byte) []byte {
+	return append([]byte{messageType, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+}
+
+func clientHello(random byte) []byte {
+	body := append([]byte{3, 3}, bytes.Repeat([]byte{random}, 32)...)
+	body = append(body, 0, 0, 2, 0x13, 0x01, 1, 0)
+	return tlsRecord(TLS_RECORD_HANDSHAKE, tlsHandshakeMessage(TLS_HANDSHAKE_CLIENT_HELLO, body))
+}
+
+// serverHello picks suite, a TLS 1.3 version goes in the supported_versions extension.
+func serverHello(version uint16, suite uint16, psk bool) []byte {
+	body := append([]byte{3, 3}, bytes.Repeat([]byte{0x22}, 32)...)
+	body = append(body, 0, byte(suite>>8), byte(suite), 0)
+	var extensions []byte
+	if version == TLS_VERSION_13 {
+		extensions = append(extensions, 0, TLS_EXTENSION_SUPPORTED_VERSIONS, 0, 2, 3, 4)
+	}
+	if psk {
+		extensions = append(extensions, 0, TLS_EXTENSION_PRE_SHARED_KEY, 0, 2, 0, 0)
+	}
+	body = append(body, byte(len(extensions)>>8), byte(len(extensions)))
+	body = append(body, extensions...)
+	return tlsRecord(TLS_RECORD_HANDSHAKE, tlsHandshakeMessage(TLS_HANDSHAKE_SERVER_HELLO, body))
+}
+
+func TestTLSRecordTiming(t *testing.T) {
+	changeCipherSpec := tlsRecord(TLS_RECORD_CHANGE_CIPHER_SPEC, []byte{1})
+	data := tlsRecord(TLS_RECORD_APPLICATION_DATA, bytes.Repeat([]byte{0x5a}, 40))
+	tests := []struct {
+		name     string
+		packets  []packetData
+		expected TLSConnection
+	}{
+		{"full TLS 1.2 handshake", []packetData{
+			{clientHello(1), true, 1000},
+			{serverHello(TLS_VERSION_12, 0xc02f, false), false, 3000},
+			{changeCipherSpec, true, 5000},
+			{changeCipherSpec, false, 7000},
+			{data, true, 10000},
+			{data, false, 15000},
+			{data, true, 20000},
+			{data, false, 30000},
+		}, TLSConnection{Version: "1.2", CipherSuite: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", Decryption: TLS_NO_KEYS,
+			Records: 8, Exchanges: 2, Latency: 15, MaxLatency: 10, HandshakeDuration: 6, ExchangeLatencies: []int64{5, 10}}},
+		{"abbreviated TLS 1.2 handshake", []packetData{
+			{clientHello(1), true, 1000},
+			{append(serverHello(TLS_VERSION_12, 0xc02f, false), changeCipherSpec...), false, 3000},
+			{changeCipherSpec, true, 4000},
+		}, TLSConnection{Version: "1.2", CipherSuite: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", Decryption: TLS_NO_KEYS,
+			Records: 4, HandshakeDuration: 3, Resumed: true}},
+		// the first client record is its Finished message, not a request
+		{"TLS 1.3 resumed", []packetData{
+			{clientHello(1), true, 1000},
+			{append(serverHello(TLS_VERSION_13, 0x1301, true), data...), false, 3000},
+			{data, true, 4000},
+			{data, true, 10000},
+			{data, false, 15000},
+		}, TLSConnection{Version: "1.3", CipherSuite: "TLS_AES_128_GCM_SHA256", Decryption: TLS_NO_KEYS,
+			Records: 6, Exchanges: 1, Latency: 5, MaxLatency: 5, HandshakeDuration: 3, Resumed: true,
+			ExchangeLatencies: []int64{5}}},
+		{"handshake alert", []packetData{
+			{clientHello(1), true, 1000},
+			{tlsRecord(TLS_RECORD_ALERT, []byte{2, 40}), false, 2000},
+		}, TLSConnection{Decryption: TLS_HANDSHAKE, Records: 2, Alerts: 1, LastAlert: "fatal:handshake_failure"}},
+		// a capture that starts in the middle of a record waits for one to start a packet
+		{"joined mid-connection", []packetData{
+			{data[20:], true, 1000},
+			{data[:10], true, 2000},
+			{data[10:], true, 2000},
+			{data, false, 4000},
+		}, TLSConnection{Decryption: TLS_NO_HANDSHAKE, Records: 2, Exchanges: 1, Latency: 2, MaxLatency: 2,
+			ExchangeLatencies: []int64{2}}},
+	}
+	for _, test := range tests {
+		session := NewTLSSession(nil)
+		for _, p := range test.packets {
+			if plaintexts := session.Read(p.data, p.fromClient, p.timestamp); len(plaintexts) != 0 {
+				t.Errorf("%v: expected nothing without keys, got %q", test.name, plaintexts)
+			}
+		}
+		if stats := session.Stats(); fmt.Sprintf("%+v", stats) != fmt.Sprintf("%+v", test.expected) {
+			t.Errorf("%v: expected %+v, got %+v", test.name, test.expected, stats)
+		}
+	}
+}
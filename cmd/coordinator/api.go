@@ -0,0 +1,1280 @@
+/*
+ * Copyright (c) 2017 Couchbase, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/codahale/hdrhistogram"
+	"github.com/gorilla/mux"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	DEFAULT_PAGE_LIMIT = 100
+	MAX_PAGE_LIMIT     = 10000
+	DEFAULT_HOT_KEYS   = 20
+	// NOT_MY_VBUCKET responses of a client further apart than this, in milliseconds, are separate
+	// periods on a stale cluster map
+	STALE_TOPOLOGY_GAP = 2000
+	// client_context_ids listed with each statement, the latest ones
+	MAX_CLIENT_CONTEXT_IDS = 20
+	// the service agents report cluster manager requests under
+	CLUSTER_MANAGER_SERVICE = "cluster_manager"
+)
+
+type LatencyStats struct {
+	Count int64   `json:"count"`
+	Min   int64   `json:"min"`
+	Mean  float64 `json:"mean"`
+	P50   int64   `json:"p50"`
+	P90   int64   `json:"p90"`
+	P99   int64   `json:"p99"`
+	P999  int64   `json:"p99.9"`
+	Max   int64   `json:"max"`
+}
+
+type LatencyBucket struct {
+	From  int64 `json:"from"`
+	To    int64 `json:"to"`
+	Count int64 `json:"count"`
+}
+
+type WindowStats struct {
+	Start int64         `json:"start"`
+	End   int64         `json:"end"`
+	Stats *LatencyStats `json:"stats"`
+}
+
+// LatencyHistograms are latencies merged over a range, overall and split by agent and by opcode.
+type LatencyHistograms struct {
+	overall *hdrhistogram.Histogram
+	agents  map[string]*hdrhistogram.Histogram
+	opcodes map[string]*hdrhistogram.Histogram
+}
+
+type MergedHotKey struct {
+	Bucket      string  `json:"bucket"`
+	Key         string  `json:"key"`
+	Count       uint64  `json:"count"`
+	Latency     uint64  `json:"latency"`
+	MeanLatency float64 `json:"mean_latency"`
+	KeyPolicy   string  `json:"key_policy"`
+}
+
+// ValueSizeStats summarises value sizes in bytes. The compression ratio is the total uncompressed
+// size over the total size on the wire, 1 when nothing was compressed and 0 without values.
+type ValueSizeStats struct {
+	Wire             *LatencyStats `json:"wire"`
+	Uncompressed     *LatencyStats `json:"uncompressed"`
+	CompressionRatio float64       `json:"compression_ratio"`
+}
+
+// MergedSubdocPath is a path of one spec type merged over agents and windows, with the statuses
+// its specs ended with.
+type MergedSubdocPath struct {
+	Bucket      string            `json:"bucket"`
+	Spec        string            `json:"spec"`
+	Path        string            `json:"path"`
+	Count       uint64            `json:"count"`
+	Failures    uint64            `json:"failures"`
+	Latency     int64             `json:"latency"`
+	MeanLatency float64           `json:"mean_latency"`
+	MaxLatency  int64             `json:"max_latency"`
+	Statuses    map[string]uint64 `json:"statuses"`
+	KeyPolicy   string            `json:"key_policy"`
+}
+
+// VbucketCount is how many NOT_MY_VBUCKET responses a vbucket got.
+type VbucketCount struct {
+	Vbucket uint32 `json:"vbucket"`
+	Count   uint64 `json:"count"`
+}
+
+// ClientNotMyVbuckets counts the NOT_MY_VBUCKET responses of a client host over all its connections.
+type ClientNotMyVbuckets struct {
+	Client   string          `json:"client"`
+	Count    uint64          `json:"count"`
+	Retries  uint64          `json:"retries"`
+	Vbuckets []*VbucketCount `json:"vbuckets"`
+	vbuckets map[uint32]*VbucketCount
+}
+
+// StaleTopology is a period a client spent sending operations of a bucket on a stale cluster map,
+// from its first NOT_MY_VBUCKET response to its last, timestamps and duration in milliseconds.
+type StaleTopology struct {
+	Client        string `json:"client"`
+	Bucket        string `json:"bucket"`
+	Start         int64  `json:"start"`
+	End           int64  `json:"end"`
+	Duration      int64  `json:"duration"`
+	Count         uint64 `json:"count"`
+	Retries       uint64 `json:"retries"`
+	Vbuckets      int    `json:"vbuckets"`
+	FirstRevision int64  `json:"first_revision"`
+	LastRevision  int64  `json:"last_revision"`
+	vbuckets      map[uint32]bool
+}
+
+// HTTPPath is a path template of an HTTP service merged over agents and windows, latencies in
+// microseconds.
+type HTTPPath struct {
+	Service   string            `json:"service"`
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Count     int64             `json:"count"`
+	Statuses  map[string]uint64 `json:"statuses"`
+	Latency   *LatencyStats     `json:"latency"`
+	FirstByte *LatencyStats     `json:"first_byte"`
+	latency   *hdrhistogram.Histogram
+	firstByte *hdrhistogram.Histogram
+}
+
+// MergedConfigStream is a streaming config request merged over the windows it stayed open in,
+// timestamps in milliseconds and intervals between pushes in microseconds.
+type MergedConfigStream struct {
+	Agent       string `json:"agent"`
+	Client      string `json:"client"`
+	Server      string `json:"server"`
+	Path        string `json:"path"`
+	Start       int64  `json:"start"`
+	FirstConfig int64  `json:"first_config"`
+	LastConfig  int64  `json:"last_config"`
+	Configs     uint64 `json:"configs"`
+	Bytes       int64  `json:"bytes"`
+	MinInterval int64  `json:"min_interval"`
+	MaxInterval int64  `json:"max_interval"`
+}
+
+// Bootstrap is a client fetching its first cluster config, from its first request to the cluster
+// manager to the first config it got, timestamps and duration in milliseconds. FirstConfig is 0 when
+// no config came within the range.
+type Bootstrap struct {
+	Start       int64 `json:"start"`
+	FirstConfig int64 `json:"first_config"`
+	Duration    int64 `json:"duration"`
+	Requests    int   `json:"requests"`
+}
+
+// ClientClusterManager is the cluster manager traffic of a client host over all its connections,
+// PeakRate being the most requests it sent within one second.
+type ClientClusterManager struct {
+	Client     string       `json:"client"`
+	Requests   int          `json:"requests"`
+	PeakRate   int          `json:"peak_rate"`
+	Streams    int          `json:"streams"`
+	Configs    uint64       `json:"configs"`
+	Bootstraps []*Bootstrap `json:"bootstraps"`
+	perSecond  map[int64]int
+	bootstrap  *Bootstrap
+}
+
+// HTTPStatement is a N1QL or analytics statement merged over agents and windows, with the latest
+// client_context_ids it was sent with.
+type HTTPStatement struct {
+	Service          string        `json:"service"`
+	Statement        string        `json:"statement"`
+	Count            int64         `json:"count"`
+	Failures         uint64        `json:"failures"`
+	TotalLatency     int64         `json:"total_latency"`
+	Latency          *LatencyStats `json:"latency"`
+	ClientContextIds []string      `json:"client_context_ids"`
+	StatementPolicy  string        `json:"statement_policy"`
+	latency          *hdrhistogram.Histogram
+}
+
+type AgentStatus struct {
+	Address string `json:"address"`
+	State   string `json:"state"`
+}
+
+func newLatencyStats(histogram *hdrhistogram.Histogram) *LatencyStats {
+	return &LatencyStats{
+		Count: histogram.TotalCount(),
+		Min:   histogram.Min(),
+		Mean:  histogram.Mean(),
+		P50:   histogram.ValueAtQuantile(50),
+		P90:   histogram.ValueAtQuantile(90),
+		P99:   histogram.ValueAtQuantile(99),
+		P999:  histogram.ValueAtQuantile(99.9),
+		Max:   histogram.Max(),
+	}
+}
+
+func newLatencyHistograms() *LatencyHistograms {
+	return &LatencyHistograms{
+		overall: newLatencyHistogram(),
+		agents:  make(map[string]*hdrhistogram.Histogram),
+		opcodes: make(map[string]*hdrhistogram.Histogram),
+	}
+}
+
+func histogramFor(histograms map[string]*hdrhistogram.Histogram, name string) *hdrhistogram.Histogram {
+	histogram, ok := histograms[name]
+	if !ok {
+		histogram = newLatencyHistogram()
+		histograms[name] = histogram
+	}
+	return histogram
+}
+
+func (h *LatencyHistograms) merge(agent string, opcode string, histogram *hdrhistogram.Histogram) {
+	h.overall.Merge(histogram)
+	histogramFor(h.agents, agent).Merge(histogram)
+	// histograms stored before opcodes were tracked only count towards the totals
+	if opcode != "" {
+		histogramFor(h.opcodes, opcode).Merge(histogram)
+	}
+}
+
+func (h *LatencyHistograms) record(agent string, opcode string, latency int64) {
+	recordLatency(h.overall, latency)
+	recordLatency(histogramFor(h.agents, agent), latency)
+	if opcode != "" {
+		recordLatency(histogramFor(h.opcodes, opcode), latency)
+	}
+}
+
+func newValueSizeStats(wire *hdrhistogram.Histogram, uncompressed *hdrhistogram.Histogram) *ValueSizeStats {
+	stats := &ValueSizeStats{
+		Wire:         newLatencyStats(wire),
+		Uncompressed: newLatencyStats(uncompressed),
+	}
+	// by the means, values whose uncompressed size is unknown are only left out of the uncompressed side
+	if wire.TotalCount() > 0 && uncompressed.TotalCount() > 0 {
+		stats.CompressionRatio = uncompressed.Mean() / wire.Mean()
+	}
+	return stats
+}
+
+func (m *MergedSubdocPath) add(path *SubdocPath) {
+	m.Count += path.Count
+	// a spec on a deleted document still did what it was asked
+	if path.Status != "success" && path.Status != "subdoc_success_deleted" {
+		m.Failures += path.Count
+	}
+	m.Latency += path.Latency
+	if path.MaxLatency > m.MaxLatency {
+		m.MaxLatency = path.MaxLatency
+	}
+	m.Statuses[path.Status] += path.Count
+}
+
+func nowInMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// parseTime accepts either milliseconds since the epoch or an RFC3339 timestamp.
+func parseTime(value string, fallback int64) (int64, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return millis, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected milliseconds since the epoch or RFC3339", value)
+	}
+	return t.UnixNano() / int64(time.Millisecond), nil
+}
+
+func parseInt(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid number %q", value)
+	}
+	return n, nil
+}
+
+// parseFilter reads the filters shared by the query endpoints: from, to, agent, opcode, status,
+// bucket and key_prefix, and limit and offset for pagination.
+func parseFilter(r *http.Request) (*OperationFilter, error) {
+	query := r.URL.Query()
+	filter := &OperationFilter{
+		Agent:     query.Get("agent"),
+		Opcode:    query.Get("opcode"),
+		Status:    query.Get("status"),
+		Bucket:    query.Get("bucket"),
+		KeyPrefix: query.Get("key_prefix"),
+	}
+	var err error
+	if filter.From, err = parseTime(query.Get("from"), 0); err != nil {
+		return nil, err
+	}
+	if filter.To, err = parseTime(query.Get("to"), nowInMillis()); err != nil {
+		return nil, err
+	}
+	if filter.Limit, err = parseInt(query.Get("limit"), DEFAULT_PAGE_LIMIT); err != nil {
+		return nil, err
+	}
+	if filter.Limit == 0 || filter.Limit > MAX_PAGE_LIMIT {
+		filter.Limit = MAX_PAGE_LIMIT
+	}
+	if filter.Offset, err = parseInt(query.Get("offset"), 0); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+func (c *Coordinator) writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		c.logger.Error("Unable to write response %v", err)
+	}
+}
+
+func (c *Coordinator) writeError(w http.ResponseWriter, status int, err error) {
+	c.writeJson(w, status, map[string]string{"error": err.Error()})
+}
+
+// writePage writes one page of count query results under name, with the range and pagination
+// that selected it. next_offset is only set when the page is full, so more results may follow.
+func (c *Coordinator) writePage(w http.ResponseWriter, filter *OperationFilter, name string, page interface{}, count int) {
+	if count == 0 {
+		// an empty page is still a list to clients, not null
+		page = []interface{}{}
+	}
+	response := map[string]interface{}{
+		"from":   filter.From,
+		"to":     filter.To,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+		name:     page,
+	}
+	if count == filter.Limit {
+		response["next_offset"] = filter.Offset + filter.Limit
+	}
+	c.writeJson(w, http.StatusOK, response)
+}
+
+func (c *Coordinator) opsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	operations, err := c.store.QueryRange(filter)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	c.writePage(w, filter, "operations", operations, len(operations))
+}
+
+// histogramFilter selects stored histograms, which only know the agent and opcode of an operation.
+func histogramFilter(filter *OperationFilter) bool {
+	return filter.Status == "" && filter.Bucket == "" && filter.KeyPrefix == ""
+}
+
+func (f *OperationFilter) matchesHistogram(histogram *AgentHistogram) bool {
+	return (f.Agent == "" || histogram.Agent == f.Agent) &&
+		(f.Opcode == "" || histogram.Opcode == f.Opcode)
+}
+
+// latencyHistograms merges the latencies matching filter into one histogram overall, one per agent
+// and one per opcode. The stored window histograms are merged when the filter allows it, otherwise
+// the histograms are built from the matching operations, which are gone for downsampled windows.
+func (c *Coordinator) latencyHistograms(filter *OperationFilter) (*LatencyHistograms, error) {
+	histograms := newLatencyHistograms()
+	if histogramFilter(filter) {
+		stored, err := c.store.QueryHistograms(filter.From, filter.To)
+		if err != nil {
+			return nil, err
+		}
+		for _, histogram := range stored {
+			if filter.matchesHistogram(histogram) {
+				histograms.merge(histogram.Agent, histogram.Opcode, histogram.Histogram)
+			}
+		}
+		return histograms, nil
+	}
+
+	operations, err := c.store.QueryRange(&OperationFilter{
+		From:      filter.From,
+		To:        filter.To,
+		Agent:     filter.Agent,
+		Opcode:    filter.Opcode,
+		Status:    filter.Status,
+		Bucket:    filter.Bucket,
+		KeyPrefix: filter.KeyPrefix,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range operations {
+		histograms.record(op.Agent, op.Opcode, op.Latency)
+	}
+	return histograms, nil
+}
+
+// percentilesHandler summarises the latency of the operations matching the filters, overall, per
+// agent and per opcode. Pagination does not apply.
+func (c *Coordinator) percentilesHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	histograms, err := c.latencyHistograms(filter)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	agents := make(map[string]*LatencyStats)
+	for agent, histogram := range histograms.agents {
+		agents[agent] = newLatencyStats(histogram)
+	}
+	opcodes := make(map[string]*LatencyStats)
+	for opcode, histogram := range histograms.opcodes {
+		opcodes[opcode] = newLatencyStats(histogram)
+	}
+	c.writeJson(w, http.StatusOK, map[string]interface{}{
+		"from":    filter.From,
+		"to":      filter.To,
+		"overall": newLatencyStats(histograms.overall),
+		"agents":  agents,
+		"opcodes": opcodes,
+	})
+}
+
+// histogramHandler returns the full distribution of the matching latencies, leaving out empty buckets.
+func (c *Coordinator) histogramHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	histograms, err := c.latencyHistograms(filter)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	buckets := []*LatencyBucket{}
+	for _, bar := range histograms.overall.Distribution() {
+		if bar.Count > 0 {
+			buckets = append(buckets, &LatencyBucket{From: bar.From, To: bar.To, Count: bar.Count})
+		}
+	}
+	c.writeJson(w, http.StatusOK, map[string]interface{}{
+		"from":    filter.From,
+		"to":      filter.To,
+		"stats":   newLatencyStats(histograms.overall),
+		"buckets": buckets,
+	})
+}
+
+// windowPercentilesHandler summarises every capture window in the range on its own, for plotting
+// latency over time. Only the agent and opcode filters apply.
+func (c *Coordinator) windowPercentilesHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !histogramFilter(filter) {
+		c.writeError(w, http.StatusBadRequest, fmt.Errorf("only the agent and opcode filters apply per window"))
+		return
+	}
+	stored, err := c.store.QueryHistograms(filter.From, filter.To)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// the histograms are ordered by window so each window is a run of consecutive entries
+	windows := []*WindowStats{}
+	var current *WindowStats
+	var merged *hdrhistogram.Histogram
+	for _, histogram := range stored {
+		if current == nil || current.Start != histogram.Start || current.End != histogram.End {
+			if current != nil {
+				current.Stats = newLatencyStats(merged)
+			}
+			current = &WindowStats{Start: histogram.Start, End: histogram.End}
+			merged = newLatencyHistogram()
+			windows = append(windows, current)
+		}
+		if filter.matchesHistogram(histogram) {
+			merged.Merge(histogram.Histogram)
+		}
+	}
+	if current != nil {
+		current.Stats = newLatencyStats(merged)
+	}
+	c.writeJson(w, http.StatusOK, map[string]interface{}{
+		"from":    filter.From,
+		"to":      filter.To,
+		"windows": windows,
+	})
+}
+
+func (c *Coordinator) agentsHandler(w http.ResponseWriter, r *http.Request) {
+	agents := []*AgentStatus{}
+	for _, agentInfo := range c.orderedAgents() {
+		agents = append(agents, &AgentStatus{
+			Address: agentInfo.hostname,
+			State:   agentInfo.getState().String(),
+		})
+	}
+	c.writeJson(w, http.StatusOK, map[string]interface{}{
+		"agents": agents,
+	})
+}
+
+func (c *Coordinator) windowsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	windows, err := c.store.QueryWindows(filter.From, filter.To)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	page := []*WindowInfo{}
+	if filter.Offset < len(windows) {
+		page = windows[filter.Offset:]
+	}
+	response := map[string]interface{}{
+		"from":   filter.From,
+		"to":     filter.To,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+	}
+	if len(page) > filter.Limit {
+		page = page[:filter.Limit]
+		response["next_offset"] = filter.Offset + filter.Limit
+	}
+	response["windows"] = page
+	c.writeJson(w, http.StatusOK, response)
+}
+
+func (c *Coordinator) slowOpsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	slowOps, err := c.store.QuerySlowOps(filter)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	c.writePage(w, filter, "slow_ops", slowOps, len(slowOps))
+}
+
+// hotKeysHandler merges the hot keys of every agent and window in the range and returns the top
+// ones cluster-wide, by count or with by=latency by cumulative latency. Keys that were not in an
+// agent's top list for a window are not counted for it, so totals are a lower bound.
+func (c *Coordinator) hotKeysHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if r.URL.Query().Get("limit") == "" {
+		filter.Limit = DEFAULT_HOT_KEYS
+	}
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = "count"
+	} else if by != "count" && by != "latency" {
+		c.writeError(w, http.StatusBadRequest, fmt.Errorf("by must be count or latency"))
+		return
+	}
+	stored, err := c.store.QueryHotKeys(filter)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	merged := make(map[string]*MergedHotKey)
+	for _, hotKey := range stored {
+		// keys reported under different policies can not be the same key
+		id := hotKey.KeyPolicy + "\x00" + hotKey.Bucket + "\x00" + hotKey.Key
+		entry, ok := merged[id]
+		if !ok {
+			entry = &MergedHotKey{Bucket: hotKey.Bucket, Key: hotKey.Key, KeyPolicy: hotKey.KeyPolicy}
+			merged[id] = entry
+		}
+		entry.Count += hotKey.Count
+		entry.Latency += hotKey.Latency
+	}
+	hotKeys := []*MergedHotKey{}
+	for _, entry := range merged {
+		if entry.Count > 0 {
+			entry.MeanLatency = float64(entry.Latency) / float64(entry.Count)
+		}
+		hotKeys = append(hotKeys, entry)
+	}
+	sort.Slice(hotKeys, func(i, j int) bool {
+		if by == "latency" {
+			return hotKeys[i].Latency > hotKeys[j].Latency
+		}
+		return hotKeys[i].Count > hotKeys[j].Count
+	})
+	if filter.Offset >= len(hotKeys) {
+		hotKeys = []*MergedHotKey{}
+	} else {
+		hotKeys = hotKeys[filter.Offset:]
+	}
+	if len(hotKeys) > filter.Limit {
+		hotKeys = hotKeys[:filter.Limit]
+	}
+	c.writeJson(w, http.StatusOK, map[string]interface{}{
+		"from":     filter.From,
+		"to":       filter.To,
+		"by":       by,
+		"hot_keys": hotKeys,
+	})
+}
+
+// subdocHandler merges the paths of subdoc multi-path operations across agents and windows, sorted
+// by count or with by=latency or by=failures, along with a summary of every spec type. The opcode
+// filter selects a spec type.
+func (c *Coordinator) subdocHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if r.URL.Query().Get("limit") == "" {
+		filter.Limit = DEFAULT_HOT_KEYS
+	}
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = "count"
+	} else if by != "count" && by != "latency" && by != "failures" {
+		c.writeError(w, http.StatusBadRequest, fmt.Errorf("by must be count, latency or failures"))
+		return
+	}
+	stored, err := c.store.QuerySubdocPaths(filter)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	merged := make(map[string]*MergedSubdocPath)
+	specs := make(map[string]*MergedSubdocPath)
+	for _, path := range stored {
+		// paths reported under different policies can not be the same path
+		id := path.KeyPolicy + "\x00" + path.Bucket + "\x00" + path.Spec + "\x00" + path.Path
+		entry, ok := merged[id]
+		if !ok {
+			entry = &MergedSubdocPath{Bucket: path.Bucket, Spec: path.Spec, Path: path.Path, KeyPolicy: path.KeyPolicy,
+				Statuses: make(map[string]uint64)}
+			merged[id] = entry
+		}
+		entry.add(path)
+		spec, ok := specs[path.Spec]
+		if !ok {
+			spec = &MergedSubdocPath{Spec: path.Spec, Statuses: make(map[string]uint64)}
+			specs[path.Spec] = spec
+		}
+		spec.add(path)
+	}
+	paths := []*MergedSubdocPath{}
+	for _, entry := range merged {
+		entry.MeanLatency = float64(entry.Latency) / float64(entry.Count)
+		paths = append(paths, entry)
+	}
+	for _, spec := range specs {
+		spec.MeanLatency = float64(spec.Latency) / float64(spec.Count)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		switch by {
+		case "latency":
+			return paths[i].Latency > paths[j].Latency
+		case "failures":
+			return paths[i].Failures > paths[j].Failures
+		}
+		return paths[i].Count > paths[j].Count
+	})
+	if filter.Offset >= len(paths) {
+		paths = []*MergedSubdocPath{}
+	} else {
+		paths = paths[filter.Offset:]
+	}
+	if len(paths) > filter.Limit {
+		paths = paths[:filter.Limit]
+	}
+	c.writeJson(w, http.StatusOK, map[string]interface{}{
+		"from":  filter.From,
+		"to":    filter.To,
+		"by":    by,
+		"paths": paths,
+		"specs": specs,
+	})
+}
+
+// tlsHandler lists the connections the agents saw on the TLS port, with their record-level timing
+// and whether they could be decrypted.
+func (c *Coordinator) tlsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	connections, err := c.store.QueryTLSConnections(filter)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	c.writePage(w, filter, "connections", connections, len(connections))
+}
+
+// tlsPercentilesHandler returns the percentiles of the TLS exchange latencies, overall and per
+// agent. They are estimated from record timing and never mixed with the parsed operations.
+func (c *Coordinator) tlsPercentilesHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	stored, err := c.store.QueryTLSHistograms(filter.From, filter.To)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	histograms := newLatencyHistograms()
+	for _, histogram := range stored {
+		if filter.Agent == "" || histogram.Agent == filter.Agent {
+			histograms.merge(histogram.Agent, "", histogram.Histogram)
+		}
+	}
+
+	agents := make(map[string]*LatencyStats)
+	for agent, histogram := range histograms.agents {
+		agents[agent] = newLatencyStats(histogram)
+	}
+	c.writeJson(w, http.StatusOK, map[string]interface{}{
+		"from":    filter.From,
+		"to":      filter.To,
+		"overall": newLatencyStats(histograms.overall),
+		"agents":  agents,
+	})
+}
+
+// valueSizesHandler returns the distribution of the value sizes overall and per opcode, on the wire
+// and uncompressed, along with the compression ratio. Only the agent and opcode filters apply.
+func (c *Coordinator) valueSizesHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	stored, err := c.store.QueryValueSizeHistograms(filter.From, filter.To)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	wire, uncompressed := newValueSizeHistogram(), newValueSizeHistogram()
+	opcodeWire := make(map[string]*hdrhistogram.Histogram)
+	opcodeUncompressed := make(map[string]*hdrhistogram.Histogram)
+	for _, histogram := range stored {
+		if (filter.Agent != "" && histogram.Agent != filter.Agent) || (filter.Opcode != "" && histogram.Opcode != filter.Opcode) {
+			continue
+		}
+		if _, ok := opcodeWire[histogram.Opcode]; !ok {
+			opcodeWire[histogram.Opcode] = newValueSizeHistogram()
+			opcodeUncompressed[histogram.Opcode] = newValueSizeHistogram()
+		}
+		wire.Merge(histogram.Wire)
+		uncompressed.Merge(histogram.Uncompressed)
+		opcodeWire[histogram.Opcode].Merge(histogram.Wire)
+		opcodeUncompressed[histogram.Opcode].Merge(histogram.Uncompressed)
+	}
+
+	opcodes := make(map[string]*ValueSizeStats)
+	for opcode, histogram := range opcodeWire {
+		opcodes[opcode] = newValueSizeStats(histogram, opcodeUncompressed[opcode])
+	}
+	c.writeJson(w, http.StatusOK, map[string]interface{}{
+		"from":    filter.From,
+		"to":      filter.To,
+		"overall": newValueSizeStats(wire, uncompressed),
+		"opcodes": opcodes,
+	})
+}
+
+// dcpHandler lists the DCP connections the agents saw with their streams and flow control.
+func (c *Coordinator) dcpHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	connections, err := c.store.QueryDCPConnections(filter)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	c.writePage(w, filter, "connections", connections, len(connections))
+}
+
+// ClustermapTimeline is the cluster maps pushed on one client connection, in the order they were captured.
+type ClustermapTimeline struct {
+	Agent         string                    `json:"agent"`
+	Client        string                    `json:"client"`
+	Server        string                    `json:"server"`
+	Notifications []*ClustermapNotification `json:"notifications"`
+}
+
+// clustermapHandler groups the pushed cluster maps per connection, to line topology changes up with
+// the latency of the operations around them.
+func (c *Coordinator) clustermapHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	notifications, err := c.store.QueryClustermapNotifications(filter)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	timelines := []*ClustermapTimeline{}
+	byConnection := map[string]*ClustermapTimeline{}
+	for _, notification := range notifications {
+		id := notification.Agent + "|" + notification.Client + "|" + notification.Server
+		timeline, ok := byConnection[id]
+		if !ok {
+			timeline = &ClustermapTimeline{
+				Agent:  notification.Agent,
+				Client: notification.Client,
+				Server: notification.Server,
+			}
+			byConnection[id] = timeline
+			timelines = append(timelines, timeline)
+		}
+		timeline.Notifications = append(timeline.Notifications, notification)
+	}
+	c.writeJson(w, http.StatusOK, map[string]interface{}{
+		"from":        filter.From,
+		"to":          filter.To,
+		"connections": timelines,
+	})
+}
+
+// clientHost leaves the port out of a client address, a client spreads its operations over several
+// connections.
+func clientHost(client string) string {
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		return host
+	}
+	return client
+}
+
+// notMyVbucketHandler counts NOT_MY_VBUCKET responses per client and vbucket, and lays out the
+// periods each client spent retrying on a stale cluster map during rebalances.
+func (c *Coordinator) notMyVbucketHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ops, err := c.store.QueryNotMyVbuckets(filter)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	clients := []*ClientNotMyVbuckets{}
+	byClient := map[string]*ClientNotMyVbuckets{}
+	timeline := []*StaleTopology{}
+	current := map[string]*StaleTopology{}
+	// ops come sorted by timestamp
+	for _, op := range ops {
+		host := clientHost(op.Client)
+		client, ok := byClient[host]
+		if !ok {
+			client = &ClientNotMyVbuckets{Client: host, vbuckets: map[uint32]*VbucketCount{}}
+			byClient[host] = client
+			clients = append(clients, client)
+		}
+		vbucket, ok := client.vbuckets[op.Vbucket]
+		if !ok {
+			vbucket = &VbucketCount{Vbucket: op.Vbucket}
+			client.vbuckets[op.Vbucket] = vbucket
+			client.Vbuckets = append(client.Vbuckets, vbucket)
+		}
+		client.Count++
+		vbucket.Count++
+
+		id := host + "|" + op.Bucket
+		stale, ok := current[id]
+		if !ok || op.Timestamp-stale.End > STALE_TOPOLOGY_GAP {
+			stale = &StaleTopology{
+				Client:        host,
+				Bucket:        op.Bucket,
+				Start:         op.Timestamp,
+				FirstRevision: op.Revision,
+				vbuckets:      map[uint32]bool{},
+			}
+			current[id] = stale
+			timeline = append(timeline, stale)
+		}
+		stale.End = op.Timestamp
+		stale.Duration = stale.End - stale.Start
+		stale.Count++
+		stale.vbuckets[op.Vbucket] = true
+		stale.Vbuckets = len(stale.vbuckets)
+		// servers leave the map out when the client already has it
+		if op.Revision != 0 {
+			if stale.FirstRevision == 0 {
+				stale.FirstRevision = op.Revision
+			}
+			stale.LastRevision = op.Revision
+		}
+		if op.Attempt > 1 {
+			client.Retries++
+			stale.Retries++
+		}
+	}
+	sort.SliceStable(clients, func(i, j int) bool {
+		return clients[i].Count > clients[j].Count
+	})
+	for _, client := range clients {
+		sort.SliceStable(client.Vbuckets, func(i, j int) bool {
+			return client.Vbuckets[i].Count > client.Vbuckets[j].Count
+		})
+	}
+	c.writeJson(w, http.StatusOK, map[string]interface{}{
+		"from":     filter.From,
+		"to":       filter.To,
+		"count":    len(ops),
+		"clients":  clients,
+		"timeline": timeline,
+	})
+}
+
+// queryHTTPRequests returns the requests of the range to the service asked for, if any.
+func (c *Coordinator) queryHTTPRequests(r *http.Request, filter *OperationFilter) ([]*HTTPRequest, error) {
+	requests, err := c.store.QueryHTTPRequests(filter)
+	if err != nil {
+		return nil, err
+	}
+	service := r.URL.Query().Get("service")
+	if service == "" {
+		return requests, nil
+	}
+	var selected []*HTTPRequest
+	for _, request := range requests {
+		if request.Service == service {
+			selected = append(selected, request)
+		}
+	}
+	return selected, nil
+}
+
+// httpHandler breaks the requests to the HTTP services down by method and path template, with the
+// statuses they got and their latency percentiles.
+func (c *Coordinator) httpHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	requests, err := c.queryHTTPRequests(r, filter)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	merged := make(map[string]*HTTPPath)
+	paths := []*HTTPPath{}
+	for _, request := range requests {
+		id := request.Service + "\x00" + request.Method + "\x00" + request.Path
+		path, ok := merged[id]
+		if !ok {
+			path = &HTTPPath{Service: request.Service, Method: request.Method, Path: request.Path,
+				Statuses: make(map[string]uint64), latency: newHTTPLatencyHistogram(), firstByte: newHTTPLatencyHistogram()}
+			merged[id] = path
+			paths = append(paths, path)
+		}
+		path.Count++
+		path.Statuses[strconv.Itoa(request.Status)]++
+		recordHTTPLatency(path.latency, request.Latency)
+		recordHTTPLatency(path.firstByte, request.FirstByte)
+	}
+	for _, path := range paths {
+		path.Latency = newLatencyStats(path.latency)
+		path.FirstByte = newLatencyStats(path.firstByte)
+	}
+	sort.SliceStable(paths, func(i, j int) bool {
+		return paths[i].Count > paths[j].Count
+	})
+	c.writeJson(w, http.StatusOK, map[string]interface{}{
+		"from":  filter.From,
+		"to":    filter.To,
+		"paths": paths,
+	})
+}
+
+// httpQueriesHandler breaks the query and analytics requests down by statement, by cumulative
+// latency or with by=count by count, to find the statements behind the slowest requests.
+func (c *Coordinator) httpQueriesHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if r.URL.Query().Get("limit") == "" {
+		filter.Limit = DEFAULT_HOT_KEYS
+	}
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = "latency"
+	} else if by != "count" && by != "latency" {
+		c.writeError(w, http.StatusBadRequest, fmt.Errorf("by must be count or latency"))
+		return
+	}
+	requests, err := c.queryHTTPRequests(r, filter)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	merged := make(map[string]*HTTPStatement)
+	statements := []*HTTPStatement{}
+	// requests come sorted by timestamp, so the latest client_context_ids are the last ones
+	for _, request := range requests {
+		if request.Statement == "" {
+			continue
+		}
+		// statements reported under different policies can not be the same statement
+		id := request.StatementPolicy + "\x00" + request.Service + "\x00" + request.Statement
+		statement, ok := merged[id]
+		if !ok {
+			statement = &HTTPStatement{Service: request.Service, Statement: request.Statement,
+				StatementPolicy: request.StatementPolicy, ClientContextIds: []string{}, latency: newHTTPLatencyHistogram()}
+			merged[id] = statement
+			statements = append(statements, statement)
+		}
+		statement.Count++
+		statement.TotalLatency += request.Latency
+		recordHTTPLatency(statement.latency, request.Latency)
+		if request.Status >= http.StatusBadRequest {
+			statement.Failures++
+		}
+		if request.ClientContextId != "" {
+			statement.ClientContextIds = append(statement.ClientContextIds, request.ClientContextId)
+			if len(statement.ClientContextIds) > MAX_CLIENT_CONTEXT_IDS {
+				statement.ClientContextIds = statement.ClientContextIds[1:]
+			}
+		}
+	}
+	for _, statement := range statements {
+		statement.Latency = newLatencyStats(statement.latency)
+	}
+	sort.SliceStable(statements, func(i, j int) bool {
+		if by == "count" {
+			return statements[i].Count > statements[j].Count
+		}
+		return statements[i].TotalLatency > statements[j].TotalLatency
+	})
+	if filter.Offset >= len(statements) {
+		statements = []*HTTPStatement{}
+	} else {
+		statements = statements[filter.Offset:]
+	}
+	if len(statements) > filter.Limit {
+		statements = statements[:filter.Limit]
+	}
+	c.writeJson(w, http.StatusOK, map[string]interface{}{
+		"from":       filter.From,
+		"to":         filter.To,
+		"by":         by,
+		"statements": statements,
+	})
+}
+
+// bootstrapPaths are those a client starts from when it has no config yet, configPaths those
+// answered with a bucket config.
+var bootstrapPaths = map[string]bool{
+	"/pools":                          true,
+	"/pools/default":                  true,
+	"/pools/default/b/{bucket}":       true,
+	"/pools/default/buckets/{bucket}": true,
+}
+
+var configPaths = map[string]bool{
+	"/pools/default/b/{bucket}":       true,
+	"/pools/default/buckets/{bucket}": true,
+}
+
+// clusterManagerEvent is a request to the cluster manager or a config received from it, in the order
+// bootstraps are replayed.
+type clusterManagerEvent struct {
+	timestamp int64
+	client    string
+	path      string
+	config    bool
+}
+
+// clusterManagerHandler reports the streaming config requests with the interval between pushes, and
+// per client host how long bootstrapping took and the peak request rate, to diagnose slow application
+// startup and config storms.
+func (c *Coordinator) clusterManagerHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		c.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	requests, err := c.store.QueryHTTPRequests(filter)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	configStreams, err := c.store.QueryConfigStreams(filter)
+	if err != nil {
+		c.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	merged := make(map[string]*MergedConfigStream)
+	streams := []*MergedConfigStream{}
+	var events []*clusterManagerEvent
+	for _, configStream := range configStreams {
+		id := configStream.Agent + "|" + configStream.Client + "|" + configStream.Server
+		stream, ok := merged[id]
+		if !ok {
+			stream = &MergedConfigStream{Agent: configStream.Agent, Client: configStream.Client,
+				Server: configStream.Server, Start: configStream.Start}
+			merged[id] = stream
+			streams = append(streams, stream)
+		}
+		// streams resumed in later windows do not know their path
+		if configStream.Path != "" {
+			stream.Path = configStream.Path
+		}
+		if configStream.Configs == 0 {
+			continue
+		}
+		if stream.FirstConfig == 0 {
+			stream.FirstConfig = configStream.FirstConfig
+		}
+		stream.LastConfig = configStream.LastConfig
+		stream.Configs += configStream.Configs
+		stream.Bytes += configStream.Bytes
+		if configStream.MinInterval > 0 && (stream.MinInterval == 0 || configStream.MinInterval < stream.MinInterval) {
+			stream.MinInterval = configStream.MinInterval
+		}
+		if configStream.MaxInterval > stream.MaxInterval {
+			stream.MaxInterval = configStream.MaxInterval
+		}
+		if !configStream.Resumed {
+			events = append(events, &clusterManagerEvent{timestamp: configStream.FirstConfig,
+				client: clientHost(configStream.Client), config: true})
+		}
+	}
+	for _, request := range requests {
+		if request.Service != CLUSTER_MANAGER_SERVICE {
+			continue
+		}
+		events = append(events, &clusterManagerEvent{timestamp: request.Timestamp,
+			client: clientHost(request.Client), path: request.Path})
+		if configPaths[request.Path] && request.Status == http.StatusOK {
+			events = append(events, &clusterManagerEvent{timestamp: request.Timestamp + request.Latency/1000,
+				client: clientHost(request.Client), config: true})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].timestamp < events[j].timestamp
+	})
+
+	byClient := make(map[string]*ClientClusterManager)
+	clients := []*ClientClusterManager{}
+	clientOf := func(host string) *ClientClusterManager {
+		client, ok := byClient[host]
+		if !ok {
+			client = &ClientClusterManager{Client: host, Bootstraps: []*Bootstrap{}, perSecond: make(map[int64]int)}
+			byClient[host] = client
+			clients = append(clients, client)
+		}
+		return client
+	}
+	for _, stream := range streams {
+		client := clientOf(clientHost(stream.Client))
+		client.Streams++
+		client.Configs += stream.Configs
+	}
+	for _, event := range events {
+		client := clientOf(event.client)
+		if event.config {
+			if client.bootstrap != nil {
+				client.bootstrap.FirstConfig = event.timestamp
+				client.bootstrap.Duration = event.timestamp - client.bootstrap.Start
+				client.bootstrap = nil
+			}
+			continue
+		}
+		client.Requests++
+		client.perSecond[event.timestamp/1000]++
+		if client.perSecond[event.timestamp/1000] > client.PeakRate {
+			client.PeakRate = client.perSecond[event.timestamp/1000]
+		}
+		if client.bootstrap == nil && bootstrapPaths[event.path] {
+			client.bootstrap = &Bootstrap{Start: event.timestamp}
+			client.Bootstraps = append(client.Bootstraps, client.bootstrap)
+		}
+		if client.bootstrap != nil {
+			client.bootstrap.Requests++
+		}
+	}
+	sort.SliceStable(clients, func(i, j int) bool {
+		return clients[i].Requests > clients[j].Requests
+	})
+	c.writeJson(w, http.StatusOK, map[string]interface{}{
+		"from":    filter.From,
+		"to":      filter.To,
+		"streams": streams,
+		"clients": clients,
+	})
+}
+
+func (c *Coordinator) registerApi(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/ops", c.opsHandler).Methods("GET")
+	api.HandleFunc("/percentiles", c.percentilesHandler).Methods("GET")
+	api.HandleFunc("/percentiles/windows", c.windowPercentilesHandler).Methods("GET")
+	api.HandleFunc("/histogram", c.histogramHandler).Methods("GET")
+	api.HandleFunc("/agents", c.agentsHandler).Methods("GET")
+	api.HandleFunc("/windows", c.windowsHandler).Methods("GET")
+	api.HandleFunc("/slowops", c.slowOpsHandler).Methods("GET")
+	api.HandleFunc("/hotkeys", c.hotKeysHandler).Methods("GET")
+	api.HandleFunc("/tls", c.tlsHandler).Methods("GET")
+	api.HandleFunc("/tls/percentiles", c.tlsPercentilesHandler).Methods("GET")
+	api.HandleFunc("/values", c.valueSizesHandler).Methods("GET")
+	api.HandleFunc("/subdoc", c.subdocHandler).Methods("GET")
+	api.HandleFunc("/dcp", c.dcpHandler).Methods("GET")
+	api.HandleFunc("/clustermap", c.clustermapHandler).Methods("GET")
+	api.HandleFunc("/nmvb", c.notMyVbucketHandler).Methods("GET")
+	api.HandleFunc("/http", c.httpHandler).Methods("GET")
+	api.HandleFunc("/http/queries", c.httpQueriesHandler).Methods("GET")
+	api.HandleFunc("/clustermanager", c.clusterManagerHandler).Methods("GET")
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2017 Couchbase, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"../../logger"
+	"../../tlsconfig"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Authorizer decides whether a caller may register or remove agents, over gRPC or the REST API,
+// and leaves an audit line for every attempt, whether it is allowed or not.
+type Authorizer struct {
+	token      string
+	agents     map[string]bool
+	clientAuth bool
+	logger     *logger.Logger
+}
+
+func NewAuthorizer(config *Config, logger *logger.Logger) (*Authorizer, error) {
+	if len(config.Auth.Agents) > 0 && !config.TLS.ClientAuth {
+		return nil, fmt.Errorf("Agent allow-list needs tls clientauth to identify agents")
+	}
+	if config.Auth.Token != "" && !config.TLS.Enabled() {
+		return nil, fmt.Errorf("Auth token needs tls, it would otherwise be visible on the network")
+	}
+	authorizer := &Authorizer{token: config.Auth.Token, agents: map[string]bool{}, clientAuth: config.TLS.ClientAuth,
+		logger: logger}
+	for _, agent := range config.Auth.Agents {
+		authorizer.agents[agent] = true
+	}
+	if authorizer.token == "" && !authorizer.clientAuth {
+		logger.Error("Authorization is off, anyone reaching the coordinator can register or remove agents")
+	}
+	return authorizer, nil
+}
+
+// authorize checks the names of the caller's verified client certificate against the allow-list
+// and the token it sent, as a gRPC status.
+func (a *Authorizer) authorize(names []string, authorization string) error {
+	if len(a.agents) > 0 {
+		allowed := false
+		for _, name := range names {
+			allowed = allowed || a.agents[name]
+		}
+		if !allowed {
+			return status.Errorf(codes.PermissionDenied, "agent %q is not allowed", strings.Join(names, ","))
+		}
+	}
+	if a.token != "" {
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			return status.Errorf(codes.Unauthenticated, "missing or invalid token")
+		}
+	}
+	return nil
+}
+
+func (a *Authorizer) audit(method string, address string, names []string, err error) {
+	identity := strings.Join(names, ",")
+	if err != nil {
+		a.logger.Error("Audit: denied %v from %v identity %q: %v", method, address, identity, err)
+		return
+	}
+	a.logger.Info("Audit: allowed %v from %v identity %q", method, address, identity)
+}
+
+// caller returns the address of a gRPC caller and the names of its verified client certificate.
+func caller(ctx context.Context) (string, []string) {
+	address := ""
+	var names []string
+	if p, ok := peer.FromContext(ctx); ok {
+		address = p.Addr.String()
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			names = tlsconfig.Identities(tlsInfo.State)
+		}
+	}
+	return address, names
+}
+
+func (a *Authorizer) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	address, names := caller(ctx)
+	authorization := ""
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		authorization = values[0]
+	}
+	err := a.authorize(names, authorization)
+	a.audit(info.FullMethod, address, names, err)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// bindAgent lets an agent register or deregister only itself: with tls clientauth the host of
+// agentAddress has to be one of the names of the caller's certificate, so an allowed agent can not
+// add or remove the others.
+func (a *Authorizer) bindAgent(ctx context.Context, method string, agentAddress string) error {
+	if !a.clientAuth {
+		return nil
+	}
+	address, names := caller(ctx)
+	host, _, err := net.SplitHostPort(agentAddress)
+	if err != nil {
+		host = agentAddress
+	}
+	for _, name := range names {
+		if strings.EqualFold(name, host) {
+			return nil
+		}
+	}
+	err = status.Errorf(codes.PermissionDenied, "agent %q can not act for %v", strings.Join(names, ","), agentAddress)
+	a.audit(method, address, names, err)
+	return err
+}
+
+// Handler authorizes a REST call the same way, the client certificate is optional on the REST port
+// and only required by the allow-list.
+func (a *Authorizer) Handler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var names []string
+		if r.TLS != nil {
+			names = tlsconfig.Identities(*r.TLS)
+		}
+		err := a.authorize(names, r.Header.Get("Authorization"))
+		a.audit(r.Method+" "+r.URL.Path, r.RemoteAddr, names, err)
+		if err != nil {
+			denied, _ := status.FromError(err)
+			code := http.StatusUnauthorized
+			if denied.Code() == codes.PermissionDenied {
+				code = http.StatusForbidden
+			}
+			http.Error(w, denied.Message(), code)
+			return
+		}
+		handler(w, r)
+	}
+}
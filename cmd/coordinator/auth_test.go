@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2017 Couchbase, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package main
+
+import (
+	"../../logger"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"net"
+	"testing"
+)
+
+// agentContext is the context of a call from an agent whose verified certificate is issued to names.
+func agentContext(names ...string) context.Context {
+	cert := &x509.Certificate{DNSNames: names}
+	state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr:     &net.TCPAddr{IP: net.IP{10, 0, 0, 1}, Port: 40000},
+		AuthInfo: credentials.TLSInfo{State: state},
+	})
+}
+
+func TestBindAgent(t *testing.T) {
+	l := &logger.Logger{}
+	l.Init("", logger.ERRORLEVEL)
+	tests := []struct {
+		name       string
+		clientAuth bool
+		ctx        context.Context
+		address    string
+		allowed    bool
+	}{
+		{"own address", true, agentContext("agent1.example.com"), "agent1.example.com:11000", true},
+		{"own address without port", true, agentContext("agent1.example.com"), "AGENT1.example.com", true},
+		{"other agent", true, agentContext("agent1.example.com"), "agent2.example.com:11000", false},
+		{"no certificate", true, context.Background(), "agent1.example.com:11000", false},
+		{"no clientauth", false, context.Background(), "agent2.example.com:11000", true},
+	}
+	for _, test := range tests {
+		authorizer := &Authorizer{agents: map[string]bool{}, clientAuth: test.clientAuth, logger: l}
+		err := authorizer.bindAgent(test.ctx, "RegisterAgent", test.address)
+		if (err == nil) != test.allowed {
+			t.Errorf("%v: expected allowed %v, got %v", test.name, test.allowed, err)
+		}
+	}
+}
@@ -16,27 +16,112 @@
 
 package main
 
+import (
+	"../../tlsconfig"
+)
+
 type Config struct {
-	Capture  CaptureConfig  `yaml:"capture"`
-	Agents   []string       `yaml:"agents"`
-	Port     int            `yaml:"port"`
-	History  ResultsHistory `yaml:"history"`
-	RestPort int            `yaml:"restport"`
-	logging  LoggingConfig  `yaml:"log"`
+	Capture   CaptureConfig    `yaml:"capture"`
+	Agents    []string         `yaml:"agents"`
+	Port      int              `yaml:"port"`
+	History   ResultsHistory   `yaml:"history"`
+	RestPort  int              `yaml:"restport"`
+	Retry     RetryConfig      `yaml:"retry"`
+	Keepalive KeepaliveConfig  `yaml:"keepalive"`
+	Discovery DiscoveryConfig  `yaml:"discovery"`
+	TLS       tlsconfig.Config `yaml:"tls"`
+	Auth      AuthConfig       `yaml:"auth"`
+	logging   LoggingConfig    `yaml:"log"`
 }
 
 type CaptureConfig struct {
-	Timeout  int `yaml:"timeout"`
+	Timeout int `yaml:"timeout"`
+	// Deadline bounds how long a window waits on all the agents together, retries included
+	Deadline int `yaml:"deadline"`
 	Period   int `yaml:"period"`
 	Interval int `yaml:"interval"`
 }
 
+type RetryConfig struct {
+	Attempts   int `yaml:"attempts"`
+	Backoff    int `yaml:"backoff"`
+	MaxBackoff int `yaml:"maxbackoff"`
+	LostAfter  int `yaml:"lostafter"`
+}
+
+// MIN_KEEPALIVE_TIME is the shortest ping interval agents accept, in seconds. Pinging more often
+// makes them close the connection with too_many_pings.
+const MIN_KEEPALIVE_TIME = 5
+
+// KeepaliveConfig sets in seconds how often agents are pinged and how long a ping may go
+// unanswered, Time is raised to MIN_KEEPALIVE_TIME.
+type KeepaliveConfig struct {
+	Time    int `yaml:"time"`
+	Timeout int `yaml:"timeout"`
+}
+
+type DiscoveryConfig struct {
+	Endpoint  string `yaml:"endpoint"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+	AgentPort int    `yaml:"agentport"`
+	Interval  int    `yaml:"interval"`
+}
+
+// AuthConfig holds the token shared with the agents: it is sent to them on every call and required
+// from whoever registers or removes an agent. With Agents, only callers whose client certificate is
+// issued to one of the names may do so.
+type AuthConfig struct {
+	Token  string   `yaml:"token"`
+	Agents []string `yaml:"agents"`
+}
+
 type ResultsHistory struct {
-	FileName string `yaml:"file"`
-	Period   int    `yaml:"period"`
+	Backend    string `yaml:"backend"`
+	FileName   string `yaml:"file"`
+	Period     int    `yaml:"period"`
+	Downsample int    `yaml:"downsample"`
 }
 
 type LoggingConfig struct {
 	logLevel string `yaml:"level"`
 	file     string `yaml:"file"`
 }
+
+func (config *Config) setDefaults() {
+	if config.Capture.Timeout <= 0 {
+		config.Capture.Timeout = 5000
+	}
+	if config.Capture.Deadline <= 0 {
+		config.Capture.Deadline = config.Capture.Timeout
+	}
+	if config.Retry.Attempts < 0 {
+		config.Retry.Attempts = 0
+	}
+	if config.Retry.Backoff <= 0 {
+		config.Retry.Backoff = 100
+	}
+	if config.Retry.MaxBackoff < config.Retry.Backoff {
+		config.Retry.MaxBackoff = config.Retry.Backoff
+	}
+	if config.Retry.LostAfter <= 0 {
+		config.Retry.LostAfter = 3
+	}
+	if config.Keepalive.Time <= 0 {
+		config.Keepalive.Time = 10
+	} else if config.Keepalive.Time < MIN_KEEPALIVE_TIME {
+		config.Keepalive.Time = MIN_KEEPALIVE_TIME
+	}
+	if config.Keepalive.Timeout <= 0 {
+		config.Keepalive.Timeout = 5
+	}
+	if config.History.Period <= 0 {
+		config.History.Period = 24 * 60
+	}
+	if config.Discovery.AgentPort <= 0 {
+		config.Discovery.AgentPort = 3612
+	}
+	if config.Discovery.Interval <= 0 {
+		config.Discovery.Interval = 30
+	}
+}
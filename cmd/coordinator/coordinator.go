@@ -21,17 +21,18 @@ import (
 	pb "../../rpc"
 	"bytes"
 	"context"
-	"database/sql"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"github.com/codahale/hdrhistogram"
 	"github.com/gorilla/mux"
-	_ "github.com/mattn/go-sqlite3"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"strconv"
 	"sync"
 	"syscall"
@@ -43,20 +44,47 @@ type AgentsConfig struct {
 }
 
 type Coordinator struct {
-	config             *Config
-	agentsInfo         map[string]*AgentInfo
-	db                 *sql.DB
-	insertStatementStr string
-	histogram          *hdrhistogram.Histogram
-	logger             *logger.Logger
+	config         *Config
+	agentsMutex    *sync.Mutex
+	agentsInfo     map[string]*AgentInfo
+	nextAgentIndex int
+	store          Store
+	metrics        *Metrics
+	dialOptions    []grpc.DialOption
+	serverOptions  []grpc.ServerOption
+	restTLS        *tls.Config
+	authorizer     *Authorizer
+	logger         *logger.Logger
 }
 
 type AgentInfo struct {
+	mutex    *sync.Mutex
 	index    int
 	hostname string
 	conn     *grpc.ClientConn
 	client   pb.AgentServiceClient
-	results  map[string]*pb.AgentResultsResponse_CaptureInfo
+	state    AgentState
+	failures int
+	removed  bool
+	response *pb.AgentResultsResponse
+}
+
+// AgentResults are the results one agent returned for a capture window.
+type AgentResults struct {
+	hostname        string
+	results         map[string]*pb.AgentResultsResponse_CaptureInfo
+	packetsReceived uint64
+	packetsDropped  uint64
+	slowOps         []*pb.AgentResultsResponse_SlowOp
+	hotKeys         []*pb.AgentResultsResponse_HotKey
+	tlsConnections  []*pb.AgentResultsResponse_TlsConnection
+	dcpConnections  []*pb.AgentResultsResponse_DcpConnection
+	serverPush      []*pb.AgentResultsResponse_ServerPushConnection
+	notMyVbuckets   []*pb.AgentResultsResponse_NotMyVbucket
+	httpRequests    []*pb.AgentResultsResponse_HttpRequest
+	statementPolicy string
+	configStreams   []*pb.AgentResultsResponse_ConfigStream
+	keyPolicy       string
 }
 
 type LatencyInfo struct {
@@ -80,8 +108,8 @@ func (c *Coordinator) homeHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		var agents []string
-		for agent, _ := range c.agentsInfo {
-			agents = append(agents, agent)
+		for _, agentInfo := range c.orderedAgents() {
+			agents = append(agents, agentInfo.hostname)
 		}
 
 		agentsJson, err := json.Marshal(agents)
@@ -90,12 +118,18 @@ func (c *Coordinator) homeHandler(w http.ResponseWriter, r *http.Request) {
 			c.shutdown()
 		}
 
+		maxLatency, err := c.getMaxLatency()
+		if err != nil {
+			c.logger.Error("Unable to read the latency histograms from db due to %v", err)
+			os.Exit(1)
+		}
+
 		buffer.WriteString("<script type=\"text/javascript\">")
 		buffer.WriteString("var data=")
 		buffer.WriteString(jsonStr)
 		buffer.WriteString(";")
 		buffer.WriteString("var yMax=")
-		buffer.WriteString(strconv.FormatInt(c.getMaxLatency(), 10))
+		buffer.WriteString(strconv.FormatInt(maxLatency, 10))
 		buffer.WriteString(";")
 		buffer.WriteString("var agents=")
 		buffer.WriteString(string(agentsJson))
@@ -109,13 +143,26 @@ func (c *Coordinator) homeHandler(w http.ResponseWriter, r *http.Request) {
 func (c *Coordinator) startRestServer() {
 	r := mux.NewRouter()
 	r.HandleFunc("/", c.homeHandler)
+	r.HandleFunc("/slowops", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "./graphplotter/slowops.html")
+	})
+	r.HandleFunc("/agents", c.authorizer.Handler(c.addAgentHandler)).Methods("POST")
+	r.HandleFunc("/agents/{address}", c.authorizer.Handler(c.removeAgentHandler)).Methods("DELETE")
+	c.registerApi(r)
+	r.Handle("/metrics", c.metrics.handler())
 	http.Handle("/", r)
 
 	srv := &http.Server{
-		Handler: r,
-		Addr:    fmt.Sprint(":", c.config.RestPort),
+		Handler:   r,
+		Addr:      fmt.Sprint(":", c.config.RestPort),
+		TLSConfig: c.restTLS,
+	}
+	var err error
+	if c.restTLS != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
 	}
-	err := srv.ListenAndServe()
 	if err != nil {
 		c.logger.Error("%v", err)
 		c.shutdown()
@@ -123,200 +170,386 @@ func (c *Coordinator) startRestServer() {
 }
 
 func (c *Coordinator) setupStore() {
-	file := c.config.History.FileName
-	os.Remove(file)
-	db, err := sql.Open("sqlite3", file)
-	if err != nil {
-		c.logger.Error("%v", err)
-		c.shutdown()
-	}
-
-	var cols string
-	for i := 0; i < len(c.agentsInfo); i++ {
-		agent := c.agentsInfo["agent"+strconv.Itoa(i)]
-		cols += fmt.Sprint("agent", agent.index)
-		cols += " text"
-		if agent.index < (len(c.agentsInfo) - 1) {
-			cols += ", "
-		}
-	}
-
-	sqlStmt := fmt.Sprintf("create table CaptureResults (opaque_streamId text not null, timestamp integer, %v); delete from CaptureResults;", cols)
-	_, err = db.Exec(sqlStmt)
+	store, err := newStore(&c.config.History)
 	if err != nil {
-		c.logger.Error("%q: %s\n", err, sqlStmt)
+		c.logger.Error("Unable to open the %v history store %v due to %v", c.config.History.Backend, c.config.History.FileName, err)
 		c.shutdown()
 	}
-
-	var fieldStr, argsStr string
-	for i := 0; i < len(c.agentsInfo); i++ {
-		agent := c.agentsInfo["agent"+strconv.Itoa(i)]
-		fieldStr += fmt.Sprint("agent", agent.index)
-		argsStr += "?"
-		if agent.index < (len(c.agentsInfo) - 1) {
-			fieldStr += ", "
-			argsStr += ", "
-		}
-	}
-
-	statementStr := fmt.Sprintf("insert into CaptureResults(opaque_streamId, timestamp, %v) values(?, ?, %v)", fieldStr, argsStr)
-	c.insertStatementStr = statementStr
-	c.db = db
+	c.store = store
 }
 
+// storeFlusher applies the rolling retention: capture windows older than the history period are
+// deleted, and windows older than the downsample age lose their operations and keep only their
+// histograms.
 func (c *Coordinator) storeFlusher() {
-	currentTime := time.Now().UnixNano() / int64(time.Millisecond)
-	maxHistoryTime := currentTime + int64(c.config.History.Period*60)
 	for {
-		if currentTime < maxHistoryTime {
-			time.Sleep(time.Second * time.Duration(maxHistoryTime-currentTime))
+		now := time.Now().UnixNano() / int64(time.Millisecond)
+		expired := now - int64(c.config.History.Period)*60*1000
+		var downsampled int64
+		if c.config.History.Downsample > 0 {
+			downsampled = now - int64(c.config.History.Downsample)*60*1000
 		}
-		sqlStmt := `delete from CaptureResults;`
-		_, err := c.db.Exec(sqlStmt)
-		if err != nil {
-			c.logger.Error("Cannot execute %q: %s\n", err, sqlStmt)
+		if err := c.store.ApplyRetention(expired, downsampled); err != nil {
+			c.logger.Error("Unable to apply retention to the store %v", err)
 			c.shutdown()
 		}
-
+		time.Sleep(time.Minute)
 	}
 }
 
-func connectToAgent(hostName string) (*grpc.ClientConn, error) {
-	return grpc.Dial(hostName, grpc.WithInsecure())
+func (c *Coordinator) connectToAgent(hostName string, block bool) (*grpc.ClientConn, error) {
+	opts := append([]grpc.DialOption{
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                time.Duration(c.config.Keepalive.Time) * time.Second,
+			Timeout:             time.Duration(c.config.Keepalive.Timeout) * time.Second,
+			PermitWithoutStream: true,
+		}),
+	}, c.dialOptions...)
+	if !block {
+		return grpc.Dial(hostName, opts...)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.callTimeout())
+	defer cancel()
+	return grpc.DialContext(ctx, hostName, append(opts, grpc.WithBlock())...)
 }
 
 func (c *Coordinator) ConnectToAgents() {
-	for ii, hostName := range c.config.Agents {
+	for _, hostName := range c.config.Agents {
+		c.addAgent(hostName)
+	}
+}
 
-		conn, err := connectToAgent(hostName)
-		if err != nil {
-			c.logger.Error("Unable to connect to the Agent %v %v", err, hostName)
-			os.Exit(1)
-		}
-		c.logger.Info("Connected to the agent %v", hostName)
-		c.agentsInfo["agent"+strconv.Itoa(ii)] = &AgentInfo{
-			index:    ii,
-			hostname: hostName,
-			conn:     conn,
-			client:   pb.NewAgentServiceClient(conn),
-		}
+// orderedAgents returns a snapshot of the registered agents sorted by the order they were added in.
+func (c *Coordinator) orderedAgents() []*AgentInfo {
+	var agentsInfo []*AgentInfo
+	c.agentsMutex.Lock()
+	for _, agentInfo := range c.agentsInfo {
+		agentsInfo = append(agentsInfo, agentInfo)
 	}
+	c.agentsMutex.Unlock()
+	sort.Slice(agentsInfo, func(i, j int) bool {
+		return agentsInfo[i].index < agentsInfo[j].index
+	})
+	return agentsInfo
 }
 
-func (c *Coordinator) startCapture(wg *sync.WaitGroup, agentInfo *AgentInfo) {
-	_, err := agentInfo.client.CaptureSignal(context.Background(), &pb.CoordinatorCaptureRequest{})
+func (c *Coordinator) startCapture(ctx context.Context, agentInfo *AgentInfo) {
+	if agentInfo.getState() == AGENT_LOST {
+		return
+	}
+	err := c.withRetry(ctx, agentInfo, "CaptureSignal", func(ctx context.Context) error {
+		_, err := agentInfo.getClient().CaptureSignal(ctx, &pb.CoordinatorCaptureRequest{})
+		return err
+	})
 	if err != nil {
 		c.logger.Error("Unable to start capture on agent %s due to %v", agentInfo.hostname, err)
-		c.shutdown()
+		c.markFailed(agentInfo, err)
 	}
-	wg.Done()
 }
 
 func (c *Coordinator) StartCapture() {
-	wg := sync.WaitGroup{}
-	wg.Add(len(c.agentsInfo))
-	for _, agent := range c.agentsInfo {
-		go c.startCapture(&wg, agent)
-	}
-	wg.Wait()
+	c.fanOut(c.orderedAgents(), c.startCapture)
 }
 
-func (c *Coordinator) mergeAndStore() {
-	tx, err := c.db.Begin()
-	if err != nil {
-		c.logger.Error("Unable start tx in store %v", err)
-		c.shutdown()
+func (c *Coordinator) mergeAndStore(start time.Time, end time.Time, windowResults []*AgentResults) {
+	window := &Window{
+		Start:       start.UnixNano() / int64(time.Millisecond),
+		End:         end.UnixNano() / int64(time.Millisecond),
+		KeyPolicies: make(map[string]string),
 	}
 
-	stmt, err := tx.Prepare(c.insertStatementStr)
-
-	if err != nil {
-		c.logger.Error("Unable to prepare statement for store %v %v", c.insertStatementStr, err)
-		c.shutdown()
-	}
+	// Agents that failed this window have no operations, which shows up as a gap for that agent
+	// while the operations seen by the rest of the cluster are kept.
+	for _, agentResults := range windowResults {
+		window.KeyPolicies[agentResults.hostname] = agentResults.keyPolicy
+		histograms := make(map[string]*hdrhistogram.Histogram)
+		valueSizes := make(map[string]*ValueSizeHistogram)
+		subdocPaths := make(map[string]*SubdocPath)
+		for rowKey, row := range agentResults.results {
+			lat, _ := strconv.ParseInt(row.Oplatency, 10, 64)
+			histogram, ok := histograms[row.Opcode]
+			if !ok {
+				histogram = newLatencyHistogram()
+				histograms[row.Opcode] = histogram
+			}
+			recordLatency(histogram, lat)
+			c.metrics.observeOperation(agentResults.hostname, row.Opcode, row.Status, lat)
+			// misses and operations without a value would only pile up at 0
+			if row.ValueSize > 0 {
+				valueSize, ok := valueSizes[row.Opcode]
+				if !ok {
+					valueSize = &ValueSizeHistogram{
+						Start:        window.Start,
+						End:          window.End,
+						Agent:        agentResults.hostname,
+						Opcode:       row.Opcode,
+						Wire:         newValueSizeHistogram(),
+						Uncompressed: newValueSizeHistogram(),
+					}
+					valueSizes[row.Opcode] = valueSize
+					window.ValueSizes = append(window.ValueSizes, valueSize)
+				}
+				recordValueSize(valueSize.Wire, row.ValueSize)
+				// 0 when the snappy length of a compressed value could not be read
+				if row.UncompressedSize > 0 {
+					recordValueSize(valueSize.Uncompressed, row.UncompressedSize)
+				}
+			}
+			for _, spec := range row.Specs {
+				id := row.Bucket + "\x00" + spec.Opcode + "\x00" + spec.Path + "\x00" + spec.Status
+				path, ok := subdocPaths[id]
+				if !ok {
+					path = &SubdocPath{
+						Agent:     agentResults.hostname,
+						Bucket:    row.Bucket,
+						Spec:      spec.Opcode,
+						Path:      spec.Path,
+						Status:    spec.Status,
+						KeyPolicy: agentResults.keyPolicy,
+					}
+					subdocPaths[id] = path
+					window.SubdocPaths = append(window.SubdocPaths, path)
+				}
+				path.Count++
+				path.Latency += lat
+				if lat > path.MaxLatency {
+					path.MaxLatency = lat
+				}
+			}
 
-	var agentsInfo []*AgentInfo
-	for _, agentInfo := range c.agentsInfo {
-		agentsInfo = append(agentsInfo, agentInfo)
-	}
-	timestamp := time.Now().Unix() * 1000
-
-	for rowKey, row := range agentsInfo[0].results {
-		lat, _ := strconv.ParseInt(row.Oplatency, 10, 64)
-		c.histogram.RecordValue(lat)
-		var args []interface{}
-		args = append(args, rowKey)
-		args = append(args, timestamp)
-		args = append(args, row.Oplatency)
-		found := false
-		for i := 1; i < len(agentsInfo); i++ {
-			agent := agentsInfo[i]
-			if row := agent.results[rowKey]; row != nil {
-				args = append(args, row.Oplatency)
-				lat, _ := strconv.ParseInt(row.Oplatency, 10, 64)
-				c.histogram.RecordValue(lat)
-				found = true
+			window.Operations = append(window.Operations, &Operation{
+				Agent:            agentResults.hostname,
+				OpaqueStreamId:   rowKey,
+				Opcode:           row.Opcode,
+				Status:           row.Status,
+				Bucket:           row.Bucket,
+				Key:              row.Key,
+				Latency:          lat,
+				KeyPolicy:        agentResults.keyPolicy,
+				Datatype:         row.Datatype,
+				ValueSize:        row.ValueSize,
+				UncompressedSize: row.UncompressedSize,
+			})
+		}
+		for _, op := range agentResults.slowOps {
+			window.SlowOps = append(window.SlowOps, &SlowOp{
+				Timestamp:      op.Timestamp / int64(time.Millisecond),
+				Agent:          agentResults.hostname,
+				Client:         op.Client,
+				Server:         op.Server,
+				Opaque:         op.Opaque,
+				Opcode:         op.Opcode,
+				Status:         op.Status,
+				Vbucket:        op.Vbucket,
+				Bucket:         op.Bucket,
+				Key:            op.Key,
+				ValueSize:      op.ValueSize,
+				Latency:        op.Latency,
+				ServerDuration: op.ServerDuration,
+				PipelineDepth:  op.PipelineDepth,
+				KeyPolicy:      agentResults.keyPolicy,
+			})
+		}
+		for _, hotKey := range agentResults.hotKeys {
+			window.HotKeys = append(window.HotKeys, &HotKey{
+				Agent:     agentResults.hostname,
+				Bucket:    hotKey.Bucket,
+				Key:       hotKey.Key,
+				Count:     hotKey.Count,
+				Latency:   hotKey.Latency,
+				KeyPolicy: agentResults.keyPolicy,
+			})
+		}
+		// TLS exchanges are only estimated from record timing, they are kept out of the operations
+		var tlsHistogram *hdrhistogram.Histogram
+		for _, connection := range agentResults.tlsConnections {
+			window.TLS = append(window.TLS, &TLSConnection{
+				End:               window.End,
+				Agent:             agentResults.hostname,
+				Client:            connection.Client,
+				Server:            connection.Server,
+				Version:           connection.Version,
+				CipherSuite:       connection.CipherSuite,
+				Decryption:        connection.Decryption,
+				Records:           connection.Records,
+				Exchanges:         connection.Exchanges,
+				Latency:           connection.Latency,
+				MaxLatency:        connection.MaxLatency,
+				HandshakeDuration: connection.HandshakeDuration,
+				Resumed:           connection.Resumed,
+				Alerts:            connection.Alerts,
+				LastAlert:         connection.LastAlert,
+			})
+			for _, latency := range connection.ExchangeLatencies {
+				if tlsHistogram == nil {
+					tlsHistogram = newLatencyHistogram()
+				}
+				recordLatency(tlsHistogram, latency)
 			}
 		}
-		if !found {
-			break
+		if tlsHistogram != nil {
+			window.TLSHistograms = append(window.TLSHistograms, &AgentHistogram{
+				Start:     window.Start,
+				End:       window.End,
+				Agent:     agentResults.hostname,
+				Histogram: tlsHistogram,
+			})
 		}
-
-		_, err = stmt.Exec(args...)
-		if err != nil {
-			c.logger.Error("Error executing insert %v", err)
-			c.shutdown()
+		for _, connection := range agentResults.dcpConnections {
+			window.DCP = append(window.DCP, newDCPConnection(window.End, agentResults.hostname, connection))
+		}
+		for _, connection := range agentResults.serverPush {
+			for _, notification := range connection.Notifications {
+				window.Notifications = append(window.Notifications, &ClustermapNotification{
+					Timestamp: notification.Timestamp / int64(time.Millisecond),
+					Agent:     agentResults.hostname,
+					Client:    connection.Client,
+					Server:    connection.Server,
+					Bucket:    notification.Bucket,
+					Epoch:     notification.Epoch,
+					Revision:  notification.Revision,
+					Size:      notification.Size,
+				})
+			}
+		}
+		for _, op := range agentResults.notMyVbuckets {
+			window.NotMyVbuckets = append(window.NotMyVbuckets, &NotMyVbucket{
+				Timestamp: op.Timestamp / int64(time.Millisecond),
+				Agent:     agentResults.hostname,
+				Client:    op.Client,
+				Server:    op.Server,
+				Bucket:    op.Bucket,
+				Vbucket:   op.Vbucket,
+				Opcode:    op.Opcode,
+				Latency:   op.Latency,
+				Epoch:     op.Epoch,
+				Revision:  op.Revision,
+				MapSize:   op.MapSize,
+				Attempt:   op.Attempt,
+			})
+		}
+		for _, request := range agentResults.httpRequests {
+			window.HTTPRequests = append(window.HTTPRequests, &HTTPRequest{
+				Timestamp:       request.Timestamp / int64(time.Millisecond),
+				Agent:           agentResults.hostname,
+				Client:          request.Client,
+				Server:          request.Server,
+				Service:         request.Service,
+				Method:          request.Method,
+				Path:            request.Path,
+				Status:          int(request.Status),
+				Latency:         request.Latency,
+				FirstByte:       request.FirstByte,
+				RequestSize:     request.RequestSize,
+				ResponseSize:    request.ResponseSize,
+				Statement:       request.Statement,
+				ClientContextId: request.ClientContextId,
+				StatementPolicy: agentResults.statementPolicy,
+			})
+		}
+		for _, configStream := range agentResults.configStreams {
+			window.ConfigStreams = append(window.ConfigStreams, &ConfigStream{
+				Agent:       agentResults.hostname,
+				Client:      configStream.Client,
+				Server:      configStream.Server,
+				Path:        configStream.Path,
+				Resumed:     configStream.Resumed,
+				Start:       configStream.Start / int64(time.Millisecond),
+				FirstConfig: configStream.FirstConfig / int64(time.Millisecond),
+				LastConfig:  configStream.LastConfig / int64(time.Millisecond),
+				Configs:     configStream.Configs,
+				Bytes:       configStream.Bytes,
+				MinInterval: configStream.MinInterval,
+				MaxInterval: configStream.MaxInterval,
+			})
+		}
+		for opcode, histogram := range histograms {
+			window.Histograms = append(window.Histograms, &AgentHistogram{
+				Start:     window.Start,
+				End:       window.End,
+				Agent:     agentResults.hostname,
+				Opcode:    opcode,
+				Histogram: histogram,
+			})
 		}
 	}
 
-	for _, agentInfo := range agentsInfo {
-		agentInfo.results = nil
+	c.metrics.observeWindow(window, windowResults)
+
+	if err := c.store.WriteWindow(window); err != nil {
+		c.logger.Error("Unable to store capture window %v", err)
+		c.shutdown()
 	}
+}
 
-	tx.Commit()
+func newDCPConnection(end int64, agent string, connection *pb.AgentResultsResponse_DcpConnection) *DCPConnection {
+	dcp := &DCPConnection{
+		End:        end,
+		Agent:      agent,
+		Client:     connection.Client,
+		Server:     connection.Server,
+		Name:       connection.Name,
+		Producer:   connection.Producer,
+		BufferSize: connection.BufferSize,
+		MaxUnacked: connection.MaxUnacked,
+		Acked:      connection.Acked,
+		Stalls:     connection.Stalls,
+		StallTime:  connection.StallTime,
+		MaxStall:   connection.MaxStall,
+		Streams:    []*DCPStream{},
+	}
+	for _, stream := range connection.Streams {
+		dcpStream := &DCPStream{
+			Opaque:           stream.Opaque,
+			Vbucket:          stream.Vbucket,
+			Status:           stream.Status,
+			StartSeqno:       stream.StartSeqno,
+			EndSeqno:         stream.EndSeqno,
+			LastSeqno:        stream.LastSeqno,
+			Mutations:        stream.Mutations,
+			Deletions:        stream.Deletions,
+			Expirations:      stream.Expirations,
+			Bytes:            stream.Bytes,
+			Duration:         stream.Duration,
+			Snapshots:        stream.Snapshots,
+			MaxSnapshotItems: stream.MaxSnapshotItems,
+			MaxSnapshotBytes: stream.MaxSnapshotBytes,
+			End:              stream.End,
+		}
+		// a stream with a single message has no rate to speak of
+		if stream.Duration > 0 {
+			seconds := float64(stream.Duration) / float64(time.Second/time.Microsecond)
+			dcpStream.ItemsPerSecond = float64(stream.Mutations+stream.Deletions+stream.Expirations) / seconds
+			dcpStream.BytesPerSecond = float64(stream.Bytes) / seconds
+		}
+		dcp.Streams = append(dcp.Streams, dcpStream)
+	}
+	return dcp
 }
 
 func (c *Coordinator) getFullCaptureFromDb() (string, error) {
-	tx, err := c.db.Begin()
-	if err != nil {
-		c.logger.Error("Error on db tx %v", err)
-		os.Exit(1)
-	}
 	c.logger.Debug("Executing select query")
-	rows, err := tx.Query("select * from CaptureResults;")
-	defer rows.Close()
-	if err != nil {
-		c.logger.Error("Error executing select statement %v", err)
-		os.Exit(1)
-	}
-	columns, err := rows.Columns()
+	operations, err := c.store.QueryRange(&OperationFilter{To: time.Now().UnixNano() / int64(time.Millisecond)})
 	if err != nil {
 		return "", err
 	}
-	count := len(columns)
+
+	// Pivot the per agent operations back into one entry per operation with a latency per agent.
 	tableData := make([]map[string]interface{}, 0)
-	values := make([]interface{}, count)
-	valuePtrs := make([]interface{}, count)
-	for rows.Next() {
-		for i := 0; i < count; i++ {
-			valuePtrs[i] = &values[i]
-		}
-		rows.Scan(valuePtrs...)
-		entry := make(map[string]interface{})
-		for i, col := range columns {
-			var v interface{}
-			val := values[i]
-			b, ok := val.([]byte)
-			if ok {
-				v = string(b)
-			} else {
-				v = val
+	entries := make(map[string]map[string]interface{})
+	for _, op := range operations {
+		entryKey := op.OpaqueStreamId + strconv.FormatInt(op.Timestamp, 10)
+		entry, ok := entries[entryKey]
+		if !ok {
+			entry = map[string]interface{}{
+				"opaque_streamId": op.OpaqueStreamId,
+				"timestamp":       op.Timestamp,
 			}
-			entry[col] = v
+			entries[entryKey] = entry
+			tableData = append(tableData, entry)
 		}
-		tableData = append(tableData, entry)
+		entry[op.Agent] = op.Latency
 	}
-	tx.Commit()
 
 	jsonData, err := json.Marshal(tableData)
 	if err != nil {
@@ -326,53 +559,89 @@ func (c *Coordinator) getFullCaptureFromDb() (string, error) {
 	return string(jsonData), nil
 }
 
-func (c *Coordinator) getMaxLatency() int64 {
-	return c.histogram.Max()
+// getMaxLatency is the largest latency across the retained history, used to size the graph.
+func (c *Coordinator) getMaxLatency() (int64, error) {
+	histograms, err := c.store.QueryHistograms(0, nowInMillis())
+	if err != nil {
+		return 0, err
+	}
+	var max int64
+	for _, histogram := range histograms {
+		if histogram.Histogram.Max() > max {
+			max = histogram.Histogram.Max()
+		}
+	}
+	return max, nil
 }
 
-func (c *Coordinator) getResults(wg *sync.WaitGroup, agentInfo *AgentInfo) {
-	if response, err := agentInfo.client.AgentResults(context.Background(), &pb.CoordinatorResultsRequest{}); err != nil {
+func (c *Coordinator) getResults(ctx context.Context, agentInfo *AgentInfo) {
+	agentInfo.response = nil
+	if agentInfo.getState() == AGENT_LOST {
+		return
+	}
+	var response *pb.AgentResultsResponse
+	err := c.withRetry(ctx, agentInfo, "AgentResults", func(ctx context.Context) error {
+		var err error
+		response, err = agentInfo.getClient().AgentResults(ctx, &pb.CoordinatorResultsRequest{})
+		return err
+	})
+	if err != nil {
 		c.logger.Error("Unable to get results from agent %s due to %v", agentInfo.hostname, err)
-		c.shutdown()
+		c.markFailed(agentInfo, err)
 	} else {
 		c.logger.Info("Got %v capture results from %v", len(response.CaptureMap), agentInfo.hostname)
-		agentInfo.results = response.CaptureMap
+		agentInfo.response = response
+		c.markHealthy(agentInfo)
 	}
-	wg.Done()
 }
 
-func (c *Coordinator) GetResults() {
-	wg := sync.WaitGroup{}
-	wg.Add(len(c.agentsInfo))
-	for _, agent := range c.agentsInfo {
-		go c.getResults(&wg, agent)
-	}
-	wg.Wait()
-}
-
-func (c *Coordinator) sayGoodBye(wg *sync.WaitGroup, agentInfo *AgentInfo) {
-	_, err := agentInfo.client.AgentResults(context.Background(), &pb.CoordinatorResultsRequest{})
-	if err != nil {
-		c.logger.Error("Unable to get results from agent %s due to %v", agentInfo.hostname, err)
-		os.Exit(1)
+func (c *Coordinator) GetResults() []*AgentResults {
+	agentsInfo := c.orderedAgents()
+	c.fanOut(agentsInfo, c.getResults)
+
+	var windowResults []*AgentResults
+	for _, agent := range agentsInfo {
+		if agent.response != nil {
+			windowResults = append(windowResults, &AgentResults{
+				hostname:        agent.hostname,
+				results:         agent.response.CaptureMap,
+				packetsReceived: agent.response.PacketsReceived,
+				packetsDropped:  agent.response.PacketsDropped,
+				slowOps:         agent.response.SlowOps,
+				hotKeys:         agent.response.HotKeys,
+				keyPolicy:       agent.response.KeyPolicy,
+				tlsConnections:  agent.response.TlsConnections,
+				dcpConnections:  agent.response.DcpConnections,
+				serverPush:      agent.response.ServerPush,
+				notMyVbuckets:   agent.response.NotMyVbuckets,
+				httpRequests:    agent.response.HttpRequests,
+				statementPolicy: agent.response.StatementPolicy,
+				configStreams:   agent.response.ConfigStreams,
+			})
+		}
 	}
-	wg.Done()
+	return windowResults
 }
 
 func (c *Coordinator) sayGoodbye(wg *sync.WaitGroup, agentInfo *AgentInfo) {
+	defer wg.Done()
+	if agentInfo.getState() == AGENT_LOST {
+		return
+	}
 	c.logger.Info("Saying goodbye to %v", agentInfo.hostname)
-	_, err := agentInfo.client.GoodByeSignal(context.Background(), &pb.CoordinatorGoodByeRequest{})
+	ctx, cancel := context.WithTimeout(context.Background(), c.callTimeout())
+	defer cancel()
+	_, err := agentInfo.getClient().GoodByeSignal(ctx, &pb.CoordinatorGoodByeRequest{})
 	if err != nil {
 		c.logger.Error("Unable to say good bye to agent %s due to %v", agentInfo.hostname, err)
-		os.Exit(1)
 	}
-	wg.Done()
 }
 
 func (c *Coordinator) shutdown() {
+	agentsInfo := c.orderedAgents()
 	wg := sync.WaitGroup{}
-	wg.Add(len(c.agentsInfo))
-	for _, agent := range c.agentsInfo {
+	wg.Add(len(agentsInfo))
+	for _, agent := range agentsInfo {
 		go c.sayGoodbye(&wg, agent)
 	}
 	wg.Wait()
@@ -389,17 +658,22 @@ func (c *Coordinator) cleanupOnTermination() {
 }
 
 func (c *Coordinator) Run() {
-	c.ConnectToAgents()
 	c.setupStore()
+	c.ConnectToAgents()
+	if c.config.Discovery.Endpoint != "" {
+		go c.discoverAgents()
+	}
+	go c.startRpcServer()
 	go c.startRestServer()
 	go c.storeFlusher()
 	go c.cleanupOnTermination()
 
 	for {
+		start := time.Now()
 		c.StartCapture()
 		time.Sleep(time.Duration(c.config.Capture.Period) * time.Millisecond)
-		c.GetResults()
-		go c.mergeAndStore()
+		windowResults := c.GetResults()
+		go c.mergeAndStore(start, time.Now(), windowResults)
 		time.Sleep(time.Duration(c.config.Capture.Interval) * time.Millisecond)
 	}
 
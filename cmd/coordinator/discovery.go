@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2017 Couchbase, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type clusterNode struct {
+	Hostname          string   `json:"hostname"`
+	Services          []string `json:"services"`
+	ClusterMembership string   `json:"clusterMembership"`
+}
+
+type poolsDefault struct {
+	Nodes []clusterNode `json:"nodes"`
+}
+
+// fetchDataNodes returns the agent address of every active data node in the cluster map.
+func (c *Coordinator) fetchDataNodes() (map[string]bool, error) {
+	request, err := http.NewRequest("GET", strings.TrimRight(c.config.Discovery.Endpoint, "/")+"/pools/default", nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.config.Discovery.Username != "" {
+		request.SetBasicAuth(c.config.Discovery.Username, c.config.Discovery.Password)
+	}
+	client := &http.Client{Timeout: c.callTimeout()}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Cluster manager returned %v", response.Status)
+	}
+
+	var pools poolsDefault
+	if err := json.NewDecoder(response.Body).Decode(&pools); err != nil {
+		return nil, err
+	}
+
+	agents := make(map[string]bool)
+	for _, node := range pools.Nodes {
+		if node.ClusterMembership != "" && node.ClusterMembership != "active" {
+			continue
+		}
+		isDataNode := false
+		for _, service := range node.Services {
+			if service == "kv" {
+				isDataNode = true
+			}
+		}
+		if !isDataNode {
+			continue
+		}
+		host, _, err := net.SplitHostPort(node.Hostname)
+		if err != nil {
+			c.logger.Error("Ignoring node with unexpected hostname %v", node.Hostname)
+			continue
+		}
+		agents[net.JoinHostPort(host, strconv.Itoa(c.config.Discovery.AgentPort))] = true
+	}
+	return agents, nil
+}
+
+// discoverAgents keeps the captured agents in line with the data nodes of the cluster, connecting
+// to nodes that join and dropping the ones that leave.
+func (c *Coordinator) discoverAgents() {
+	discovered := make(map[string]bool)
+	for {
+		if err := c.syncDiscoveredAgents(discovered); err != nil {
+			c.logger.Error("Unable to fetch the cluster map from %v due to %v", c.config.Discovery.Endpoint, err)
+		}
+		time.Sleep(time.Duration(c.config.Discovery.Interval) * time.Second)
+	}
+}
+
+// syncDiscoveredAgents adds the data nodes that joined since the previous call and removes the
+// discovered ones that left. Agents that were already known, from the config or registered at
+// runtime, are never marked as discovered and are left alone.
+func (c *Coordinator) syncDiscoveredAgents(discovered map[string]bool) error {
+	agents, err := c.fetchDataNodes()
+	if err != nil {
+		return err
+	}
+	for agent := range agents {
+		if discovered[agent] {
+			continue
+		}
+		c.agentsMutex.Lock()
+		_, known := c.agentsInfo[agent]
+		c.agentsMutex.Unlock()
+		if known {
+			continue
+		}
+		c.logger.Info("Discovered data node agent %v", agent)
+		c.addAgent(agent)
+		discovered[agent] = true
+	}
+	for agent := range discovered {
+		if !agents[agent] {
+			c.logger.Info("Data node agent %v left the cluster", agent)
+			if err := c.removeAgent(agent, true); err != nil {
+				c.logger.Error("%v", err)
+			}
+			delete(discovered, agent)
+		}
+	}
+	return nil
+}
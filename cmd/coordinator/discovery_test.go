@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2017 Couchbase, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"../../logger"
+	"encoding/json"
+	"google.golang.org/grpc"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func newTestCoordinator() *Coordinator {
+	c := &Coordinator{
+		config:      &Config{},
+		agentsMutex: &sync.Mutex{},
+		agentsInfo:  make(map[string]*AgentInfo),
+		dialOptions: []grpc.DialOption{grpc.WithInsecure()},
+		logger:      &logger.Logger{},
+	}
+	c.logger.Init("", logger.ERRORLEVEL)
+	c.config.setDefaults()
+	c.metrics = newMetrics(c)
+	return c
+}
+
+func (c *Coordinator) hasAgent(hostName string) bool {
+	c.agentsMutex.Lock()
+	defer c.agentsMutex.Unlock()
+	_, ok := c.agentsInfo[hostName]
+	return ok
+}
+
+func TestSyncDiscoveredAgents(t *testing.T) {
+	var pools poolsDefault
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pools/default" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(&pools)
+	}))
+	defer server.Close()
+
+	c := newTestCoordinator()
+	c.config.Discovery.Endpoint = server.URL
+	// nothing listens there, goodbyes fail right away
+	c.config.Discovery.AgentPort = 1
+	c.addAgent("127.0.0.4:1")
+
+	pools.Nodes = []clusterNode{
+		{Hostname: "127.0.0.1:8091", Services: []string{"kv", "n1ql"}, ClusterMembership: "active"},
+		{Hostname: "127.0.0.2:8091", Services: []string{"n1ql"}, ClusterMembership: "active"},
+		{Hostname: "127.0.0.3:8091", Services: []string{"kv"}, ClusterMembership: "inactiveAdded"},
+		{Hostname: "127.0.0.4:8091", Services: []string{"kv"}, ClusterMembership: "active"},
+	}
+	discovered := make(map[string]bool)
+	if err := c.syncDiscoveredAgents(discovered); err != nil {
+		t.Fatal(err)
+	}
+	if !c.hasAgent("127.0.0.1:1") {
+		t.Error("the active data node was not added")
+	}
+	if c.hasAgent("127.0.0.2:1") || c.hasAgent("127.0.0.3:1") {
+		t.Error("a node without kv or not active was added")
+	}
+	if discovered["127.0.0.4:1"] {
+		t.Error("an agent known before discovery was marked as discovered")
+	}
+
+	pools.Nodes = nil
+	if err := c.syncDiscoveredAgents(discovered); err != nil {
+		t.Fatal(err)
+	}
+	if c.hasAgent("127.0.0.1:1") || len(discovered) != 0 {
+		t.Error("the node that left was not removed")
+	}
+	if !c.hasAgent("127.0.0.4:1") {
+		t.Error("an agent known before discovery was removed")
+	}
+
+	server.Close()
+	if err := c.syncDiscoveredAgents(discovered); err == nil {
+		t.Error("expected an error from an unreachable cluster manager")
+	}
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) 2017 Couchbase, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	pb "../../rpc"
+	"context"
+	"sync"
+	"time"
+)
+
+type AgentState int
+
+const (
+	AGENT_CONNECTED AgentState = iota
+	AGENT_DEGRADED
+	AGENT_LOST
+)
+
+func (s AgentState) String() string {
+	switch s {
+	case AGENT_CONNECTED:
+		return "connected"
+	case AGENT_DEGRADED:
+		return "degraded"
+	case AGENT_LOST:
+		return "lost"
+	}
+	return "unknown"
+}
+
+func (a *AgentInfo) getState() AgentState {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.state
+}
+
+func (a *AgentInfo) getClient() pb.AgentServiceClient {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.client
+}
+
+func (c *Coordinator) setState(agentInfo *AgentInfo, state AgentState) {
+	agentInfo.mutex.Lock()
+	previous := agentInfo.state
+	agentInfo.state = state
+	agentInfo.mutex.Unlock()
+
+	if previous != state {
+		c.logger.Info("Agent %v is now %v (was %v)", agentInfo.hostname, state, previous)
+	}
+}
+
+// callTimeout bounds every rpc made to an agent so that a hung node can not stall the capture loop.
+func (c *Coordinator) callTimeout() time.Duration {
+	return time.Duration(c.config.Capture.Timeout) * time.Millisecond
+}
+
+// fanOut runs call on every agent at once and waits until they are all done or the capture deadline
+// passes. Calls still running then are cancelled, so one hung agent can not hold up the window for
+// the rest of the cluster, and fail like any other call.
+func (c *Coordinator) fanOut(agentsInfo []*AgentInfo, call func(ctx context.Context, agentInfo *AgentInfo)) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.config.Capture.Deadline)*time.Millisecond)
+	defer cancel()
+	wg := sync.WaitGroup{}
+	wg.Add(len(agentsInfo))
+	for _, agent := range agentsInfo {
+		go func(agentInfo *AgentInfo) {
+			defer wg.Done()
+			call(ctx, agentInfo)
+		}(agent)
+	}
+	wg.Wait()
+}
+
+// withRetry runs call until it succeeds, the configured attempts are used up or ctx is done,
+// doubling the wait between attempts up to the configured maximum.
+func (c *Coordinator) withRetry(ctx context.Context, agentInfo *AgentInfo, op string, call func(ctx context.Context) error) error {
+	backoff := time.Duration(c.config.Retry.Backoff) * time.Millisecond
+	maxBackoff := time.Duration(c.config.Retry.MaxBackoff) * time.Millisecond
+
+	var err error
+	for attempt := 0; attempt <= c.config.Retry.Attempts; attempt++ {
+		if attempt > 0 {
+			c.logger.Debug("Retrying %v on agent %v in %v (attempt %v)", op, agentInfo.hostname, backoff, attempt)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return err
+			}
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+		callCtx, cancel := context.WithTimeout(ctx, c.callTimeout())
+		err = call(callCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (c *Coordinator) markHealthy(agentInfo *AgentInfo) {
+	agentInfo.mutex.Lock()
+	agentInfo.failures = 0
+	agentInfo.mutex.Unlock()
+	c.setState(agentInfo, AGENT_CONNECTED)
+}
+
+// markFailed degrades the agent after a failed call and gives up on the connection once
+// LostAfter calls in a row have failed, at which point it is handed over to reconnect.
+func (c *Coordinator) markFailed(agentInfo *AgentInfo, err error) {
+	agentInfo.mutex.Lock()
+	agentInfo.failures++
+	failures := agentInfo.failures
+	agentInfo.mutex.Unlock()
+
+	c.logger.Error("Agent %v failed %v consecutive times, last error %v", agentInfo.hostname, failures, err)
+	c.metrics.agentFailures.WithLabelValues(agentInfo.hostname).Inc()
+	if failures < c.config.Retry.LostAfter {
+		c.setState(agentInfo, AGENT_DEGRADED)
+		return
+	}
+	if agentInfo.getState() != AGENT_LOST {
+		c.setState(agentInfo, AGENT_LOST)
+		go c.reconnect(agentInfo)
+	}
+}
+
+// reconnect redials a lost agent with exponential backoff until the agent answers again.
+func (c *Coordinator) reconnect(agentInfo *AgentInfo) {
+	agentInfo.mutex.Lock()
+	if agentInfo.conn != nil {
+		agentInfo.conn.Close()
+	}
+	agentInfo.mutex.Unlock()
+	backoff := time.Duration(c.config.Retry.Backoff) * time.Millisecond
+	maxBackoff := time.Duration(c.config.Retry.MaxBackoff) * time.Millisecond
+
+	for {
+		conn, err := c.connectToAgent(agentInfo.hostname, true)
+		if err == nil {
+			agentInfo.mutex.Lock()
+			if agentInfo.removed {
+				agentInfo.mutex.Unlock()
+				conn.Close()
+				return
+			}
+			agentInfo.conn = conn
+			agentInfo.client = pb.NewAgentServiceClient(conn)
+			agentInfo.mutex.Unlock()
+			c.logger.Info("Reconnected to the agent %v", agentInfo.hostname)
+			c.markHealthy(agentInfo)
+			return
+		}
+		c.logger.Debug("Unable to reconnect to the agent %v %v", agentInfo.hostname, err)
+		agentInfo.mutex.Lock()
+		removed := agentInfo.removed
+		agentInfo.mutex.Unlock()
+		if removed {
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
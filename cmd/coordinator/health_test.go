@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2017 Couchbase, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	pb "../../rpc"
+	"context"
+	"google.golang.org/grpc"
+	"net"
+	"testing"
+	"time"
+)
+
+// stubAgent answers capture results right away, or only once the call is cancelled when it hangs.
+type stubAgent struct {
+	hang bool
+}
+
+func (a *stubAgent) CaptureSignal(context.Context, *pb.CoordinatorCaptureRequest) (*pb.AgentCaptureResponse, error) {
+	return &pb.AgentCaptureResponse{Status: "success"}, nil
+}
+
+func (a *stubAgent) GoodByeSignal(context.Context, *pb.CoordinatorGoodByeRequest) (*pb.AgentGoodByeResponse, error) {
+	return &pb.AgentGoodByeResponse{Status: "success"}, nil
+}
+
+func (a *stubAgent) AgentResults(ctx context.Context, in *pb.CoordinatorResultsRequest) (*pb.AgentResultsResponse, error) {
+	if a.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &pb.AgentResultsResponse{Status: "success", KeyPolicy: "plain"}, nil
+}
+
+func startStubAgent(t *testing.T, agent *stubAgent) (string, *grpc.Server) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterAgentServiceServer(s, agent)
+	go s.Serve(lis)
+	return lis.Addr().String(), s
+}
+
+func TestGetResultsDeadline(t *testing.T) {
+	c := newTestCoordinator()
+	c.config.Capture.Timeout = 10000
+	c.config.Capture.Deadline = 200
+	healthy, healthyServer := startStubAgent(t, &stubAgent{})
+	defer healthyServer.Stop()
+	hung, hungServer := startStubAgent(t, &stubAgent{hang: true})
+	defer hungServer.Stop()
+	c.addAgent(healthy)
+	c.addAgent(hung)
+
+	start := time.Now()
+	results := c.GetResults()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("GetResults waited %v on the hung agent", elapsed)
+	}
+	if len(results) != 1 || results[0].hostname != healthy {
+		t.Fatalf("expected the results of %v only, got %+v", healthy, results)
+	}
+	c.agentsMutex.Lock()
+	healthyState, hungState := c.agentsInfo[healthy].getState(), c.agentsInfo[hung].getState()
+	c.agentsMutex.Unlock()
+	if healthyState != AGENT_CONNECTED || hungState != AGENT_DEGRADED {
+		t.Errorf("expected connected and degraded, got %v and %v", healthyState, hungState)
+	}
+}
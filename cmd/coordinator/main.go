@@ -20,11 +20,11 @@ import (
 	"../../logger"
 	"flag"
 	"fmt"
-	"github.com/codahale/hdrhistogram"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"log"
 	"strings"
+	"sync"
 )
 
 func loadConfig(configFile string, config *Config) {
@@ -42,12 +42,14 @@ func main() {
 	configFile := flag.String("config", "./config.yml", "Config file for the tricorder coordinator")
 	flag.Parse()
 	coordinator := &Coordinator{
-		config:     &Config{},
-		agentsInfo: make(map[string]*AgentInfo),
-		histogram:  hdrhistogram.New(1, 5*1000*1000, 3), //max histogram value for latency 5 secs
-		logger:     &logger.Logger{},
+		config:      &Config{},
+		agentsMutex: &sync.Mutex{},
+		agentsInfo:  make(map[string]*AgentInfo),
+		logger:      &logger.Logger{},
 	}
+	coordinator.metrics = newMetrics(coordinator)
 	loadConfig(fmt.Sprint("./", *configFile), coordinator.config)
+	coordinator.config.setDefaults()
 	if coordinator.config.logging.logLevel == "" || strings.EqualFold(coordinator.config.logging.logLevel, "info") {
 		coordinator.logger.Init(coordinator.config.logging.file, 1)
 	} else if strings.EqualFold(coordinator.config.logging.logLevel, "error") {
@@ -55,6 +57,11 @@ func main() {
 	} else if strings.EqualFold(coordinator.config.logging.logLevel, "debug") {
 		coordinator.logger.Init(coordinator.config.logging.file, 2)
 	}
+	// Refuse to start rather than fall back to plaintext when tls is configured but unusable, or
+	// when the token would be sent in plaintext.
+	if err := coordinator.setupCredentials(); err != nil {
+		log.Fatalf("Invalid tls or auth configuration: %v", err)
+	}
 
 	coordinator.Run()
 }
@@ -0,0 +1,227 @@
+/*
+ * Copyright (c) 2017 Couchbase, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+)
+
+const METRICS_NAMESPACE = "tricorder"
+
+// Latency buckets in seconds, from 50µs doubling up to ~6.5s to cover the range of the histograms.
+var latencyBuckets = prometheus.ExponentialBuckets(0.00005, 2, 18)
+
+// HTTP buckets in seconds, from 1ms doubling up to ~9 minutes for long running queries.
+var httpLatencyBuckets = prometheus.ExponentialBuckets(0.001, 2, 20)
+
+var agentStateDesc = prometheus.NewDesc(METRICS_NAMESPACE+"_agent_state",
+	"Current state of each agent, 1 for the state the agent is in and 0 for the others.",
+	[]string{"agent", "state"}, nil)
+
+var agentUpDesc = prometheus.NewDesc(METRICS_NAMESPACE+"_agent_up",
+	"Whether the agent answered the last capture, 0 once it is lost.",
+	[]string{"agent"}, nil)
+
+// Metrics are exported on /metrics of the REST port for Prometheus to scrape.
+type Metrics struct {
+	registry         *prometheus.Registry
+	latency          *prometheus.HistogramVec
+	operations       *prometheus.CounterVec
+	windows          prometheus.Counter
+	windowDuration   prometheus.Histogram
+	windowOperations *prometheus.GaugeVec
+	packetsReceived  *prometheus.CounterVec
+	packetsDropped   *prometheus.CounterVec
+	agentFailures    *prometheus.CounterVec
+	slowOps          *prometheus.CounterVec
+	serverRequests   *prometheus.CounterVec
+	// clustermapNotifications counts the notifications reported, not those an agent dropped over its limit.
+	clustermapNotifications *prometheus.CounterVec
+	notMyVbuckets           *prometheus.CounterVec
+	httpLatency             *prometheus.HistogramVec
+	configPushes            *prometheus.CounterVec
+}
+
+// agentCollector reports the health of the registered agents at scrape time.
+type agentCollector struct {
+	coordinator *Coordinator
+}
+
+func (a *agentCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- agentStateDesc
+	ch <- agentUpDesc
+}
+
+func (a *agentCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, agentInfo := range a.coordinator.orderedAgents() {
+		state := agentInfo.getState()
+		for _, s := range []AgentState{AGENT_CONNECTED, AGENT_DEGRADED, AGENT_LOST} {
+			value := 0.0
+			if s == state {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(agentStateDesc, prometheus.GaugeValue, value, agentInfo.hostname, s.String())
+		}
+		up := 1.0
+		if state == AGENT_LOST {
+			up = 0
+		}
+		ch <- prometheus.MustNewConstMetric(agentUpDesc, prometheus.GaugeValue, up, agentInfo.hostname)
+	}
+}
+
+func newMetrics(c *Coordinator) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "operation_latency_seconds",
+			Help:      "Latency of the captured operations between request and response.",
+			Buckets:   latencyBuckets,
+		}, []string{"agent", "opcode", "status"}),
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "operations_total",
+			Help:      "Number of captured operations.",
+		}, []string{"agent", "opcode", "status"}),
+		windows: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "capture_windows_total",
+			Help:      "Number of capture windows merged and stored.",
+		}),
+		windowDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "capture_window_duration_seconds",
+			Help:      "Duration of the capture windows.",
+			Buckets:   prometheus.LinearBuckets(1, 1, 10),
+		}),
+		windowOperations: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "capture_window_operations",
+			Help:      "Number of operations each agent captured in the last window.",
+		}, []string{"agent"}),
+		packetsReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "packets_received_total",
+			Help:      "Packets the kernel passed to the agent's capture.",
+		}, []string{"agent"}),
+		packetsDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "packets_dropped_total",
+			Help:      "Packets the kernel dropped because the agent's capture could not keep up.",
+		}, []string{"agent"}),
+		agentFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "agent_failures_total",
+			Help:      "Calls to an agent that failed after all retries.",
+		}, []string{"agent"}),
+		slowOps: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "slow_operations_total",
+			Help:      "Operations over the agent's slow-op threshold.",
+		}, []string{"agent", "opcode"}),
+		serverRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "server_requests_total",
+			Help:      "Requests servers initiated on duplex client connections, kept apart from the operations.",
+		}, []string{"agent"}),
+		clustermapNotifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "clustermap_notifications_total",
+			Help:      "Cluster map change notifications pushed to clients.",
+		}, []string{"agent", "bucket"}),
+		notMyVbuckets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "not_my_vbucket_total",
+			Help:      "Operations answered NOT_MY_VBUCKET, sent on a stale cluster map.",
+		}, []string{"agent", "bucket"}),
+		httpLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "http_request_latency_seconds",
+			Help:      "Latency of the requests to the HTTP services up to the end of their response.",
+			Buckets:   httpLatencyBuckets,
+		}, []string{"agent", "service", "method", "path", "status"}),
+		configPushes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "config_pushes_total",
+			Help:      "Configs the cluster manager pushed on streaming config requests.",
+		}, []string{"agent"}),
+	}
+	m.registry.MustRegister(m.latency, m.operations, m.windows, m.windowDuration, m.windowOperations,
+		m.packetsReceived, m.packetsDropped, m.agentFailures, m.slowOps, m.serverRequests, m.clustermapNotifications,
+		m.notMyVbuckets, m.httpLatency, m.configPushes, &agentCollector{coordinator: c})
+	return m
+}
+
+func (m *Metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// observeOperation records one operation, latency is in microseconds.
+func (m *Metrics) observeOperation(agent string, opcode string, status string, latency int64) {
+	m.latency.WithLabelValues(agent, opcode, status).Observe(float64(latency) / 1e6)
+	m.operations.WithLabelValues(agent, opcode, status).Inc()
+}
+
+func (m *Metrics) observeWindow(window *Window, windowResults []*AgentResults) {
+	m.windows.Inc()
+	m.windowDuration.Observe(float64(window.End-window.Start) / 1e3)
+	for _, agentResults := range windowResults {
+		m.windowOperations.WithLabelValues(agentResults.hostname).Set(float64(len(agentResults.results)))
+		m.packetsReceived.WithLabelValues(agentResults.hostname).Add(float64(agentResults.packetsReceived))
+		m.packetsDropped.WithLabelValues(agentResults.hostname).Add(float64(agentResults.packetsDropped))
+		for _, op := range agentResults.slowOps {
+			m.slowOps.WithLabelValues(agentResults.hostname, op.Opcode).Inc()
+		}
+		for _, connection := range agentResults.serverPush {
+			m.serverRequests.WithLabelValues(agentResults.hostname).Add(float64(connection.Requests))
+			for _, notification := range connection.Notifications {
+				m.clustermapNotifications.WithLabelValues(agentResults.hostname, notification.Bucket).Inc()
+			}
+		}
+		for _, op := range agentResults.notMyVbuckets {
+			m.notMyVbuckets.WithLabelValues(agentResults.hostname, op.Bucket).Inc()
+		}
+		for _, request := range agentResults.httpRequests {
+			m.httpLatency.WithLabelValues(agentResults.hostname, request.Service, request.Method, request.Path,
+				strconv.Itoa(int(request.Status))).Observe(float64(request.Latency) / 1e6)
+		}
+		for _, configStream := range agentResults.configStreams {
+			m.configPushes.WithLabelValues(agentResults.hostname).Add(float64(configStream.Configs))
+		}
+	}
+}
+
+// forgetAgent drops the series of a removed agent so that it no longer shows up in scrapes.
+func (m *Metrics) forgetAgent(agent string) {
+	labels := prometheus.Labels{"agent": agent}
+	m.latency.DeletePartialMatch(labels)
+	m.operations.DeletePartialMatch(labels)
+	m.windowOperations.DeletePartialMatch(labels)
+	m.packetsReceived.DeletePartialMatch(labels)
+	m.packetsDropped.DeletePartialMatch(labels)
+	m.agentFailures.DeletePartialMatch(labels)
+	m.slowOps.DeletePartialMatch(labels)
+	m.serverRequests.DeletePartialMatch(labels)
+	m.clustermapNotifications.DeletePartialMatch(labels)
+	m.notMyVbuckets.DeletePartialMatch(labels)
+	m.httpLatency.DeletePartialMatch(labels)
+	m.configPushes.DeletePartialMatch(labels)
+}
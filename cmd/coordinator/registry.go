@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2017 Couchbase, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	pb "../../rpc"
+	"../../tlsconfig"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"net"
+	"net/http"
+	"sync"
+)
+
+type agentRequest struct {
+	Address string `json:"address"`
+}
+
+// addAgent connects to the agent at hostName and adds it to the set of captured agents. Adding an
+// agent that is already known is a no-op so agents can safely re-register after a restart.
+func (c *Coordinator) addAgent(hostName string) *AgentInfo {
+	c.agentsMutex.Lock()
+	if agentInfo, ok := c.agentsInfo[hostName]; ok {
+		c.agentsMutex.Unlock()
+		return agentInfo
+	}
+	agentInfo := &AgentInfo{
+		mutex:    &sync.Mutex{},
+		index:    c.nextAgentIndex,
+		hostname: hostName,
+	}
+	c.nextAgentIndex++
+	c.agentsInfo[hostName] = agentInfo
+	c.agentsMutex.Unlock()
+
+	conn, err := c.connectToAgent(hostName, false)
+	if err != nil {
+		c.logger.Error("Unable to connect to the Agent %v %v", err, hostName)
+		c.setState(agentInfo, AGENT_LOST)
+		go c.reconnect(agentInfo)
+		return agentInfo
+	}
+	c.logger.Info("Connected to the agent %v", hostName)
+	agentInfo.mutex.Lock()
+	agentInfo.conn = conn
+	agentInfo.client = pb.NewAgentServiceClient(conn)
+	agentInfo.mutex.Unlock()
+	return agentInfo
+}
+
+// removeAgent stops capturing from the agent at hostName, its history is kept in the store. Agents
+// deregistering themselves are shutting down already and are not told goodbye.
+func (c *Coordinator) removeAgent(hostName string, goodbye bool) error {
+	c.agentsMutex.Lock()
+	agentInfo, ok := c.agentsInfo[hostName]
+	if ok {
+		delete(c.agentsInfo, hostName)
+	}
+	c.agentsMutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("Agent %v is not registered", hostName)
+	}
+
+	if goodbye {
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		c.sayGoodbye(&wg, agentInfo)
+	}
+	agentInfo.mutex.Lock()
+	agentInfo.removed = true
+	if agentInfo.conn != nil {
+		agentInfo.conn.Close()
+	}
+	agentInfo.mutex.Unlock()
+	c.metrics.forgetAgent(hostName)
+	c.logger.Info("Removed the agent %v", hostName)
+	return nil
+}
+
+func (c *Coordinator) RegisterAgent(ctx context.Context, in *pb.AgentRegisterRequest) (*pb.CoordinatorRegisterResponse, error) {
+	if in.Address == "" {
+		return nil, fmt.Errorf("Agent address is missing")
+	}
+	if err := c.authorizer.bindAgent(ctx, "RegisterAgent", in.Address); err != nil {
+		return nil, err
+	}
+	c.logger.Info("Agent %v registered", in.Address)
+	c.addAgent(in.Address)
+	return &pb.CoordinatorRegisterResponse{Status: "success"}, nil
+}
+
+func (c *Coordinator) DeregisterAgent(ctx context.Context, in *pb.AgentDeregisterRequest) (*pb.CoordinatorDeregisterResponse, error) {
+	if err := c.authorizer.bindAgent(ctx, "DeregisterAgent", in.Address); err != nil {
+		return nil, err
+	}
+	c.logger.Info("Agent %v deregistered", in.Address)
+	if err := c.removeAgent(in.Address, false); err != nil {
+		return nil, err
+	}
+	return &pb.CoordinatorDeregisterResponse{Status: "success"}, nil
+}
+
+// setupCredentials prepares the credentials used both to dial the agents and to serve agent
+// registrations, along with who may register agents.
+func (c *Coordinator) setupCredentials() error {
+	dialOption, err := c.config.TLS.DialOption()
+	if err != nil {
+		return err
+	}
+	serverConfig, err := c.config.TLS.ServerConfig()
+	if err != nil {
+		return err
+	}
+	if c.authorizer, err = NewAuthorizer(c.config, c.logger); err != nil {
+		return err
+	}
+	c.dialOptions = append(c.dialOptions, dialOption)
+	if c.config.Auth.Token != "" {
+		c.dialOptions = append(c.dialOptions, grpc.WithPerRPCCredentials(tlsconfig.TokenCredentials(c.config.Auth.Token)))
+	}
+	c.serverOptions = append(c.serverOptions, grpc.UnaryInterceptor(c.authorizer.UnaryInterceptor))
+	if serverConfig != nil {
+		c.serverOptions = append(c.serverOptions, grpc.Creds(credentials.NewTLS(serverConfig)))
+		// browsers reach the REST API without a client certificate, only the agent endpoints
+		// need one and only with an allow-list
+		c.restTLS = serverConfig.Clone()
+		if c.restTLS.ClientAuth == tls.RequireAndVerifyClientCert {
+			c.restTLS.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return nil
+}
+
+func (c *Coordinator) startRpcServer() {
+	lis, err := net.Listen("tcp", fmt.Sprint(":", c.config.Port))
+	if err != nil {
+		c.logger.Error("Failed to listen for agent registrations %v", err)
+		c.shutdown()
+	}
+	s := grpc.NewServer(c.serverOptions...)
+	pb.RegisterCoordinatorServiceServer(s, c)
+	if err := s.Serve(lis); err != nil {
+		c.logger.Error("Failed to serve agent registrations %v", err)
+		c.shutdown()
+	}
+}
+
+func (c *Coordinator) addAgentHandler(w http.ResponseWriter, r *http.Request) {
+	var request agentRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Address == "" {
+		http.Error(w, "expected a json body with the agent address", http.StatusBadRequest)
+		return
+	}
+	c.addAgent(request.Address)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (c *Coordinator) removeAgentHandler(w http.ResponseWriter, r *http.Request) {
+	if err := c.removeAgent(mux.Vars(r)["address"], true); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,484 @@
+/*
+ * Copyright (c) 2017 Couchbase, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/codahale/hdrhistogram"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	SQLITE_BACKEND = "sqlite"
+	MEMORY_BACKEND = "memory"
+	FILE_BACKEND   = "file"
+)
+
+// Latencies are recorded in microseconds, anything above 5 seconds is recorded as 5 seconds.
+const MAX_LATENCY = 5 * 1000 * 1000
+
+// Latency of HTTP requests in microseconds, up to 10 minutes.
+const MAX_HTTP_LATENCY = 10 * 60 * 1000 * 1000
+
+// Value sizes are recorded in bytes up to the 20MB document limit of the server.
+const MAX_VALUE_SIZE = 20 * 1024 * 1024
+
+// Operation is a single captured operation as seen by one agent. Timestamps are in milliseconds.
+type Operation struct {
+	Timestamp      int64  `json:"timestamp"`
+	Agent          string `json:"agent"`
+	OpaqueStreamId string `json:"opaque_streamId"`
+	Opcode         string `json:"opcode"`
+	Status         string `json:"status"`
+	Bucket         string `json:"bucket"`
+	Key            string `json:"key"`
+	Latency        int64  `json:"latency"`
+	KeyPolicy      string `json:"key_policy"`
+	// Datatype, ValueSize and UncompressedSize describe the value of the request for mutations
+	// and of the response for reads, sizes are in bytes.
+	Datatype         string `json:"datatype"`
+	ValueSize        uint32 `json:"value_size"`
+	UncompressedSize uint32 `json:"uncompressed_size"`
+}
+
+// SlowOp is an operation an agent found slower than its slow-op threshold, with its full context.
+// The timestamp is when the request was seen, in milliseconds, latencies are in microseconds.
+type SlowOp struct {
+	Timestamp      int64  `json:"timestamp"`
+	Agent          string `json:"agent"`
+	Client         string `json:"client"`
+	Server         string `json:"server"`
+	Opaque         uint32 `json:"opaque"`
+	Opcode         string `json:"opcode"`
+	Status         string `json:"status"`
+	Vbucket        uint32 `json:"vbucket"`
+	Bucket         string `json:"bucket"`
+	Key            string `json:"key"`
+	ValueSize      uint32 `json:"value_size"`
+	Latency        int64  `json:"latency"`
+	ServerDuration int64  `json:"server_duration"`
+	PipelineDepth  uint32 `json:"pipeline_depth"`
+	KeyPolicy      string `json:"key_policy"`
+}
+
+// HotKey is a key among one agent's top keys of one window, by count or by cumulative latency in
+// microseconds. Both are count-min sketch estimates from the agent.
+type HotKey struct {
+	Agent     string `json:"agent"`
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	Count     uint64 `json:"count"`
+	Latency   uint64 `json:"latency"`
+	KeyPolicy string `json:"key_policy"`
+}
+
+// TLSConnection is one connection an agent saw on the TLS port during a window. Exchanges are client
+// records answered by a server record, their latency in microseconds is known with or without
+// decrypting the connection. The handshake duration is 0 when the handshake was not captured.
+type TLSConnection struct {
+	End               int64  `json:"end"`
+	Agent             string `json:"agent"`
+	Client            string `json:"client"`
+	Server            string `json:"server"`
+	Version           string `json:"version"`
+	CipherSuite       string `json:"cipher_suite"`
+	Decryption        string `json:"decryption"`
+	Records           uint64 `json:"records"`
+	Exchanges         uint64 `json:"exchanges"`
+	Latency           int64  `json:"latency"`
+	MaxLatency        int64  `json:"max_latency"`
+	HandshakeDuration int64  `json:"handshake_duration"`
+	Resumed           bool   `json:"resumed"`
+	Alerts            uint32 `json:"alerts"`
+	LastAlert         string `json:"last_alert"`
+}
+
+// DCPStream is one vbucket stream of a DCP connection over a window. Sizes are in bytes, Duration is
+// the time between the first and the last message of the stream in microseconds, and the throughput
+// is over that time.
+type DCPStream struct {
+	Opaque           uint32  `json:"opaque"`
+	Vbucket          uint32  `json:"vbucket"`
+	Status           string  `json:"status"`
+	StartSeqno       uint64  `json:"start_seqno"`
+	EndSeqno         uint64  `json:"end_seqno"`
+	LastSeqno        uint64  `json:"last_seqno"`
+	Mutations        uint64  `json:"mutations"`
+	Deletions        uint64  `json:"deletions"`
+	Expirations      uint64  `json:"expirations"`
+	Bytes            uint64  `json:"bytes"`
+	Duration         int64   `json:"duration"`
+	ItemsPerSecond   float64 `json:"items_per_second"`
+	BytesPerSecond   float64 `json:"bytes_per_second"`
+	Snapshots        uint64  `json:"snapshots"`
+	MaxSnapshotItems uint64  `json:"max_snapshot_items"`
+	MaxSnapshotBytes uint64  `json:"max_snapshot_bytes"`
+	End              string  `json:"end"`
+}
+
+// DCPConnection is one DCP connection an agent saw during a window with the usage of its
+// flow-control buffer. Stall times are in microseconds, a stall lasts while the buffer is full.
+type DCPConnection struct {
+	End        int64        `json:"end"`
+	Agent      string       `json:"agent"`
+	Client     string       `json:"client"`
+	Server     string       `json:"server"`
+	Name       string       `json:"name"`
+	Producer   bool         `json:"producer"`
+	BufferSize uint32       `json:"buffer_size"`
+	MaxUnacked uint64       `json:"max_unacked"`
+	Acked      uint64       `json:"acked"`
+	Stalls     uint32       `json:"stalls"`
+	StallTime  int64        `json:"stall_time"`
+	MaxStall   int64        `json:"max_stall"`
+	Streams    []*DCPStream `json:"streams"`
+}
+
+// ClustermapNotification is a cluster map a server pushed on a duplex client connection, timestamped
+// in milliseconds when it was captured. Epoch is 0 for servers that only send the revision.
+type ClustermapNotification struct {
+	Timestamp int64  `json:"timestamp"`
+	Agent     string `json:"agent"`
+	Client    string `json:"client"`
+	Server    string `json:"server"`
+	Bucket    string `json:"bucket"`
+	Epoch     int64  `json:"epoch"`
+	Revision  int64  `json:"revision"`
+	Size      uint32 `json:"size"`
+}
+
+// NotMyVbucket is an operation a server answered NOT_MY_VBUCKET, timestamped in milliseconds.
+// Epoch and Revision are those of the cluster map the server sent back, 0 when it left the map out.
+// Attempt counts the NOT_MY_VBUCKET responses in a row for the key on the connection.
+type NotMyVbucket struct {
+	Timestamp int64  `json:"timestamp"`
+	Agent     string `json:"agent"`
+	Client    string `json:"client"`
+	Server    string `json:"server"`
+	Bucket    string `json:"bucket"`
+	Vbucket   uint32 `json:"vbucket"`
+	Opcode    string `json:"opcode"`
+	Latency   int64  `json:"latency"`
+	Epoch     int64  `json:"epoch"`
+	Revision  int64  `json:"revision"`
+	MapSize   uint32 `json:"map_size"`
+	Attempt   uint32 `json:"attempt"`
+}
+
+// HTTPRequest is a request to one of the HTTP services, timestamped in milliseconds when it was
+// sent. Latency runs to the end of the response and FirstByte to its headers, in microseconds.
+// Responses that end with the connection are only timed to their headers, with a response_size of 0.
+// The statement and client_context_id of query and analytics requests follow StatementPolicy.
+type HTTPRequest struct {
+	Timestamp       int64  `json:"timestamp"`
+	Agent           string `json:"agent"`
+	Client          string `json:"client"`
+	Server          string `json:"server"`
+	Service         string `json:"service"`
+	Method          string `json:"method"`
+	Path            string `json:"path"`
+	Status          int    `json:"status"`
+	Latency         int64  `json:"latency"`
+	FirstByte       int64  `json:"first_byte"`
+	RequestSize     int64  `json:"request_size"`
+	ResponseSize    int64  `json:"response_size"`
+	Statement       string `json:"statement,omitempty"`
+	ClientContextId string `json:"client_context_id,omitempty"`
+	StatementPolicy string `json:"statement_policy,omitempty"`
+}
+
+// ConfigStream is a streaming config request on the cluster manager port, with the configs pushed
+// on it during a window. Timestamps are in milliseconds and the intervals between pushes in
+// microseconds. A resumed stream was opened before the window and its path is not known.
+type ConfigStream struct {
+	Agent       string `json:"agent"`
+	Client      string `json:"client"`
+	Server      string `json:"server"`
+	Path        string `json:"path"`
+	Resumed     bool   `json:"resumed"`
+	Start       int64  `json:"start"`
+	FirstConfig int64  `json:"first_config"`
+	LastConfig  int64  `json:"last_config"`
+	Configs     uint64 `json:"configs"`
+	Bytes       int64  `json:"bytes"`
+	MinInterval int64  `json:"min_interval"`
+	MaxInterval int64  `json:"max_interval"`
+}
+
+// SubdocPath counts the specs of one type on one path that ended with one status on one agent over
+// one window. Latency is the cumulative latency in microseconds of the operations they were part of.
+type SubdocPath struct {
+	Agent      string `json:"agent"`
+	Bucket     string `json:"bucket"`
+	Spec       string `json:"spec"`
+	Path       string `json:"path"`
+	Status     string `json:"status"`
+	Count      uint64 `json:"count"`
+	Latency    int64  `json:"latency"`
+	MaxLatency int64  `json:"max_latency"`
+	KeyPolicy  string `json:"key_policy"`
+}
+
+// OperationFilter selects operations from windows that ended within [From, To]. Empty fields
+// match everything and a Limit of 0 returns all matching operations.
+type OperationFilter struct {
+	From      int64
+	To        int64
+	Agent     string
+	Opcode    string
+	Status    string
+	Bucket    string
+	KeyPrefix string
+	Limit     int
+	Offset    int
+}
+
+func (f *OperationFilter) matches(op *Operation) bool {
+	return (f.Agent == "" || op.Agent == f.Agent) &&
+		(f.Opcode == "" || op.Opcode == f.Opcode) &&
+		(f.Status == "" || op.Status == f.Status) &&
+		(f.Bucket == "" || op.Bucket == f.Bucket) &&
+		strings.HasPrefix(op.Key, f.KeyPrefix)
+}
+
+// matchesSlowOp selects slow ops by their own timestamp rather than the end of their window.
+func (f *OperationFilter) matchesSlowOp(op *SlowOp) bool {
+	return op.Timestamp >= f.From && op.Timestamp <= f.To &&
+		(f.Agent == "" || op.Agent == f.Agent) &&
+		(f.Opcode == "" || op.Opcode == f.Opcode) &&
+		(f.Status == "" || op.Status == f.Status) &&
+		(f.Bucket == "" || op.Bucket == f.Bucket) &&
+		strings.HasPrefix(op.Key, f.KeyPrefix)
+}
+
+func (f *OperationFilter) matchesSubdocPath(path *SubdocPath) bool {
+	return (f.Agent == "" || path.Agent == f.Agent) &&
+		(f.Opcode == "" || path.Spec == f.Opcode) &&
+		(f.Status == "" || path.Status == f.Status) &&
+		(f.Bucket == "" || path.Bucket == f.Bucket)
+}
+
+// matchesNotification selects notifications by their own timestamp rather than the end of their window.
+func (f *OperationFilter) matchesNotification(notification *ClustermapNotification) bool {
+	return notification.Timestamp >= f.From && notification.Timestamp <= f.To &&
+		(f.Agent == "" || notification.Agent == f.Agent) &&
+		(f.Bucket == "" || notification.Bucket == f.Bucket)
+}
+
+func (f *OperationFilter) matchesNotMyVbucket(op *NotMyVbucket) bool {
+	return op.Timestamp >= f.From && op.Timestamp <= f.To &&
+		(f.Agent == "" || op.Agent == f.Agent) &&
+		(f.Opcode == "" || op.Opcode == f.Opcode) &&
+		(f.Bucket == "" || op.Bucket == f.Bucket)
+}
+
+func (f *OperationFilter) matchesHTTPRequest(request *HTTPRequest) bool {
+	return request.Timestamp >= f.From && request.Timestamp <= f.To &&
+		(f.Agent == "" || request.Agent == f.Agent)
+}
+
+// matchesConfigStream selects the streams open at some point of the range.
+func (f *OperationFilter) matchesConfigStream(configStream *ConfigStream) bool {
+	last := configStream.Start
+	if configStream.LastConfig > last {
+		last = configStream.LastConfig
+	}
+	return configStream.Start <= f.To && last >= f.From &&
+		(f.Agent == "" || configStream.Agent == f.Agent)
+}
+
+func (f *OperationFilter) matchesHotKey(hotKey *HotKey) bool {
+	return (f.Agent == "" || hotKey.Agent == f.Agent) &&
+		(f.Bucket == "" || hotKey.Bucket == f.Bucket) &&
+		strings.HasPrefix(hotKey.Key, f.KeyPrefix)
+}
+
+// WindowInfo describes a stored capture window without its contents.
+type WindowInfo struct {
+	Start       int64 `json:"start"`
+	End         int64 `json:"end"`
+	Downsampled bool  `json:"downsampled"`
+	Operations  int   `json:"operations"`
+}
+
+// AgentHistogram is the latency histogram of one opcode on one agent over one capture window.
+// Histograms stored before opcodes were tracked have an empty Opcode and cover every opcode.
+type AgentHistogram struct {
+	Start     int64
+	End       int64
+	Agent     string
+	Opcode    string
+	Histogram *hdrhistogram.Histogram
+}
+
+// ValueSizeHistogram is the distribution of the value sizes of one opcode on one agent over one
+// capture window, for the operations that carried a value. Wire is the size as sent, Uncompressed
+// the size once snappy values are inflated, which is the wire size for values sent as is.
+type ValueSizeHistogram struct {
+	Start        int64
+	End          int64
+	Agent        string
+	Opcode       string
+	Wire         *hdrhistogram.Histogram
+	Uncompressed *hdrhistogram.Histogram
+}
+
+// Window is the merged result of one capture across all agents that answered.
+type Window struct {
+	Start       int64
+	End         int64
+	Downsampled bool
+	Operations  []*Operation
+	Histograms  []*AgentHistogram
+	SlowOps     []*SlowOp
+	HotKeys     []*HotKey
+	TLS         []*TLSConnection
+	// TLSHistograms are the TLS exchange latencies of each agent, kept apart from the operations.
+	TLSHistograms []*AgentHistogram
+	ValueSizes    []*ValueSizeHistogram
+	SubdocPaths   []*SubdocPath
+	DCP           []*DCPConnection
+	Notifications []*ClustermapNotification
+	NotMyVbuckets []*NotMyVbucket
+	HTTPRequests  []*HTTPRequest
+	ConfigStreams []*ConfigStream
+	// KeyPolicies is how each agent reported its keys in this window, see the agent key policy.
+	KeyPolicies map[string]string
+}
+
+// Store keeps the capture history. Records with keys come back with the key policy their agent
+// applied in their window. Ranges select the windows that ended within [from, to].
+type Store interface {
+	WriteWindow(window *Window) error
+	QueryRange(filter *OperationFilter) ([]*Operation, error)
+	QueryHistograms(from int64, to int64) ([]*AgentHistogram, error)
+	QueryWindows(from int64, to int64) ([]*WindowInfo, error)
+	QuerySlowOps(filter *OperationFilter) ([]*SlowOp, error)
+	// QueryHotKeys returns the hot keys of every agent and window in the range, only the agent,
+	// bucket and key prefix filters apply.
+	QueryHotKeys(filter *OperationFilter) ([]*HotKey, error)
+	// QueryTLSConnections returns the TLS connections of the windows in the range by window end,
+	// only the agent filter applies.
+	QueryTLSConnections(filter *OperationFilter) ([]*TLSConnection, error)
+	QueryTLSHistograms(from int64, to int64) ([]*AgentHistogram, error)
+	QueryValueSizeHistograms(from int64, to int64) ([]*ValueSizeHistogram, error)
+	// QuerySubdocPaths returns the subdoc paths of every agent and window in the range, the opcode
+	// filter selects the spec type and the agent, status and bucket filters apply.
+	QuerySubdocPaths(filter *OperationFilter) ([]*SubdocPath, error)
+	// QueryDCPConnections returns the DCP connections of the windows in the range by window end,
+	// only the agent filter applies.
+	QueryDCPConnections(filter *OperationFilter) ([]*DCPConnection, error)
+	// QueryClustermapNotifications returns the notifications captured within the range by their
+	// own timestamp, only the agent and bucket filters apply.
+	QueryClustermapNotifications(filter *OperationFilter) ([]*ClustermapNotification, error)
+	// QueryNotMyVbuckets returns the NOT_MY_VBUCKET responses captured within the range by their
+	// own timestamp, the agent, opcode and bucket filters apply.
+	QueryNotMyVbuckets(filter *OperationFilter) ([]*NotMyVbucket, error)
+	// QueryHTTPRequests returns the requests to the HTTP services sent within the range, only the
+	// agent filter applies.
+	QueryHTTPRequests(filter *OperationFilter) ([]*HTTPRequest, error)
+	// QueryConfigStreams returns the streaming config requests open at some point of the range,
+	// only the agent filter applies.
+	QueryConfigStreams(filter *OperationFilter) ([]*ConfigStream, error)
+	// ApplyRetention deletes windows that ended before expired, and drops the operations of windows
+	// that ended before downsampled while keeping every aggregate.
+	ApplyRetention(expired int64, downsampled int64) error
+	Close() error
+}
+
+func newStore(config *ResultsHistory) (Store, error) {
+	switch config.Backend {
+	case "", SQLITE_BACKEND:
+		return newSqliteStore(config.FileName)
+	case MEMORY_BACKEND:
+		return newMemoryStore(), nil
+	case FILE_BACKEND:
+		return newFileStore(config.FileName)
+	}
+	return nil, fmt.Errorf("Unknown history backend %v", config.Backend)
+}
+
+func newLatencyHistogram() *hdrhistogram.Histogram {
+	return hdrhistogram.New(1, MAX_LATENCY, 3)
+}
+
+// recordLatency records latency in histogram, clamped to the longest latency it can hold so that
+// the slowest operations still count towards the tail.
+func recordLatency(histogram *hdrhistogram.Histogram, latency int64) {
+	if latency > MAX_LATENCY {
+		latency = MAX_LATENCY
+	}
+	histogram.RecordValue(latency)
+}
+
+// HTTP requests run far longer than operations, a query can take minutes.
+func newHTTPLatencyHistogram() *hdrhistogram.Histogram {
+	return hdrhistogram.New(1, MAX_HTTP_LATENCY, 2)
+}
+
+// recordHTTPLatency records latency in histogram, clamped to the longest latency it can hold.
+func recordHTTPLatency(histogram *hdrhistogram.Histogram, latency int64) {
+	if latency > MAX_HTTP_LATENCY {
+		latency = MAX_HTTP_LATENCY
+	}
+	histogram.RecordValue(latency)
+}
+
+func newValueSizeHistogram() *hdrhistogram.Histogram {
+	return hdrhistogram.New(1, MAX_VALUE_SIZE, 2)
+}
+
+// recordValueSize records size in histogram, clamped to the largest size it can hold.
+func recordValueSize(histogram *hdrhistogram.Histogram, size uint32) {
+	if size > MAX_VALUE_SIZE {
+		size = MAX_VALUE_SIZE
+	}
+	histogram.RecordValue(int64(size))
+}
+
+// encodeHistogram serialises a histogram for storage, the counts are mostly zero so they compress well.
+func encodeHistogram(histogram *hdrhistogram.Histogram) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if err := json.NewEncoder(writer).Encode(histogram.Export()); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeHistogram(data []byte) (*hdrhistogram.Histogram, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot hdrhistogram.Snapshot
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return nil, err
+	}
+	return hdrhistogram.Import(&snapshot), nil
+}
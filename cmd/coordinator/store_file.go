@@ -0,0 +1,366 @@
+/*
+ * Copyright (c) 2017 Couchbase, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+type fileHistogram struct {
+	Agent     string `json:"agent"`
+	Opcode    string `json:"opcode,omitempty"`
+	Histogram []byte `json:"histogram"`
+}
+
+type fileValueSizeHistogram struct {
+	Agent        string `json:"agent"`
+	Opcode       string `json:"opcode"`
+	Wire         []byte `json:"wire"`
+	Uncompressed []byte `json:"uncompressed"`
+}
+
+// fileWindow is the on disk form of a window, one json document per line.
+type fileWindow struct {
+	Start       int64            `json:"start"`
+	End         int64            `json:"end"`
+	Downsampled bool             `json:"downsampled,omitempty"`
+	Operations  []*Operation     `json:"operations,omitempty"`
+	Histograms  []*fileHistogram `json:"histograms"`
+	SlowOps     []*SlowOp        `json:"slow_ops,omitempty"`
+	HotKeys     []*HotKey        `json:"hot_keys,omitempty"`
+	TLS         []*TLSConnection `json:"tls,omitempty"`
+	// TLSHistograms are the TLS exchange latencies of each agent.
+	TLSHistograms []*fileHistogram `json:"tls_histograms,omitempty"`
+	// ValueSizes are the value size distributions of each agent and opcode.
+	ValueSizes  []*fileValueSizeHistogram `json:"value_sizes,omitempty"`
+	SubdocPaths []*SubdocPath             `json:"subdoc_paths,omitempty"`
+	DCP         []*DCPConnection          `json:"dcp,omitempty"`
+	// Notifications are the cluster maps pushed to clients.
+	Notifications []*ClustermapNotification `json:"notifications,omitempty"`
+	NotMyVbuckets []*NotMyVbucket           `json:"not_my_vbuckets,omitempty"`
+	HTTPRequests  []*HTTPRequest            `json:"http_requests,omitempty"`
+	ConfigStreams []*ConfigStream           `json:"config_streams,omitempty"`
+	KeyPolicies   map[string]string         `json:"key_policies,omitempty"`
+}
+
+// fileStore appends every window to a file of json lines and answers queries from memory. The
+// file is only rewritten when retention drops or downsamples windows.
+type fileStore struct {
+	mutex  *sync.Mutex
+	file   string
+	fp     *os.File
+	memory *memoryStore
+}
+
+func newFileStore(file string) (*fileStore, error) {
+	s := &fileStore{
+		mutex:  &sync.Mutex{},
+		file:   file,
+		memory: newMemoryStore(),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	fp, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.fp = fp
+	return s, nil
+}
+
+func (s *fileStore) load() error {
+	fp, err := os.Open(s.file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	scanner := bufio.NewScanner(fp)
+	scanner.Buffer(make([]byte, 64*1024), 256*1024*1024)
+	for scanner.Scan() {
+		var stored fileWindow
+		if err := json.Unmarshal(scanner.Bytes(), &stored); err != nil {
+			return err
+		}
+		window := &Window{
+			Start:         stored.Start,
+			End:           stored.End,
+			Downsampled:   stored.Downsampled,
+			Operations:    stored.Operations,
+			SlowOps:       stored.SlowOps,
+			HotKeys:       stored.HotKeys,
+			TLS:           stored.TLS,
+			SubdocPaths:   stored.SubdocPaths,
+			DCP:           stored.DCP,
+			Notifications: stored.Notifications,
+			NotMyVbuckets: stored.NotMyVbuckets,
+			HTTPRequests:  stored.HTTPRequests,
+			ConfigStreams: stored.ConfigStreams,
+			KeyPolicies:   stored.KeyPolicies,
+		}
+		if window.Histograms, err = decodeFileHistograms(&stored, stored.Histograms); err != nil {
+			return err
+		}
+		if window.TLSHistograms, err = decodeFileHistograms(&stored, stored.TLSHistograms); err != nil {
+			return err
+		}
+		if window.ValueSizes, err = decodeFileValueSizes(&stored); err != nil {
+			return err
+		}
+		s.memory.WriteWindow(window)
+	}
+	return scanner.Err()
+}
+
+func decodeFileHistograms(stored *fileWindow, histograms []*fileHistogram) ([]*AgentHistogram, error) {
+	var decoded []*AgentHistogram
+	for _, histogram := range histograms {
+		decodedHistogram, err := decodeHistogram(histogram.Histogram)
+		if err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, &AgentHistogram{
+			Start:     stored.Start,
+			End:       stored.End,
+			Agent:     histogram.Agent,
+			Opcode:    histogram.Opcode,
+			Histogram: decodedHistogram,
+		})
+	}
+	return decoded, nil
+}
+
+func encodeFileHistograms(histograms []*AgentHistogram) ([]*fileHistogram, error) {
+	var encoded []*fileHistogram
+	for _, histogram := range histograms {
+		encodedHistogram, err := encodeHistogram(histogram.Histogram)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, &fileHistogram{
+			Agent:     histogram.Agent,
+			Opcode:    histogram.Opcode,
+			Histogram: encodedHistogram,
+		})
+	}
+	return encoded, nil
+}
+
+func decodeFileValueSizes(stored *fileWindow) ([]*ValueSizeHistogram, error) {
+	var decoded []*ValueSizeHistogram
+	for _, histogram := range stored.ValueSizes {
+		wire, err := decodeHistogram(histogram.Wire)
+		if err != nil {
+			return nil, err
+		}
+		uncompressed, err := decodeHistogram(histogram.Uncompressed)
+		if err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, &ValueSizeHistogram{
+			Start:        stored.Start,
+			End:          stored.End,
+			Agent:        histogram.Agent,
+			Opcode:       histogram.Opcode,
+			Wire:         wire,
+			Uncompressed: uncompressed,
+		})
+	}
+	return decoded, nil
+}
+
+func encodeFileValueSizes(histograms []*ValueSizeHistogram) ([]*fileValueSizeHistogram, error) {
+	var encoded []*fileValueSizeHistogram
+	for _, histogram := range histograms {
+		wire, err := encodeHistogram(histogram.Wire)
+		if err != nil {
+			return nil, err
+		}
+		uncompressed, err := encodeHistogram(histogram.Uncompressed)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, &fileValueSizeHistogram{
+			Agent:        histogram.Agent,
+			Opcode:       histogram.Opcode,
+			Wire:         wire,
+			Uncompressed: uncompressed,
+		})
+	}
+	return encoded, nil
+}
+
+func encodeWindow(window *Window) ([]byte, error) {
+	stored := &fileWindow{
+		Start:         window.Start,
+		End:           window.End,
+		Downsampled:   window.Downsampled,
+		Operations:    window.Operations,
+		SlowOps:       window.SlowOps,
+		HotKeys:       window.HotKeys,
+		TLS:           window.TLS,
+		SubdocPaths:   window.SubdocPaths,
+		DCP:           window.DCP,
+		Notifications: window.Notifications,
+		NotMyVbuckets: window.NotMyVbuckets,
+		HTTPRequests:  window.HTTPRequests,
+		ConfigStreams: window.ConfigStreams,
+		KeyPolicies:   window.KeyPolicies,
+	}
+	var err error
+	if stored.Histograms, err = encodeFileHistograms(window.Histograms); err != nil {
+		return nil, err
+	}
+	if stored.TLSHistograms, err = encodeFileHistograms(window.TLSHistograms); err != nil {
+		return nil, err
+	}
+	if stored.ValueSizes, err = encodeFileValueSizes(window.ValueSizes); err != nil {
+		return nil, err
+	}
+	line, err := json.Marshal(stored)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+func (s *fileStore) WriteWindow(window *Window) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	line, err := encodeWindow(window)
+	if err != nil {
+		return err
+	}
+	if _, err := s.fp.Write(line); err != nil {
+		return err
+	}
+	return s.memory.WriteWindow(window)
+}
+
+func (s *fileStore) QueryRange(filter *OperationFilter) ([]*Operation, error) {
+	return s.memory.QueryRange(filter)
+}
+
+func (s *fileStore) QueryHistograms(from int64, to int64) ([]*AgentHistogram, error) {
+	return s.memory.QueryHistograms(from, to)
+}
+
+func (s *fileStore) QueryWindows(from int64, to int64) ([]*WindowInfo, error) {
+	return s.memory.QueryWindows(from, to)
+}
+
+func (s *fileStore) QuerySlowOps(filter *OperationFilter) ([]*SlowOp, error) {
+	return s.memory.QuerySlowOps(filter)
+}
+
+func (s *fileStore) QueryHotKeys(filter *OperationFilter) ([]*HotKey, error) {
+	return s.memory.QueryHotKeys(filter)
+}
+
+func (s *fileStore) QueryTLSConnections(filter *OperationFilter) ([]*TLSConnection, error) {
+	return s.memory.QueryTLSConnections(filter)
+}
+
+func (s *fileStore) QueryTLSHistograms(from int64, to int64) ([]*AgentHistogram, error) {
+	return s.memory.QueryTLSHistograms(from, to)
+}
+
+func (s *fileStore) QueryValueSizeHistograms(from int64, to int64) ([]*ValueSizeHistogram, error) {
+	return s.memory.QueryValueSizeHistograms(from, to)
+}
+
+func (s *fileStore) QuerySubdocPaths(filter *OperationFilter) ([]*SubdocPath, error) {
+	return s.memory.QuerySubdocPaths(filter)
+}
+
+func (s *fileStore) QueryDCPConnections(filter *OperationFilter) ([]*DCPConnection, error) {
+	return s.memory.QueryDCPConnections(filter)
+}
+
+func (s *fileStore) QueryClustermapNotifications(filter *OperationFilter) ([]*ClustermapNotification, error) {
+	return s.memory.QueryClustermapNotifications(filter)
+}
+
+func (s *fileStore) QueryNotMyVbuckets(filter *OperationFilter) ([]*NotMyVbucket, error) {
+	return s.memory.QueryNotMyVbuckets(filter)
+}
+
+func (s *fileStore) QueryHTTPRequests(filter *OperationFilter) ([]*HTTPRequest, error) {
+	return s.memory.QueryHTTPRequests(filter)
+}
+
+func (s *fileStore) QueryConfigStreams(filter *OperationFilter) ([]*ConfigStream, error) {
+	return s.memory.QueryConfigStreams(filter)
+}
+
+// ApplyRetention compacts the file by writing the retained windows to a new file and renaming it
+// over the old one, so a crash never leaves a half written history behind.
+func (s *fileStore) ApplyRetention(expired int64, downsampled int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.memory.mutex.Lock()
+	changed := s.memory.retain(expired, downsampled)
+	windows := s.memory.windows
+	s.memory.mutex.Unlock()
+	if !changed {
+		return nil
+	}
+
+	tmpFile := s.file + ".tmp"
+	fp, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(fp)
+	for _, window := range windows {
+		line, err := encodeWindow(window)
+		if err != nil {
+			fp.Close()
+			return err
+		}
+		if _, err := writer.Write(line); err != nil {
+			fp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		fp.Close()
+		return err
+	}
+	if err := fp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, s.file); err != nil {
+		return err
+	}
+
+	s.fp.Close()
+	s.fp, err = os.OpenFile(s.file, os.O_APPEND|os.O_WRONLY, 0644)
+	return err
+}
+
+func (s *fileStore) Close() error {
+	return s.fp.Close()
+}
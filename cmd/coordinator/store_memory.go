@@ -0,0 +1,363 @@
+/*
+ * Copyright (c) 2017 Couchbase, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// memoryStore keeps the history in process, it is lost on restart and is meant for tests and
+// short lived coordinators.
+type memoryStore struct {
+	mutex   *sync.Mutex
+	windows []*Window
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		mutex: &sync.Mutex{},
+	}
+}
+
+func (s *memoryStore) WriteWindow(window *Window) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.windows = append(s.windows, window)
+	sort.SliceStable(s.windows, func(i, j int) bool {
+		return s.windows[i].End < s.windows[j].End
+	})
+	return nil
+}
+
+func (s *memoryStore) QueryRange(filter *OperationFilter) ([]*Operation, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var operations []*Operation
+	skipped := 0
+	for _, window := range s.windows {
+		if window.End < filter.From || window.End > filter.To {
+			continue
+		}
+		for _, op := range window.Operations {
+			if !filter.matches(op) {
+				continue
+			}
+			if skipped < filter.Offset {
+				skipped++
+				continue
+			}
+			if filter.Limit > 0 && len(operations) >= filter.Limit {
+				return operations, nil
+			}
+			copied := *op
+			copied.Timestamp = window.End
+			operations = append(operations, &copied)
+		}
+	}
+	return operations, nil
+}
+
+func (s *memoryStore) QueryHistograms(from int64, to int64) ([]*AgentHistogram, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var histograms []*AgentHistogram
+	for _, window := range s.windows {
+		if window.End >= from && window.End <= to {
+			for _, histogram := range window.Histograms {
+				histograms = append(histograms, &AgentHistogram{
+					Start:     window.Start,
+					End:       window.End,
+					Agent:     histogram.Agent,
+					Opcode:    histogram.Opcode,
+					Histogram: histogram.Histogram,
+				})
+			}
+		}
+	}
+	return histograms, nil
+}
+
+func (s *memoryStore) QueryTLSHistograms(from int64, to int64) ([]*AgentHistogram, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var histograms []*AgentHistogram
+	for _, window := range s.windows {
+		if window.End >= from && window.End <= to {
+			histograms = append(histograms, window.TLSHistograms...)
+		}
+	}
+	return histograms, nil
+}
+
+func (s *memoryStore) QueryValueSizeHistograms(from int64, to int64) ([]*ValueSizeHistogram, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var histograms []*ValueSizeHistogram
+	for _, window := range s.windows {
+		if window.End >= from && window.End <= to {
+			histograms = append(histograms, window.ValueSizes...)
+		}
+	}
+	return histograms, nil
+}
+
+func (s *memoryStore) QueryWindows(from int64, to int64) ([]*WindowInfo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var windows []*WindowInfo
+	for _, window := range s.windows {
+		if window.End >= from && window.End <= to {
+			windows = append(windows, &WindowInfo{
+				Start:       window.Start,
+				End:         window.End,
+				Downsampled: window.Downsampled,
+				Operations:  len(window.Operations),
+			})
+		}
+	}
+	return windows, nil
+}
+
+func (s *memoryStore) QuerySlowOps(filter *OperationFilter) ([]*SlowOp, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var slowOps []*SlowOp
+	for _, window := range s.windows {
+		for _, op := range window.SlowOps {
+			if filter.matchesSlowOp(op) {
+				slowOps = append(slowOps, op)
+			}
+		}
+	}
+	sort.SliceStable(slowOps, func(i, j int) bool {
+		return slowOps[i].Timestamp < slowOps[j].Timestamp
+	})
+
+	if filter.Offset >= len(slowOps) {
+		return nil, nil
+	}
+	slowOps = slowOps[filter.Offset:]
+	if filter.Limit > 0 && len(slowOps) > filter.Limit {
+		slowOps = slowOps[:filter.Limit]
+	}
+	return slowOps, nil
+}
+
+func (s *memoryStore) QueryHotKeys(filter *OperationFilter) ([]*HotKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var hotKeys []*HotKey
+	for _, window := range s.windows {
+		if window.End < filter.From || window.End > filter.To {
+			continue
+		}
+		for _, hotKey := range window.HotKeys {
+			if filter.matchesHotKey(hotKey) {
+				hotKeys = append(hotKeys, hotKey)
+			}
+		}
+	}
+	return hotKeys, nil
+}
+
+func (s *memoryStore) QuerySubdocPaths(filter *OperationFilter) ([]*SubdocPath, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var paths []*SubdocPath
+	for _, window := range s.windows {
+		if window.End < filter.From || window.End > filter.To {
+			continue
+		}
+		for _, path := range window.SubdocPaths {
+			if filter.matchesSubdocPath(path) {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths, nil
+}
+
+func (s *memoryStore) QueryClustermapNotifications(filter *OperationFilter) ([]*ClustermapNotification, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var notifications []*ClustermapNotification
+	for _, window := range s.windows {
+		for _, notification := range window.Notifications {
+			if filter.matchesNotification(notification) {
+				notifications = append(notifications, notification)
+			}
+		}
+	}
+	sort.SliceStable(notifications, func(i, j int) bool {
+		return notifications[i].Timestamp < notifications[j].Timestamp
+	})
+	return notifications, nil
+}
+
+func (s *memoryStore) QueryNotMyVbuckets(filter *OperationFilter) ([]*NotMyVbucket, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var ops []*NotMyVbucket
+	for _, window := range s.windows {
+		for _, op := range window.NotMyVbuckets {
+			if filter.matchesNotMyVbucket(op) {
+				ops = append(ops, op)
+			}
+		}
+	}
+	sort.SliceStable(ops, func(i, j int) bool {
+		return ops[i].Timestamp < ops[j].Timestamp
+	})
+	return ops, nil
+}
+
+func (s *memoryStore) QueryHTTPRequests(filter *OperationFilter) ([]*HTTPRequest, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var requests []*HTTPRequest
+	for _, window := range s.windows {
+		for _, request := range window.HTTPRequests {
+			if filter.matchesHTTPRequest(request) {
+				requests = append(requests, request)
+			}
+		}
+	}
+	sort.SliceStable(requests, func(i, j int) bool {
+		return requests[i].Timestamp < requests[j].Timestamp
+	})
+	return requests, nil
+}
+
+func (s *memoryStore) QueryConfigStreams(filter *OperationFilter) ([]*ConfigStream, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var configStreams []*ConfigStream
+	for _, window := range s.windows {
+		for _, configStream := range window.ConfigStreams {
+			if filter.matchesConfigStream(configStream) {
+				configStreams = append(configStreams, configStream)
+			}
+		}
+	}
+	sort.SliceStable(configStreams, func(i, j int) bool {
+		return configStreams[i].Start < configStreams[j].Start
+	})
+	return configStreams, nil
+}
+
+func (s *memoryStore) QueryTLSConnections(filter *OperationFilter) ([]*TLSConnection, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var connections []*TLSConnection
+	for _, window := range s.windows {
+		if window.End < filter.From || window.End > filter.To {
+			continue
+		}
+		for _, connection := range window.TLS {
+			if filter.Agent == "" || connection.Agent == filter.Agent {
+				connections = append(connections, connection)
+			}
+		}
+	}
+	sort.SliceStable(connections, func(i, j int) bool {
+		return connections[i].End < connections[j].End
+	})
+
+	if filter.Offset >= len(connections) {
+		return nil, nil
+	}
+	connections = connections[filter.Offset:]
+	if filter.Limit > 0 && len(connections) > filter.Limit {
+		connections = connections[:filter.Limit]
+	}
+	return connections, nil
+}
+
+func (s *memoryStore) QueryDCPConnections(filter *OperationFilter) ([]*DCPConnection, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var connections []*DCPConnection
+	for _, window := range s.windows {
+		if window.End < filter.From || window.End > filter.To {
+			continue
+		}
+		for _, connection := range window.DCP {
+			if filter.Agent == "" || connection.Agent == filter.Agent {
+				connections = append(connections, connection)
+			}
+		}
+	}
+	sort.SliceStable(connections, func(i, j int) bool {
+		return connections[i].End < connections[j].End
+	})
+
+	if filter.Offset >= len(connections) {
+		return nil, nil
+	}
+	connections = connections[filter.Offset:]
+	if filter.Limit > 0 && len(connections) > filter.Limit {
+		connections = connections[:filter.Limit]
+	}
+	return connections, nil
+}
+
+func (s *memoryStore) ApplyRetention(expired int64, downsampled int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.retain(expired, downsampled)
+	return nil
+}
+
+// retain applies the retention and reports whether any window was changed.
+func (s *memoryStore) retain(expired int64, downsampled int64) bool {
+	changed := false
+	var windows []*Window
+	for _, window := range s.windows {
+		if window.End < expired {
+			changed = true
+			continue
+		}
+		if window.End < downsampled && !window.Downsampled {
+			window.Operations = nil
+			window.Downsampled = true
+			changed = true
+		}
+		windows = append(windows, window)
+	}
+	s.windows = windows
+	return changed
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,955 @@
+/*
+ * Copyright (c) 2017 Couchbase, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"sync"
+	"unicode/utf8"
+)
+
+// Schema migrations, applied in order and tracked with the sqlite user_version so that an
+// existing history file is upgraded in place instead of being recreated.
+var migrations = []string{
+	`drop table if exists CaptureResults;
+	create table agents (id integer primary key, hostname text not null unique);
+	create table captures (id integer primary key, start integer not null, end integer not null, downsampled integer not null default 0);
+	create index captures_start on captures(start);
+	create index captures_end on captures(end);
+	create table operations (capture_id integer not null, agent_id integer not null, opaque_streamId text not null,
+		opcode text, key text, latency integer not null);
+	create index operations_capture on operations(capture_id);
+	create table histograms (capture_id integer not null, agent_id integer not null, histogram blob not null,
+		primary key (capture_id, agent_id));`,
+	`alter table operations add column status text;
+	alter table operations add column bucket text;`,
+	`create table opcode_histograms (capture_id integer not null, agent_id integer not null, opcode text not null default '',
+		histogram blob not null, primary key (capture_id, agent_id, opcode));
+	insert into opcode_histograms(capture_id, agent_id, histogram) select capture_id, agent_id, histogram from histograms;
+	drop table histograms;
+	alter table opcode_histograms rename to histograms;`,
+	`create table slow_ops (capture_id integer not null, agent_id integer not null, timestamp integer not null,
+		client text, server text, opaque integer, opcode text, status text, vbucket integer, bucket text, key text,
+		value_size integer, latency integer not null, server_duration integer, pipeline_depth integer);
+	create index slow_ops_capture on slow_ops(capture_id);
+	create index slow_ops_timestamp on slow_ops(timestamp);`,
+	`create table hot_keys (capture_id integer not null, agent_id integer not null, bucket text, key text not null,
+		count integer not null, latency integer not null);
+	create index hot_keys_capture on hot_keys(capture_id);`,
+	`create table capture_agents (capture_id integer not null, agent_id integer not null, key_policy text,
+		primary key (capture_id, agent_id));`,
+	`create table tls_connections (capture_id integer not null, agent_id integer not null, client text, server text,
+		version text, cipher_suite text, decryption text, records integer, exchanges integer, latency integer,
+		max_latency integer);
+	create index tls_connections_capture on tls_connections(capture_id);`,
+	`alter table tls_connections add column handshake_duration integer;
+	alter table tls_connections add column resumed integer;
+	alter table tls_connections add column alerts integer;
+	alter table tls_connections add column last_alert text;
+	create table tls_histograms (capture_id integer not null, agent_id integer not null, histogram blob not null,
+		primary key (capture_id, agent_id));`,
+	`alter table operations add column datatype text;
+	alter table operations add column value_size integer;
+	alter table operations add column uncompressed_size integer;
+	create table value_size_histograms (capture_id integer not null, agent_id integer not null, opcode text not null,
+		wire blob not null, uncompressed blob not null, primary key (capture_id, agent_id, opcode));`,
+	`create table subdoc_paths (capture_id integer not null, agent_id integer not null, bucket text, opcode text,
+		path text, status text, count integer not null, latency integer not null, max_latency integer not null);
+	create index subdoc_paths_capture on subdoc_paths(capture_id);`,
+	`create table dcp_connections (capture_id integer not null, agent_id integer not null, client text, server text,
+		name text, producer integer, buffer_size integer, max_unacked integer, acked integer, stalls integer,
+		stall_time integer, max_stall integer, streams text);
+	create index dcp_connections_capture on dcp_connections(capture_id);`,
+	`create table clustermap_notifications (capture_id integer not null, agent_id integer not null,
+		timestamp integer not null, client text, server text, bucket text, epoch integer, revision integer, size integer);
+	create index clustermap_notifications_capture on clustermap_notifications(capture_id);
+	create index clustermap_notifications_timestamp on clustermap_notifications(timestamp);`,
+	`create table not_my_vbuckets (capture_id integer not null, agent_id integer not null, timestamp integer not null,
+		client text, server text, bucket text, vbucket integer, opcode text, latency integer, epoch integer,
+		revision integer, map_size integer, attempt integer);
+	create index not_my_vbuckets_capture on not_my_vbuckets(capture_id);
+	create index not_my_vbuckets_timestamp on not_my_vbuckets(timestamp);`,
+	`create table http_requests (capture_id integer not null, agent_id integer not null, timestamp integer not null,
+		client text, server text, service text, method text, path text, status integer, latency integer,
+		first_byte integer, request_size integer, response_size integer, statement text, client_context_id text,
+		statement_policy text);
+	create index http_requests_capture on http_requests(capture_id);
+	create index http_requests_timestamp on http_requests(timestamp);`,
+	`create table config_streams (capture_id integer not null, agent_id integer not null, client text, server text,
+		path text, resumed integer, start integer not null, first_config integer, last_config integer,
+		configs integer, bytes integer, min_interval integer, max_interval integer);
+	create index config_streams_capture on config_streams(capture_id);
+	create index config_streams_start on config_streams(start);`,
+}
+
+type sqliteStore struct {
+	mutex    *sync.Mutex
+	db       *sql.DB
+	agentIds map[string]int64
+}
+
+func newSqliteStore(file string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite3", file)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateStore(db); err != nil {
+		return nil, fmt.Errorf("Unable to migrate the store %v due to %v", file, err)
+	}
+	return &sqliteStore{
+		mutex:    &sync.Mutex{},
+		db:       db,
+		agentIds: make(map[string]int64),
+	}, nil
+}
+
+func migrateStore(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("pragma user_version;").Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return err
+		}
+		// pragmas can not take bound parameters
+		if _, err := tx.Exec(fmt.Sprintf("pragma user_version = %d;", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// agentId returns the id of the agent in the store, adding the agent on first use.
+func (s *sqliteStore) agentId(tx *sql.Tx, hostname string) (int64, error) {
+	if id, ok := s.agentIds[hostname]; ok {
+		return id, nil
+	}
+	if _, err := tx.Exec("insert or ignore into agents(hostname) values(?);", hostname); err != nil {
+		return 0, err
+	}
+	var id int64
+	if err := tx.QueryRow("select id from agents where hostname = ?;", hostname).Scan(&id); err != nil {
+		return 0, err
+	}
+	s.agentIds[hostname] = id
+	return id, nil
+}
+
+func (s *sqliteStore) WriteWindow(window *Window) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("insert into captures(start, end) values(?, ?);", window.Start, window.End)
+	if err != nil {
+		return err
+	}
+	captureId, _ := result.LastInsertId()
+
+	stmt, err := tx.Prepare(`insert into operations(capture_id, agent_id, opaque_streamId, opcode, status, bucket, key, latency,
+		datatype, value_size, uncompressed_size) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, op := range window.Operations {
+		agentId, err := s.agentId(tx, op.Agent)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.Exec(captureId, agentId, op.OpaqueStreamId, op.Opcode, op.Status, op.Bucket, op.Key, op.Latency,
+			op.Datatype, op.ValueSize, op.UncompressedSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, histogram := range window.Histograms {
+		agentId, err := s.agentId(tx, histogram.Agent)
+		if err != nil {
+			return err
+		}
+		encoded, err := encodeHistogram(histogram.Histogram)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("insert into histograms(capture_id, agent_id, opcode, histogram) values(?, ?, ?, ?);",
+			captureId, agentId, histogram.Opcode, encoded)
+		if err != nil {
+			return err
+		}
+	}
+	slowStmt, err := tx.Prepare(`insert into slow_ops(capture_id, agent_id, timestamp, client, server, opaque, opcode,
+		status, vbucket, bucket, key, value_size, latency, server_duration, pipeline_depth)
+		values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer slowStmt.Close()
+	for _, op := range window.SlowOps {
+		agentId, err := s.agentId(tx, op.Agent)
+		if err != nil {
+			return err
+		}
+		_, err = slowStmt.Exec(captureId, agentId, op.Timestamp, op.Client, op.Server, op.Opaque, op.Opcode,
+			op.Status, op.Vbucket, op.Bucket, op.Key, op.ValueSize, op.Latency, op.ServerDuration, op.PipelineDepth)
+		if err != nil {
+			return err
+		}
+	}
+
+	for agent, keyPolicy := range window.KeyPolicies {
+		agentId, err := s.agentId(tx, agent)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("insert into capture_agents(capture_id, agent_id, key_policy) values(?, ?, ?);", captureId, agentId, keyPolicy)
+		if err != nil {
+			return err
+		}
+	}
+
+	hotStmt, err := tx.Prepare("insert into hot_keys(capture_id, agent_id, bucket, key, count, latency) values(?, ?, ?, ?, ?, ?);")
+	if err != nil {
+		return err
+	}
+	defer hotStmt.Close()
+	for _, hotKey := range window.HotKeys {
+		agentId, err := s.agentId(tx, hotKey.Agent)
+		if err != nil {
+			return err
+		}
+		if _, err := hotStmt.Exec(captureId, agentId, hotKey.Bucket, hotKey.Key, int64(hotKey.Count), int64(hotKey.Latency)); err != nil {
+			return err
+		}
+	}
+
+	tlsStmt, err := tx.Prepare(`insert into tls_connections(capture_id, agent_id, client, server, version, cipher_suite,
+		decryption, records, exchanges, latency, max_latency, handshake_duration, resumed, alerts, last_alert)
+		values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer tlsStmt.Close()
+	for _, connection := range window.TLS {
+		agentId, err := s.agentId(tx, connection.Agent)
+		if err != nil {
+			return err
+		}
+		_, err = tlsStmt.Exec(captureId, agentId, connection.Client, connection.Server, connection.Version,
+			connection.CipherSuite, connection.Decryption, int64(connection.Records), int64(connection.Exchanges),
+			connection.Latency, connection.MaxLatency, connection.HandshakeDuration, connection.Resumed,
+			connection.Alerts, connection.LastAlert)
+		if err != nil {
+			return err
+		}
+	}
+	for _, histogram := range window.TLSHistograms {
+		agentId, err := s.agentId(tx, histogram.Agent)
+		if err != nil {
+			return err
+		}
+		encoded, err := encodeHistogram(histogram.Histogram)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("insert into tls_histograms(capture_id, agent_id, histogram) values(?, ?, ?);",
+			captureId, agentId, encoded)
+		if err != nil {
+			return err
+		}
+	}
+	for _, histogram := range window.ValueSizes {
+		agentId, err := s.agentId(tx, histogram.Agent)
+		if err != nil {
+			return err
+		}
+		wire, err := encodeHistogram(histogram.Wire)
+		if err != nil {
+			return err
+		}
+		uncompressed, err := encodeHistogram(histogram.Uncompressed)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("insert into value_size_histograms(capture_id, agent_id, opcode, wire, uncompressed) values(?, ?, ?, ?, ?);",
+			captureId, agentId, histogram.Opcode, wire, uncompressed)
+		if err != nil {
+			return err
+		}
+	}
+
+	subdocStmt, err := tx.Prepare(`insert into subdoc_paths(capture_id, agent_id, bucket, opcode, path, status, count,
+		latency, max_latency) values(?, ?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer subdocStmt.Close()
+	for _, path := range window.SubdocPaths {
+		agentId, err := s.agentId(tx, path.Agent)
+		if err != nil {
+			return err
+		}
+		_, err = subdocStmt.Exec(captureId, agentId, path.Bucket, path.Spec, path.Path, path.Status, int64(path.Count),
+			path.Latency, path.MaxLatency)
+		if err != nil {
+			return err
+		}
+	}
+
+	dcpStmt, err := tx.Prepare(`insert into dcp_connections(capture_id, agent_id, client, server, name, producer,
+		buffer_size, max_unacked, acked, stalls, stall_time, max_stall, streams)
+		values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer dcpStmt.Close()
+	for _, connection := range window.DCP {
+		agentId, err := s.agentId(tx, connection.Agent)
+		if err != nil {
+			return err
+		}
+		// streams are only ever read back with their connection
+		streams, err := json.Marshal(connection.Streams)
+		if err != nil {
+			return err
+		}
+		_, err = dcpStmt.Exec(captureId, agentId, connection.Client, connection.Server, connection.Name,
+			connection.Producer, connection.BufferSize, int64(connection.MaxUnacked), int64(connection.Acked),
+			connection.Stalls, connection.StallTime, connection.MaxStall, string(streams))
+		if err != nil {
+			return err
+		}
+	}
+
+	notificationStmt, err := tx.Prepare(`insert into clustermap_notifications(capture_id, agent_id, timestamp, client,
+		server, bucket, epoch, revision, size) values(?, ?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer notificationStmt.Close()
+	for _, notification := range window.Notifications {
+		agentId, err := s.agentId(tx, notification.Agent)
+		if err != nil {
+			return err
+		}
+		_, err = notificationStmt.Exec(captureId, agentId, notification.Timestamp, notification.Client, notification.Server,
+			notification.Bucket, notification.Epoch, notification.Revision, notification.Size)
+		if err != nil {
+			return err
+		}
+	}
+
+	notMyVbucketStmt, err := tx.Prepare(`insert into not_my_vbuckets(capture_id, agent_id, timestamp, client, server,
+		bucket, vbucket, opcode, latency, epoch, revision, map_size, attempt) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer notMyVbucketStmt.Close()
+	for _, op := range window.NotMyVbuckets {
+		agentId, err := s.agentId(tx, op.Agent)
+		if err != nil {
+			return err
+		}
+		_, err = notMyVbucketStmt.Exec(captureId, agentId, op.Timestamp, op.Client, op.Server, op.Bucket, op.Vbucket,
+			op.Opcode, op.Latency, op.Epoch, op.Revision, op.MapSize, op.Attempt)
+		if err != nil {
+			return err
+		}
+	}
+
+	httpStmt, err := tx.Prepare(`insert into http_requests(capture_id, agent_id, timestamp, client, server, service,
+		method, path, status, latency, first_byte, request_size, response_size, statement, client_context_id,
+		statement_policy) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer httpStmt.Close()
+	for _, request := range window.HTTPRequests {
+		agentId, err := s.agentId(tx, request.Agent)
+		if err != nil {
+			return err
+		}
+		_, err = httpStmt.Exec(captureId, agentId, request.Timestamp, request.Client, request.Server, request.Service,
+			request.Method, request.Path, request.Status, request.Latency, request.FirstByte, request.RequestSize,
+			request.ResponseSize, request.Statement, request.ClientContextId, request.StatementPolicy)
+		if err != nil {
+			return err
+		}
+	}
+
+	configStreamStmt, err := tx.Prepare(`insert into config_streams(capture_id, agent_id, client, server, path,
+		resumed, start, first_config, last_config, configs, bytes, min_interval, max_interval)
+		values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer configStreamStmt.Close()
+	for _, configStream := range window.ConfigStreams {
+		agentId, err := s.agentId(tx, configStream.Agent)
+		if err != nil {
+			return err
+		}
+		_, err = configStreamStmt.Exec(captureId, agentId, configStream.Client, configStream.Server, configStream.Path,
+			configStream.Resumed, configStream.Start, configStream.FirstConfig, configStream.LastConfig,
+			configStream.Configs, configStream.Bytes, configStream.MinInterval, configStream.MaxInterval)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// filterConditions appends the filters other than the time range to a where clause on table.
+func filterConditions(table string, filter *OperationFilter, query string, args []interface{}) (string, []interface{}) {
+	if filter.Agent != "" {
+		query += " and agents.hostname = ?"
+		args = append(args, filter.Agent)
+	}
+	if filter.Opcode != "" {
+		query += " and " + table + ".opcode = ?"
+		args = append(args, filter.Opcode)
+	}
+	if filter.Status != "" {
+		query += " and " + table + ".status = ?"
+		args = append(args, filter.Status)
+	}
+	if filter.Bucket != "" {
+		query += " and " + table + ".bucket = ?"
+		args = append(args, filter.Bucket)
+	}
+	if filter.KeyPrefix != "" {
+		// substr rather than like so that % and _ in the prefix are matched literally, it counts
+		// characters rather than bytes
+		query += " and substr(" + table + ".key, 1, ?) = ?"
+		args = append(args, utf8.RuneCountInString(filter.KeyPrefix), filter.KeyPrefix)
+	}
+	return query, args
+}
+
+func pageClause(filter *OperationFilter, query string, args []interface{}) (string, []interface{}) {
+	if filter.Limit > 0 {
+		query += " limit ? offset ?"
+		args = append(args, filter.Limit, filter.Offset)
+	} else if filter.Offset > 0 {
+		query += " limit -1 offset ?"
+		args = append(args, filter.Offset)
+	}
+	return query, args
+}
+
+func (s *sqliteStore) QueryRange(filter *OperationFilter) ([]*Operation, error) {
+	query := `select captures.end, agents.hostname, operations.opaque_streamId, operations.opcode, operations.status,
+		operations.bucket, operations.key, operations.latency, capture_agents.key_policy, operations.datatype,
+		operations.value_size, operations.uncompressed_size
+		from operations join captures on captures.id = operations.capture_id join agents on agents.id = operations.agent_id
+		left join capture_agents on capture_agents.capture_id = operations.capture_id and capture_agents.agent_id = operations.agent_id
+		where captures.end >= ? and captures.end <= ?`
+	args := []interface{}{filter.From, filter.To}
+	query, args = filterConditions("operations", filter, query, args)
+	query += " order by captures.end, operations.rowid"
+	query, args = pageClause(filter, query, args)
+
+	rows, err := s.db.Query(query+";", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var operations []*Operation
+	for rows.Next() {
+		op := &Operation{}
+		var opcode, status, bucket, key, keyPolicy, datatype sql.NullString
+		var valueSize, uncompressedSize sql.NullInt64
+		if err := rows.Scan(&op.Timestamp, &op.Agent, &op.OpaqueStreamId, &opcode, &status, &bucket, &key, &op.Latency,
+			&keyPolicy, &datatype, &valueSize, &uncompressedSize); err != nil {
+			return nil, err
+		}
+		op.Datatype = datatype.String
+		op.ValueSize = uint32(valueSize.Int64)
+		op.UncompressedSize = uint32(uncompressedSize.Int64)
+		op.KeyPolicy = keyPolicy.String
+		op.Opcode = opcode.String
+		op.Status = status.String
+		op.Bucket = bucket.String
+		op.Key = key.String
+		operations = append(operations, op)
+	}
+	return operations, rows.Err()
+}
+
+func (s *sqliteStore) QueryHistograms(from int64, to int64) ([]*AgentHistogram, error) {
+	rows, err := s.db.Query(`select captures.start, captures.end, agents.hostname, histograms.opcode, histograms.histogram
+		from histograms join captures on captures.id = histograms.capture_id join agents on agents.id = histograms.agent_id
+		where captures.end >= ? and captures.end <= ? order by captures.end;`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var histograms []*AgentHistogram
+	for rows.Next() {
+		histogram := &AgentHistogram{}
+		var encoded []byte
+		if err := rows.Scan(&histogram.Start, &histogram.End, &histogram.Agent, &histogram.Opcode, &encoded); err != nil {
+			return nil, err
+		}
+		if histogram.Histogram, err = decodeHistogram(encoded); err != nil {
+			return nil, err
+		}
+		histograms = append(histograms, histogram)
+	}
+	return histograms, rows.Err()
+}
+
+func (s *sqliteStore) QueryTLSHistograms(from int64, to int64) ([]*AgentHistogram, error) {
+	rows, err := s.db.Query(`select captures.start, captures.end, agents.hostname, tls_histograms.histogram
+		from tls_histograms join captures on captures.id = tls_histograms.capture_id join agents on agents.id = tls_histograms.agent_id
+		where captures.end >= ? and captures.end <= ? order by captures.end;`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var histograms []*AgentHistogram
+	for rows.Next() {
+		histogram := &AgentHistogram{}
+		var encoded []byte
+		if err := rows.Scan(&histogram.Start, &histogram.End, &histogram.Agent, &encoded); err != nil {
+			return nil, err
+		}
+		if histogram.Histogram, err = decodeHistogram(encoded); err != nil {
+			return nil, err
+		}
+		histograms = append(histograms, histogram)
+	}
+	return histograms, rows.Err()
+}
+
+func (s *sqliteStore) QueryValueSizeHistograms(from int64, to int64) ([]*ValueSizeHistogram, error) {
+	rows, err := s.db.Query(`select captures.start, captures.end, agents.hostname, value_size_histograms.opcode,
+		value_size_histograms.wire, value_size_histograms.uncompressed
+		from value_size_histograms join captures on captures.id = value_size_histograms.capture_id
+		join agents on agents.id = value_size_histograms.agent_id
+		where captures.end >= ? and captures.end <= ? order by captures.end;`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var histograms []*ValueSizeHistogram
+	for rows.Next() {
+		histogram := &ValueSizeHistogram{}
+		var wire, uncompressed []byte
+		if err := rows.Scan(&histogram.Start, &histogram.End, &histogram.Agent, &histogram.Opcode, &wire, &uncompressed); err != nil {
+			return nil, err
+		}
+		if histogram.Wire, err = decodeHistogram(wire); err != nil {
+			return nil, err
+		}
+		if histogram.Uncompressed, err = decodeHistogram(uncompressed); err != nil {
+			return nil, err
+		}
+		histograms = append(histograms, histogram)
+	}
+	return histograms, rows.Err()
+}
+
+func (s *sqliteStore) QueryWindows(from int64, to int64) ([]*WindowInfo, error) {
+	rows, err := s.db.Query(`select captures.start, captures.end, captures.downsampled, count(operations.capture_id)
+		from captures left join operations on operations.capture_id = captures.id
+		where captures.end >= ? and captures.end <= ? group by captures.id order by captures.end;`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var windows []*WindowInfo
+	for rows.Next() {
+		window := &WindowInfo{}
+		if err := rows.Scan(&window.Start, &window.End, &window.Downsampled, &window.Operations); err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+	return windows, rows.Err()
+}
+
+func (s *sqliteStore) QuerySlowOps(filter *OperationFilter) ([]*SlowOp, error) {
+	query := `select slow_ops.timestamp, agents.hostname, slow_ops.client, slow_ops.server, slow_ops.opaque, slow_ops.opcode,
+		slow_ops.status, slow_ops.vbucket, slow_ops.bucket, slow_ops.key, slow_ops.value_size, slow_ops.latency,
+		slow_ops.server_duration, slow_ops.pipeline_depth, capture_agents.key_policy
+		from slow_ops join agents on agents.id = slow_ops.agent_id
+		left join capture_agents on capture_agents.capture_id = slow_ops.capture_id and capture_agents.agent_id = slow_ops.agent_id
+		where slow_ops.timestamp >= ? and slow_ops.timestamp <= ?`
+	args := []interface{}{filter.From, filter.To}
+	query, args = filterConditions("slow_ops", filter, query, args)
+	query += " order by slow_ops.timestamp, slow_ops.rowid"
+	query, args = pageClause(filter, query, args)
+
+	rows, err := s.db.Query(query+";", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slowOps []*SlowOp
+	for rows.Next() {
+		op := &SlowOp{}
+		var keyPolicy sql.NullString
+		err := rows.Scan(&op.Timestamp, &op.Agent, &op.Client, &op.Server, &op.Opaque, &op.Opcode, &op.Status,
+			&op.Vbucket, &op.Bucket, &op.Key, &op.ValueSize, &op.Latency, &op.ServerDuration, &op.PipelineDepth, &keyPolicy)
+		if err != nil {
+			return nil, err
+		}
+		op.KeyPolicy = keyPolicy.String
+		slowOps = append(slowOps, op)
+	}
+	return slowOps, rows.Err()
+}
+
+func (s *sqliteStore) QueryHotKeys(filter *OperationFilter) ([]*HotKey, error) {
+	query := `select agents.hostname, hot_keys.bucket, hot_keys.key, hot_keys.count, hot_keys.latency, capture_agents.key_policy
+		from hot_keys join captures on captures.id = hot_keys.capture_id join agents on agents.id = hot_keys.agent_id
+		left join capture_agents on capture_agents.capture_id = hot_keys.capture_id and capture_agents.agent_id = hot_keys.agent_id
+		where captures.end >= ? and captures.end <= ?`
+	args := []interface{}{filter.From, filter.To}
+	query, args = filterConditions("hot_keys", &OperationFilter{
+		Agent:     filter.Agent,
+		Bucket:    filter.Bucket,
+		KeyPrefix: filter.KeyPrefix,
+	}, query, args)
+
+	rows, err := s.db.Query(query+";", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hotKeys []*HotKey
+	for rows.Next() {
+		hotKey := &HotKey{}
+		var bucket, keyPolicy sql.NullString
+		var count, latency int64
+		if err := rows.Scan(&hotKey.Agent, &bucket, &hotKey.Key, &count, &latency, &keyPolicy); err != nil {
+			return nil, err
+		}
+		hotKey.KeyPolicy = keyPolicy.String
+		hotKey.Bucket = bucket.String
+		hotKey.Count = uint64(count)
+		hotKey.Latency = uint64(latency)
+		hotKeys = append(hotKeys, hotKey)
+	}
+	return hotKeys, rows.Err()
+}
+
+func (s *sqliteStore) QuerySubdocPaths(filter *OperationFilter) ([]*SubdocPath, error) {
+	query := `select agents.hostname, subdoc_paths.bucket, subdoc_paths.opcode, subdoc_paths.path, subdoc_paths.status,
+		subdoc_paths.count, subdoc_paths.latency, subdoc_paths.max_latency, capture_agents.key_policy
+		from subdoc_paths join captures on captures.id = subdoc_paths.capture_id join agents on agents.id = subdoc_paths.agent_id
+		left join capture_agents on capture_agents.capture_id = subdoc_paths.capture_id and capture_agents.agent_id = subdoc_paths.agent_id
+		where captures.end >= ? and captures.end <= ?`
+	args := []interface{}{filter.From, filter.To}
+	query, args = filterConditions("subdoc_paths", &OperationFilter{
+		Agent:  filter.Agent,
+		Opcode: filter.Opcode,
+		Status: filter.Status,
+		Bucket: filter.Bucket,
+	}, query, args)
+
+	rows, err := s.db.Query(query+";", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []*SubdocPath
+	for rows.Next() {
+		path := &SubdocPath{}
+		var bucket, spec, pathName, status, keyPolicy sql.NullString
+		var count int64
+		if err := rows.Scan(&path.Agent, &bucket, &spec, &pathName, &status, &count, &path.Latency, &path.MaxLatency,
+			&keyPolicy); err != nil {
+			return nil, err
+		}
+		path.Bucket = bucket.String
+		path.Spec = spec.String
+		path.Path = pathName.String
+		path.Status = status.String
+		path.Count = uint64(count)
+		path.KeyPolicy = keyPolicy.String
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+func (s *sqliteStore) QueryTLSConnections(filter *OperationFilter) ([]*TLSConnection, error) {
+	query := `select captures.end, agents.hostname, tls_connections.client, tls_connections.server,
+		tls_connections.version, tls_connections.cipher_suite, tls_connections.decryption, tls_connections.records,
+		tls_connections.exchanges, tls_connections.latency, tls_connections.max_latency,
+		tls_connections.handshake_duration, tls_connections.resumed, tls_connections.alerts, tls_connections.last_alert
+		from tls_connections join captures on captures.id = tls_connections.capture_id
+		join agents on agents.id = tls_connections.agent_id
+		where captures.end >= ? and captures.end <= ?`
+	args := []interface{}{filter.From, filter.To}
+	query, args = filterConditions("tls_connections", &OperationFilter{Agent: filter.Agent}, query, args)
+	query += " order by captures.end, tls_connections.rowid"
+	query, args = pageClause(filter, query, args)
+
+	rows, err := s.db.Query(query+";", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var connections []*TLSConnection
+	for rows.Next() {
+		connection := &TLSConnection{}
+		var records, exchanges int64
+		// connections stored before handshakes were tracked have no handshake columns
+		var handshakeDuration, resumed, alerts sql.NullInt64
+		var lastAlert sql.NullString
+		if err := rows.Scan(&connection.End, &connection.Agent, &connection.Client, &connection.Server,
+			&connection.Version, &connection.CipherSuite, &connection.Decryption, &records, &exchanges,
+			&connection.Latency, &connection.MaxLatency, &handshakeDuration, &resumed, &alerts, &lastAlert); err != nil {
+			return nil, err
+		}
+		connection.Records = uint64(records)
+		connection.Exchanges = uint64(exchanges)
+		connection.HandshakeDuration = handshakeDuration.Int64
+		connection.Resumed = resumed.Int64 != 0
+		connection.Alerts = uint32(alerts.Int64)
+		connection.LastAlert = lastAlert.String
+		connections = append(connections, connection)
+	}
+	return connections, rows.Err()
+}
+
+func (s *sqliteStore) QueryDCPConnections(filter *OperationFilter) ([]*DCPConnection, error) {
+	query := `select captures.end, agents.hostname, dcp_connections.client, dcp_connections.server,
+		dcp_connections.name, dcp_connections.producer, dcp_connections.buffer_size, dcp_connections.max_unacked,
+		dcp_connections.acked, dcp_connections.stalls, dcp_connections.stall_time, dcp_connections.max_stall,
+		dcp_connections.streams
+		from dcp_connections join captures on captures.id = dcp_connections.capture_id
+		join agents on agents.id = dcp_connections.agent_id
+		where captures.end >= ? and captures.end <= ?`
+	args := []interface{}{filter.From, filter.To}
+	query, args = filterConditions("dcp_connections", &OperationFilter{Agent: filter.Agent}, query, args)
+	query += " order by captures.end, dcp_connections.rowid"
+	query, args = pageClause(filter, query, args)
+
+	rows, err := s.db.Query(query+";", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var connections []*DCPConnection
+	for rows.Next() {
+		connection := &DCPConnection{}
+		var maxUnacked, acked int64
+		var streams string
+		if err := rows.Scan(&connection.End, &connection.Agent, &connection.Client, &connection.Server,
+			&connection.Name, &connection.Producer, &connection.BufferSize, &maxUnacked, &acked, &connection.Stalls,
+			&connection.StallTime, &connection.MaxStall, &streams); err != nil {
+			return nil, err
+		}
+		connection.MaxUnacked = uint64(maxUnacked)
+		connection.Acked = uint64(acked)
+		if err := json.Unmarshal([]byte(streams), &connection.Streams); err != nil {
+			return nil, err
+		}
+		connections = append(connections, connection)
+	}
+	return connections, rows.Err()
+}
+
+func (s *sqliteStore) QueryClustermapNotifications(filter *OperationFilter) ([]*ClustermapNotification, error) {
+	query := `select clustermap_notifications.timestamp, agents.hostname, clustermap_notifications.client,
+		clustermap_notifications.server, clustermap_notifications.bucket, clustermap_notifications.epoch,
+		clustermap_notifications.revision, clustermap_notifications.size
+		from clustermap_notifications join agents on agents.id = clustermap_notifications.agent_id
+		where clustermap_notifications.timestamp >= ? and clustermap_notifications.timestamp <= ?`
+	args := []interface{}{filter.From, filter.To}
+	query, args = filterConditions("clustermap_notifications", &OperationFilter{
+		Agent:  filter.Agent,
+		Bucket: filter.Bucket,
+	}, query, args)
+	query += " order by clustermap_notifications.timestamp, clustermap_notifications.rowid"
+
+	rows, err := s.db.Query(query+";", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []*ClustermapNotification
+	for rows.Next() {
+		notification := &ClustermapNotification{}
+		if err := rows.Scan(&notification.Timestamp, &notification.Agent, &notification.Client, &notification.Server,
+			&notification.Bucket, &notification.Epoch, &notification.Revision, &notification.Size); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
+
+func (s *sqliteStore) QueryNotMyVbuckets(filter *OperationFilter) ([]*NotMyVbucket, error) {
+	query := `select not_my_vbuckets.timestamp, agents.hostname, not_my_vbuckets.client, not_my_vbuckets.server,
+		not_my_vbuckets.bucket, not_my_vbuckets.vbucket, not_my_vbuckets.opcode, not_my_vbuckets.latency,
+		not_my_vbuckets.epoch, not_my_vbuckets.revision, not_my_vbuckets.map_size, not_my_vbuckets.attempt
+		from not_my_vbuckets join agents on agents.id = not_my_vbuckets.agent_id
+		where not_my_vbuckets.timestamp >= ? and not_my_vbuckets.timestamp <= ?`
+	args := []interface{}{filter.From, filter.To}
+	query, args = filterConditions("not_my_vbuckets", &OperationFilter{
+		Agent:  filter.Agent,
+		Opcode: filter.Opcode,
+		Bucket: filter.Bucket,
+	}, query, args)
+	query += " order by not_my_vbuckets.timestamp, not_my_vbuckets.rowid"
+
+	rows, err := s.db.Query(query+";", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ops []*NotMyVbucket
+	for rows.Next() {
+		op := &NotMyVbucket{}
+		if err := rows.Scan(&op.Timestamp, &op.Agent, &op.Client, &op.Server, &op.Bucket, &op.Vbucket, &op.Opcode,
+			&op.Latency, &op.Epoch, &op.Revision, &op.MapSize, &op.Attempt); err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, rows.Err()
+}
+
+func (s *sqliteStore) QueryHTTPRequests(filter *OperationFilter) ([]*HTTPRequest, error) {
+	query := `select http_requests.timestamp, agents.hostname, http_requests.client, http_requests.server,
+		http_requests.service, http_requests.method, http_requests.path, http_requests.status, http_requests.latency,
+		http_requests.first_byte, http_requests.request_size, http_requests.response_size, http_requests.statement,
+		http_requests.client_context_id, http_requests.statement_policy
+		from http_requests join agents on agents.id = http_requests.agent_id
+		where http_requests.timestamp >= ? and http_requests.timestamp <= ?`
+	args := []interface{}{filter.From, filter.To}
+	query, args = filterConditions("http_requests", &OperationFilter{Agent: filter.Agent}, query, args)
+	query += " order by http_requests.timestamp, http_requests.rowid"
+
+	rows, err := s.db.Query(query+";", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []*HTTPRequest
+	for rows.Next() {
+		request := &HTTPRequest{}
+		if err := rows.Scan(&request.Timestamp, &request.Agent, &request.Client, &request.Server, &request.Service,
+			&request.Method, &request.Path, &request.Status, &request.Latency, &request.FirstByte, &request.RequestSize,
+			&request.ResponseSize, &request.Statement, &request.ClientContextId, &request.StatementPolicy); err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	return requests, rows.Err()
+}
+
+func (s *sqliteStore) QueryConfigStreams(filter *OperationFilter) ([]*ConfigStream, error) {
+	query := `select agents.hostname, config_streams.client, config_streams.server, config_streams.path,
+		config_streams.resumed, config_streams.start, config_streams.first_config, config_streams.last_config,
+		config_streams.configs, config_streams.bytes, config_streams.min_interval, config_streams.max_interval
+		from config_streams join agents on agents.id = config_streams.agent_id
+		where config_streams.start <= ? and max(config_streams.start, config_streams.last_config) >= ?`
+	args := []interface{}{filter.To, filter.From}
+	query, args = filterConditions("config_streams", &OperationFilter{Agent: filter.Agent}, query, args)
+	query += " order by config_streams.start, config_streams.rowid"
+
+	rows, err := s.db.Query(query+";", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var configStreams []*ConfigStream
+	for rows.Next() {
+		configStream := &ConfigStream{}
+		if err := rows.Scan(&configStream.Agent, &configStream.Client, &configStream.Server, &configStream.Path,
+			&configStream.Resumed, &configStream.Start, &configStream.FirstConfig, &configStream.LastConfig,
+			&configStream.Configs, &configStream.Bytes, &configStream.MinInterval, &configStream.MaxInterval); err != nil {
+			return nil, err
+		}
+		configStreams = append(configStreams, configStream)
+	}
+	return configStreams, rows.Err()
+}
+
+func (s *sqliteStore) ApplyRetention(expired int64, downsampled int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sqlStmt := `delete from operations where capture_id in (select id from captures where end < ?);
+		delete from histograms where capture_id in (select id from captures where end < ?);
+		delete from slow_ops where capture_id in (select id from captures where end < ?);
+		delete from hot_keys where capture_id in (select id from captures where end < ?);
+		delete from capture_agents where capture_id in (select id from captures where end < ?);
+		delete from tls_connections where capture_id in (select id from captures where end < ?);
+		delete from tls_histograms where capture_id in (select id from captures where end < ?);
+		delete from value_size_histograms where capture_id in (select id from captures where end < ?);
+		delete from subdoc_paths where capture_id in (select id from captures where end < ?);
+		delete from dcp_connections where capture_id in (select id from captures where end < ?);
+		delete from clustermap_notifications where capture_id in (select id from captures where end < ?);
+		delete from not_my_vbuckets where capture_id in (select id from captures where end < ?);
+		delete from http_requests where capture_id in (select id from captures where end < ?);
+		delete from config_streams where capture_id in (select id from captures where end < ?);
+		delete from captures where end < ?;`
+	if _, err := s.db.Exec(sqlStmt, expired, expired, expired, expired, expired, expired, expired, expired, expired,
+		expired, expired, expired, expired, expired, expired); err != nil {
+		return fmt.Errorf("Cannot execute %q: %v", sqlStmt, err)
+	}
+
+	sqlStmt = `delete from operations where capture_id in (select id from captures where end < ? and downsampled = 0);
+		update captures set downsampled = 1 where end < ? and downsampled = 0;`
+	if _, err := s.db.Exec(sqlStmt, downsampled, downsampled); err != nil {
+		return fmt.Errorf("Cannot execute %q: %v", sqlStmt, err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
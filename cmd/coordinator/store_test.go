@@ -0,0 +1,248 @@
+/*
+ * Copyright (c) 2017 Couchbase, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testWindows are three windows of two agents, ending at 1000, 2000 and 3000. Operations carry the
+// key policy of their agent as the coordinator sets it.
+func testWindows() []*Window {
+	var windows []*Window
+	for i, end := range []int64{1000, 2000, 3000} {
+		histogram := newLatencyHistogram()
+		histogram.RecordValue(int64(100 * (i + 1)))
+		windows = append(windows, &Window{
+			Start: end - 1000,
+			End:   end,
+			Operations: []*Operation{
+				{Agent: "a:1", OpaqueStreamId: "1", Opcode: "get", Status: "success", Bucket: "travel", Key: "airline_10", Latency: 100, KeyPolicy: "plain"},
+				{Agent: "a:1", OpaqueStreamId: "2", Opcode: "set", Status: "success", Bucket: "travel", Key: "ключ_1", Latency: 200, KeyPolicy: "plain"},
+				{Agent: "b:1", OpaqueStreamId: "3", Opcode: "get", Status: "key_not_found", Bucket: "beer", Key: "100%_abv", Latency: 300, KeyPolicy: "plain"},
+			},
+			Histograms:  []*AgentHistogram{{Agent: "a:1", Opcode: "get", Histogram: histogram}},
+			KeyPolicies: map[string]string{"a:1": "plain", "b:1": "plain"},
+		})
+	}
+	return windows
+}
+
+// forEachStore runs test against every backend, holding the test windows.
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, backend := range []string{SQLITE_BACKEND, MEMORY_BACKEND, FILE_BACKEND} {
+		t.Run(backend, func(t *testing.T) {
+			store, err := newStore(&ResultsHistory{Backend: backend, FileName: filepath.Join(dir, backend)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			for _, window := range testWindows() {
+				if err := store.WriteWindow(window); err != nil {
+					t.Fatal(err)
+				}
+			}
+			test(t, store)
+		})
+	}
+}
+
+func opaques(operations []*Operation) []string {
+	var opaques []string
+	for _, op := range operations {
+		opaques = append(opaques, op.OpaqueStreamId)
+	}
+	return opaques
+}
+
+func TestStoreQueryRangeFilters(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		for _, test := range []struct {
+			name   string
+			filter OperationFilter
+			count  int
+		}{
+			{"all", OperationFilter{To: 3000}, 9},
+			{"range", OperationFilter{From: 1500, To: 2500}, 3},
+			{"agent", OperationFilter{To: 3000, Agent: "b:1"}, 3},
+			{"opcode", OperationFilter{To: 3000, Opcode: "get"}, 6},
+			{"status", OperationFilter{To: 3000, Status: "key_not_found"}, 3},
+			{"bucket", OperationFilter{To: 3000, Bucket: "travel"}, 6},
+			{"key prefix", OperationFilter{To: 3000, KeyPrefix: "airline_"}, 3},
+			{"non-ascii key prefix", OperationFilter{To: 3000, KeyPrefix: "клю"}, 3},
+			{"literal key prefix", OperationFilter{To: 3000, KeyPrefix: "100%"}, 3},
+			{"wildcard is not a key prefix", OperationFilter{To: 3000, KeyPrefix: "%"}, 0},
+			{"combined", OperationFilter{From: 2000, To: 3000, Agent: "a:1", Opcode: "get"}, 2},
+		} {
+			operations, err := store.QueryRange(&test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(operations) != test.count {
+				t.Errorf("%v: got %v operations, want %v", test.name, len(operations), test.count)
+			}
+		}
+
+		operations, err := store.QueryRange(&OperationFilter{From: 2000, To: 2000, Agent: "b:1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(operations) != 1 || operations[0].Timestamp != 2000 || operations[0].Key != "100%_abv" ||
+			operations[0].Latency != 300 || operations[0].KeyPolicy != "plain" {
+			t.Errorf("unexpected operation %+v", operations[0])
+		}
+	})
+}
+
+func TestStoreQueryRangePagination(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		for _, test := range []struct {
+			filter  OperationFilter
+			opaques []string
+		}{
+			{OperationFilter{To: 2000, Limit: 2}, []string{"1", "2"}},
+			{OperationFilter{To: 2000, Limit: 2, Offset: 2}, []string{"3", "1"}},
+			{OperationFilter{To: 2000, Offset: 5}, []string{"3"}},
+			{OperationFilter{To: 2000, Limit: 2, Offset: 6}, nil},
+			{OperationFilter{To: 3000, Opcode: "get", Limit: 3, Offset: 1}, []string{"3", "1", "3"}},
+		} {
+			operations, err := store.QueryRange(&test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := opaques(operations)
+			if len(got) != len(test.opaques) {
+				t.Errorf("%+v: got %v, want %v", test.filter, got, test.opaques)
+				continue
+			}
+			for i := range got {
+				if got[i] != test.opaques[i] {
+					t.Errorf("%+v: got %v, want %v", test.filter, got, test.opaques)
+					break
+				}
+			}
+		}
+	})
+}
+
+func TestStoreRetention(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		// the first window expires, the second is downsampled and the third is kept whole
+		if err := store.ApplyRetention(1500, 2500); err != nil {
+			t.Fatal(err)
+		}
+		windows, err := store.QueryWindows(0, 3000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(windows) != 2 || windows[0].End != 2000 || !windows[0].Downsampled ||
+			windows[1].End != 3000 || windows[1].Downsampled || windows[1].Operations != 3 {
+			t.Fatalf("unexpected windows %+v", windows)
+		}
+
+		operations, err := store.QueryRange(&OperationFilter{To: 3000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(operations) != 3 {
+			t.Errorf("got %v operations, want those of the last window", len(operations))
+		}
+
+		histograms, err := store.QueryHistograms(0, 3000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(histograms) != 2 {
+			t.Fatalf("got %v histograms, want those of the downsampled and the last window", len(histograms))
+		}
+		for _, histogram := range histograms {
+			if histogram.Agent != "a:1" || histogram.Opcode != "get" || histogram.Histogram.TotalCount() != 1 {
+				t.Errorf("unexpected histogram %+v", histogram)
+			}
+		}
+		if histograms[0].End != 2000 || histograms[0].Histogram.Max() != 200 {
+			t.Errorf("the downsampled window lost its histogram, got %v ending at %v",
+				histograms[0].Histogram.Max(), histograms[0].End)
+		}
+
+		// applying the same retention again changes nothing
+		if err := store.ApplyRetention(1500, 2500); err != nil {
+			t.Fatal(err)
+		}
+		windows, err = store.QueryWindows(0, 3000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(windows) != 2 {
+			t.Errorf("got %v windows after retaining again", len(windows))
+		}
+	})
+}
+
+func TestStoreReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, backend := range []string{SQLITE_BACKEND, FILE_BACKEND} {
+		config := &ResultsHistory{Backend: backend, FileName: filepath.Join(dir, backend)}
+		store, err := newStore(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, window := range testWindows() {
+			if err := store.WriteWindow(window); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if store, err = newStore(config); err != nil {
+			t.Fatal(err)
+		}
+		operations, err := store.QueryRange(&OperationFilter{To: 3000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(operations) != 9 {
+			t.Errorf("%v: got %v operations after reopening", backend, len(operations))
+		}
+		store.Close()
+	}
+}
+
+func TestRecordLatencyClamps(t *testing.T) {
+	histogram := newLatencyHistogram()
+	recordLatency(histogram, 100)
+	recordLatency(histogram, 2*MAX_LATENCY)
+	if histogram.TotalCount() != 2 {
+		t.Fatalf("got %v latencies, the one above MAX_LATENCY was dropped", histogram.TotalCount())
+	}
+	if histogram.Max() < MAX_LATENCY*999/1000 {
+		t.Errorf("got a max of %v, want about %v", histogram.Max(), MAX_LATENCY)
+	}
+}
@@ -14,6 +14,10 @@ It has these top-level messages:
 	AgentGoodByeResponse
 	CoordinatorResultsRequest
 	AgentResultsResponse
+	AgentRegisterRequest
+	CoordinatorRegisterResponse
+	AgentDeregisterRequest
+	CoordinatorDeregisterResponse
 */
 package rpc
 
@@ -94,8 +98,20 @@ func (*CoordinatorResultsRequest) ProtoMessage()               {}
 func (*CoordinatorResultsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
 
 type AgentResultsResponse struct {
-	Status     string                                       `protobuf:"bytes,1,opt,name=status" json:"status,omitempty"`
-	CaptureMap map[string]*AgentResultsResponse_CaptureInfo `protobuf:"bytes,2,rep,name=captureMap" json:"captureMap,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Status          string                                       `protobuf:"bytes,1,opt,name=status" json:"status,omitempty"`
+	CaptureMap      map[string]*AgentResultsResponse_CaptureInfo `protobuf:"bytes,2,rep,name=captureMap" json:"captureMap,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	PacketsReceived uint64                                       `protobuf:"varint,3,opt,name=packets_received,json=packetsReceived" json:"packets_received,omitempty"`
+	PacketsDropped  uint64                                       `protobuf:"varint,4,opt,name=packets_dropped,json=packetsDropped" json:"packets_dropped,omitempty"`
+	SlowOps         []*AgentResultsResponse_SlowOp               `protobuf:"bytes,5,rep,name=slow_ops,json=slowOps" json:"slow_ops,omitempty"`
+	HotKeys         []*AgentResultsResponse_HotKey               `protobuf:"bytes,6,rep,name=hot_keys,json=hotKeys" json:"hot_keys,omitempty"`
+	KeyPolicy       string                                       `protobuf:"bytes,7,opt,name=key_policy,json=keyPolicy" json:"key_policy,omitempty"`
+	TlsConnections  []*AgentResultsResponse_TlsConnection        `protobuf:"bytes,8,rep,name=tls_connections,json=tlsConnections" json:"tls_connections,omitempty"`
+	DcpConnections  []*AgentResultsResponse_DcpConnection        `protobuf:"bytes,9,rep,name=dcp_connections,json=dcpConnections" json:"dcp_connections,omitempty"`
+	ServerPush      []*AgentResultsResponse_ServerPushConnection `protobuf:"bytes,10,rep,name=server_push,json=serverPush" json:"server_push,omitempty"`
+	NotMyVbuckets   []*AgentResultsResponse_NotMyVbucket         `protobuf:"bytes,11,rep,name=not_my_vbuckets,json=notMyVbuckets" json:"not_my_vbuckets,omitempty"`
+	HttpRequests    []*AgentResultsResponse_HttpRequest          `protobuf:"bytes,12,rep,name=http_requests,json=httpRequests" json:"http_requests,omitempty"`
+	StatementPolicy string                                       `protobuf:"bytes,13,opt,name=statement_policy,json=statementPolicy" json:"statement_policy,omitempty"`
+	ConfigStreams   []*AgentResultsResponse_ConfigStream         `protobuf:"bytes,14,rep,name=config_streams,json=configStreams" json:"config_streams,omitempty"`
 }
 
 func (m *AgentResultsResponse) Reset()                    { *m = AgentResultsResponse{} }
@@ -117,36 +133,1218 @@ func (m *AgentResultsResponse) GetCaptureMap() map[string]*AgentResultsResponse_
 	return nil
 }
 
+func (m *AgentResultsResponse) GetPacketsReceived() uint64 {
+	if m != nil {
+		return m.PacketsReceived
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse) GetPacketsDropped() uint64 {
+	if m != nil {
+		return m.PacketsDropped
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse) GetSlowOps() []*AgentResultsResponse_SlowOp {
+	if m != nil {
+		return m.SlowOps
+	}
+	return nil
+}
+
+func (m *AgentResultsResponse) GetHotKeys() []*AgentResultsResponse_HotKey {
+	if m != nil {
+		return m.HotKeys
+	}
+	return nil
+}
+
+func (m *AgentResultsResponse) GetKeyPolicy() string {
+	if m != nil {
+		return m.KeyPolicy
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse) GetTlsConnections() []*AgentResultsResponse_TlsConnection {
+	if m != nil {
+		return m.TlsConnections
+	}
+	return nil
+}
+
+func (m *AgentResultsResponse) GetDcpConnections() []*AgentResultsResponse_DcpConnection {
+	if m != nil {
+		return m.DcpConnections
+	}
+	return nil
+}
+
+func (m *AgentResultsResponse) GetServerPush() []*AgentResultsResponse_ServerPushConnection {
+	if m != nil {
+		return m.ServerPush
+	}
+	return nil
+}
+
+func (m *AgentResultsResponse) GetNotMyVbuckets() []*AgentResultsResponse_NotMyVbucket {
+	if m != nil {
+		return m.NotMyVbuckets
+	}
+	return nil
+}
+
+func (m *AgentResultsResponse) GetHttpRequests() []*AgentResultsResponse_HttpRequest {
+	if m != nil {
+		return m.HttpRequests
+	}
+	return nil
+}
+
+func (m *AgentResultsResponse) GetStatementPolicy() string {
+	if m != nil {
+		return m.StatementPolicy
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse) GetConfigStreams() []*AgentResultsResponse_ConfigStream {
+	if m != nil {
+		return m.ConfigStreams
+	}
+	return nil
+}
+
 type AgentResultsResponse_CaptureInfo struct {
-	Oplatency string `protobuf:"bytes,1,opt,name=oplatency" json:"oplatency,omitempty"`
-	Key       string `protobuf:"bytes,2,opt,name=key" json:"key,omitempty"`
-	Opaque    string `protobuf:"bytes,3,opt,name=opaque" json:"opaque,omitempty"`
+	Oplatency        string                             `protobuf:"bytes,1,opt,name=oplatency" json:"oplatency,omitempty"`
+	Key              string                             `protobuf:"bytes,2,opt,name=key" json:"key,omitempty"`
+	Opaque           string                             `protobuf:"bytes,3,opt,name=opaque" json:"opaque,omitempty"`
+	Opcode           string                             `protobuf:"bytes,4,opt,name=opcode" json:"opcode,omitempty"`
+	Status           string                             `protobuf:"bytes,5,opt,name=status" json:"status,omitempty"`
+	Bucket           string                             `protobuf:"bytes,6,opt,name=bucket" json:"bucket,omitempty"`
+	Datatype         string                             `protobuf:"bytes,7,opt,name=datatype" json:"datatype,omitempty"`
+	ValueSize        uint32                             `protobuf:"varint,8,opt,name=value_size,json=valueSize" json:"value_size,omitempty"`
+	UncompressedSize uint32                             `protobuf:"varint,9,opt,name=uncompressed_size,json=uncompressedSize" json:"uncompressed_size,omitempty"`
+	PathCount        uint32                             `protobuf:"varint,10,opt,name=path_count,json=pathCount" json:"path_count,omitempty"`
+	Specs            []*AgentResultsResponse_SubdocSpec `protobuf:"bytes,11,rep,name=specs" json:"specs,omitempty"`
+}
+
+func (m *AgentResultsResponse_CaptureInfo) Reset()         { *m = AgentResultsResponse_CaptureInfo{} }
+func (m *AgentResultsResponse_CaptureInfo) String() string { return proto.CompactTextString(m) }
+func (*AgentResultsResponse_CaptureInfo) ProtoMessage()    {}
+func (*AgentResultsResponse_CaptureInfo) Descriptor() ([]byte, []int) {
+	return fileDescriptor0, []int{5, 0}
+}
+
+func (m *AgentResultsResponse_CaptureInfo) GetOplatency() string {
+	if m != nil {
+		return m.Oplatency
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_CaptureInfo) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_CaptureInfo) GetOpaque() string {
+	if m != nil {
+		return m.Opaque
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_CaptureInfo) GetOpcode() string {
+	if m != nil {
+		return m.Opcode
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_CaptureInfo) GetStatus() string {
+	if m != nil {
+		return m.Status
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_CaptureInfo) GetBucket() string {
+	if m != nil {
+		return m.Bucket
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_CaptureInfo) GetDatatype() string {
+	if m != nil {
+		return m.Datatype
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_CaptureInfo) GetValueSize() uint32 {
+	if m != nil {
+		return m.ValueSize
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_CaptureInfo) GetUncompressedSize() uint32 {
+	if m != nil {
+		return m.UncompressedSize
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_CaptureInfo) GetPathCount() uint32 {
+	if m != nil {
+		return m.PathCount
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_CaptureInfo) GetSpecs() []*AgentResultsResponse_SubdocSpec {
+	if m != nil {
+		return m.Specs
+	}
+	return nil
+}
+
+type AgentResultsResponse_SubdocSpec struct {
+	Opcode string `protobuf:"bytes,1,opt,name=opcode" json:"opcode,omitempty"`
+	Path   string `protobuf:"bytes,2,opt,name=path" json:"path,omitempty"`
+	Status string `protobuf:"bytes,3,opt,name=status" json:"status,omitempty"`
+}
+
+func (m *AgentResultsResponse_SubdocSpec) Reset()         { *m = AgentResultsResponse_SubdocSpec{} }
+func (m *AgentResultsResponse_SubdocSpec) String() string { return proto.CompactTextString(m) }
+func (*AgentResultsResponse_SubdocSpec) ProtoMessage()    {}
+func (*AgentResultsResponse_SubdocSpec) Descriptor() ([]byte, []int) {
+	return fileDescriptor0, []int{5, 1}
+}
+
+func (m *AgentResultsResponse_SubdocSpec) GetOpcode() string {
+	if m != nil {
+		return m.Opcode
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_SubdocSpec) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_SubdocSpec) GetStatus() string {
+	if m != nil {
+		return m.Status
+	}
+	return ""
+}
+
+type AgentResultsResponse_SlowOp struct {
+	Timestamp      int64  `protobuf:"varint,1,opt,name=timestamp" json:"timestamp,omitempty"`
+	Client         string `protobuf:"bytes,2,opt,name=client" json:"client,omitempty"`
+	Server         string `protobuf:"bytes,3,opt,name=server" json:"server,omitempty"`
+	Opaque         uint32 `protobuf:"varint,4,opt,name=opaque" json:"opaque,omitempty"`
+	Opcode         string `protobuf:"bytes,5,opt,name=opcode" json:"opcode,omitempty"`
+	Status         string `protobuf:"bytes,6,opt,name=status" json:"status,omitempty"`
+	Vbucket        uint32 `protobuf:"varint,7,opt,name=vbucket" json:"vbucket,omitempty"`
+	Bucket         string `protobuf:"bytes,8,opt,name=bucket" json:"bucket,omitempty"`
+	Key            string `protobuf:"bytes,9,opt,name=key" json:"key,omitempty"`
+	ValueSize      uint32 `protobuf:"varint,10,opt,name=value_size,json=valueSize" json:"value_size,omitempty"`
+	Latency        int64  `protobuf:"varint,11,opt,name=latency" json:"latency,omitempty"`
+	ServerDuration int64  `protobuf:"varint,12,opt,name=server_duration,json=serverDuration" json:"server_duration,omitempty"`
+	PipelineDepth  uint32 `protobuf:"varint,13,opt,name=pipeline_depth,json=pipelineDepth" json:"pipeline_depth,omitempty"`
+}
+
+func (m *AgentResultsResponse_SlowOp) Reset()                    { *m = AgentResultsResponse_SlowOp{} }
+func (m *AgentResultsResponse_SlowOp) String() string            { return proto.CompactTextString(m) }
+func (*AgentResultsResponse_SlowOp) ProtoMessage()               {}
+func (*AgentResultsResponse_SlowOp) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5, 2} }
+
+func (m *AgentResultsResponse_SlowOp) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_SlowOp) GetClient() string {
+	if m != nil {
+		return m.Client
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_SlowOp) GetServer() string {
+	if m != nil {
+		return m.Server
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_SlowOp) GetOpaque() uint32 {
+	if m != nil {
+		return m.Opaque
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_SlowOp) GetOpcode() string {
+	if m != nil {
+		return m.Opcode
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_SlowOp) GetStatus() string {
+	if m != nil {
+		return m.Status
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_SlowOp) GetVbucket() uint32 {
+	if m != nil {
+		return m.Vbucket
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_SlowOp) GetBucket() string {
+	if m != nil {
+		return m.Bucket
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_SlowOp) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_SlowOp) GetValueSize() uint32 {
+	if m != nil {
+		return m.ValueSize
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_SlowOp) GetLatency() int64 {
+	if m != nil {
+		return m.Latency
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_SlowOp) GetServerDuration() int64 {
+	if m != nil {
+		return m.ServerDuration
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_SlowOp) GetPipelineDepth() uint32 {
+	if m != nil {
+		return m.PipelineDepth
+	}
+	return 0
+}
+
+type AgentResultsResponse_HotKey struct {
+	Bucket  string `protobuf:"bytes,1,opt,name=bucket" json:"bucket,omitempty"`
+	Key     string `protobuf:"bytes,2,opt,name=key" json:"key,omitempty"`
+	Count   uint64 `protobuf:"varint,3,opt,name=count" json:"count,omitempty"`
+	Latency uint64 `protobuf:"varint,4,opt,name=latency" json:"latency,omitempty"`
+}
+
+func (m *AgentResultsResponse_HotKey) Reset()                    { *m = AgentResultsResponse_HotKey{} }
+func (m *AgentResultsResponse_HotKey) String() string            { return proto.CompactTextString(m) }
+func (*AgentResultsResponse_HotKey) ProtoMessage()               {}
+func (*AgentResultsResponse_HotKey) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5, 3} }
+
+func (m *AgentResultsResponse_HotKey) GetBucket() string {
+	if m != nil {
+		return m.Bucket
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_HotKey) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_HotKey) GetCount() uint64 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_HotKey) GetLatency() uint64 {
+	if m != nil {
+		return m.Latency
+	}
+	return 0
+}
+
+type AgentResultsResponse_TlsConnection struct {
+	Client            string  `protobuf:"bytes,1,opt,name=client" json:"client,omitempty"`
+	Server            string  `protobuf:"bytes,2,opt,name=server" json:"server,omitempty"`
+	Version           string  `protobuf:"bytes,3,opt,name=version" json:"version,omitempty"`
+	CipherSuite       string  `protobuf:"bytes,4,opt,name=cipher_suite,json=cipherSuite" json:"cipher_suite,omitempty"`
+	Decryption        string  `protobuf:"bytes,5,opt,name=decryption" json:"decryption,omitempty"`
+	Records           uint64  `protobuf:"varint,6,opt,name=records" json:"records,omitempty"`
+	Exchanges         uint64  `protobuf:"varint,7,opt,name=exchanges" json:"exchanges,omitempty"`
+	Latency           int64   `protobuf:"varint,8,opt,name=latency" json:"latency,omitempty"`
+	MaxLatency        int64   `protobuf:"varint,9,opt,name=max_latency,json=maxLatency" json:"max_latency,omitempty"`
+	HandshakeDuration int64   `protobuf:"varint,10,opt,name=handshake_duration,json=handshakeDuration" json:"handshake_duration,omitempty"`
+	Resumed           bool    `protobuf:"varint,11,opt,name=resumed" json:"resumed,omitempty"`
+	Alerts            uint32  `protobuf:"varint,12,opt,name=alerts" json:"alerts,omitempty"`
+	LastAlert         string  `protobuf:"bytes,13,opt,name=last_alert,json=lastAlert" json:"last_alert,omitempty"`
+	ExchangeLatencies []int64 `protobuf:"varint,14,rep,name=exchange_latencies,json=exchangeLatencies,packed" json:"exchange_latencies,omitempty"`
+}
+
+func (m *AgentResultsResponse_TlsConnection) Reset()         { *m = AgentResultsResponse_TlsConnection{} }
+func (m *AgentResultsResponse_TlsConnection) String() string { return proto.CompactTextString(m) }
+func (*AgentResultsResponse_TlsConnection) ProtoMessage()    {}
+func (*AgentResultsResponse_TlsConnection) Descriptor() ([]byte, []int) {
+	return fileDescriptor0, []int{5, 4}
+}
+
+func (m *AgentResultsResponse_TlsConnection) GetClient() string {
+	if m != nil {
+		return m.Client
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_TlsConnection) GetServer() string {
+	if m != nil {
+		return m.Server
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_TlsConnection) GetVersion() string {
+	if m != nil {
+		return m.Version
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_TlsConnection) GetCipherSuite() string {
+	if m != nil {
+		return m.CipherSuite
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_TlsConnection) GetDecryption() string {
+	if m != nil {
+		return m.Decryption
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_TlsConnection) GetRecords() uint64 {
+	if m != nil {
+		return m.Records
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_TlsConnection) GetExchanges() uint64 {
+	if m != nil {
+		return m.Exchanges
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_TlsConnection) GetLatency() int64 {
+	if m != nil {
+		return m.Latency
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_TlsConnection) GetMaxLatency() int64 {
+	if m != nil {
+		return m.MaxLatency
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_TlsConnection) GetHandshakeDuration() int64 {
+	if m != nil {
+		return m.HandshakeDuration
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_TlsConnection) GetResumed() bool {
+	if m != nil {
+		return m.Resumed
+	}
+	return false
+}
+
+func (m *AgentResultsResponse_TlsConnection) GetAlerts() uint32 {
+	if m != nil {
+		return m.Alerts
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_TlsConnection) GetLastAlert() string {
+	if m != nil {
+		return m.LastAlert
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_TlsConnection) GetExchangeLatencies() []int64 {
+	if m != nil {
+		return m.ExchangeLatencies
+	}
+	return nil
+}
+
+type AgentResultsResponse_DcpStream struct {
+	Opaque           uint32 `protobuf:"varint,1,opt,name=opaque" json:"opaque,omitempty"`
+	Vbucket          uint32 `protobuf:"varint,2,opt,name=vbucket" json:"vbucket,omitempty"`
+	Status           string `protobuf:"bytes,3,opt,name=status" json:"status,omitempty"`
+	StartSeqno       uint64 `protobuf:"varint,4,opt,name=start_seqno,json=startSeqno" json:"start_seqno,omitempty"`
+	EndSeqno         uint64 `protobuf:"varint,5,opt,name=end_seqno,json=endSeqno" json:"end_seqno,omitempty"`
+	LastSeqno        uint64 `protobuf:"varint,6,opt,name=last_seqno,json=lastSeqno" json:"last_seqno,omitempty"`
+	Mutations        uint64 `protobuf:"varint,7,opt,name=mutations" json:"mutations,omitempty"`
+	Deletions        uint64 `protobuf:"varint,8,opt,name=deletions" json:"deletions,omitempty"`
+	Expirations      uint64 `protobuf:"varint,9,opt,name=expirations" json:"expirations,omitempty"`
+	Bytes            uint64 `protobuf:"varint,10,opt,name=bytes" json:"bytes,omitempty"`
+	Duration         int64  `protobuf:"varint,11,opt,name=duration" json:"duration,omitempty"`
+	Snapshots        uint64 `protobuf:"varint,12,opt,name=snapshots" json:"snapshots,omitempty"`
+	MaxSnapshotItems uint64 `protobuf:"varint,13,opt,name=max_snapshot_items,json=maxSnapshotItems" json:"max_snapshot_items,omitempty"`
+	MaxSnapshotBytes uint64 `protobuf:"varint,14,opt,name=max_snapshot_bytes,json=maxSnapshotBytes" json:"max_snapshot_bytes,omitempty"`
+	End              string `protobuf:"bytes,15,opt,name=end" json:"end,omitempty"`
+}
+
+func (m *AgentResultsResponse_DcpStream) Reset()         { *m = AgentResultsResponse_DcpStream{} }
+func (m *AgentResultsResponse_DcpStream) String() string { return proto.CompactTextString(m) }
+func (*AgentResultsResponse_DcpStream) ProtoMessage()    {}
+func (*AgentResultsResponse_DcpStream) Descriptor() ([]byte, []int) {
+	return fileDescriptor0, []int{5, 5}
+}
+
+func (m *AgentResultsResponse_DcpStream) GetOpaque() uint32 {
+	if m != nil {
+		return m.Opaque
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpStream) GetVbucket() uint32 {
+	if m != nil {
+		return m.Vbucket
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpStream) GetStatus() string {
+	if m != nil {
+		return m.Status
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_DcpStream) GetStartSeqno() uint64 {
+	if m != nil {
+		return m.StartSeqno
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpStream) GetEndSeqno() uint64 {
+	if m != nil {
+		return m.EndSeqno
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpStream) GetLastSeqno() uint64 {
+	if m != nil {
+		return m.LastSeqno
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpStream) GetMutations() uint64 {
+	if m != nil {
+		return m.Mutations
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpStream) GetDeletions() uint64 {
+	if m != nil {
+		return m.Deletions
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpStream) GetExpirations() uint64 {
+	if m != nil {
+		return m.Expirations
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpStream) GetBytes() uint64 {
+	if m != nil {
+		return m.Bytes
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpStream) GetDuration() int64 {
+	if m != nil {
+		return m.Duration
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpStream) GetSnapshots() uint64 {
+	if m != nil {
+		return m.Snapshots
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpStream) GetMaxSnapshotItems() uint64 {
+	if m != nil {
+		return m.MaxSnapshotItems
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpStream) GetMaxSnapshotBytes() uint64 {
+	if m != nil {
+		return m.MaxSnapshotBytes
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpStream) GetEnd() string {
+	if m != nil {
+		return m.End
+	}
+	return ""
+}
+
+type AgentResultsResponse_DcpConnection struct {
+	Client     string                            `protobuf:"bytes,1,opt,name=client" json:"client,omitempty"`
+	Server     string                            `protobuf:"bytes,2,opt,name=server" json:"server,omitempty"`
+	Name       string                            `protobuf:"bytes,3,opt,name=name" json:"name,omitempty"`
+	Producer   bool                              `protobuf:"varint,4,opt,name=producer" json:"producer,omitempty"`
+	BufferSize uint32                            `protobuf:"varint,5,opt,name=buffer_size,json=bufferSize" json:"buffer_size,omitempty"`
+	MaxUnacked uint64                            `protobuf:"varint,6,opt,name=max_unacked,json=maxUnacked" json:"max_unacked,omitempty"`
+	Acked      uint64                            `protobuf:"varint,7,opt,name=acked" json:"acked,omitempty"`
+	Stalls     uint32                            `protobuf:"varint,8,opt,name=stalls" json:"stalls,omitempty"`
+	StallTime  int64                             `protobuf:"varint,9,opt,name=stall_time,json=stallTime" json:"stall_time,omitempty"`
+	MaxStall   int64                             `protobuf:"varint,10,opt,name=max_stall,json=maxStall" json:"max_stall,omitempty"`
+	Streams    []*AgentResultsResponse_DcpStream `protobuf:"bytes,11,rep,name=streams" json:"streams,omitempty"`
+}
+
+func (m *AgentResultsResponse_DcpConnection) Reset()         { *m = AgentResultsResponse_DcpConnection{} }
+func (m *AgentResultsResponse_DcpConnection) String() string { return proto.CompactTextString(m) }
+func (*AgentResultsResponse_DcpConnection) ProtoMessage()    {}
+func (*AgentResultsResponse_DcpConnection) Descriptor() ([]byte, []int) {
+	return fileDescriptor0, []int{5, 6}
+}
+
+func (m *AgentResultsResponse_DcpConnection) GetClient() string {
+	if m != nil {
+		return m.Client
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_DcpConnection) GetServer() string {
+	if m != nil {
+		return m.Server
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_DcpConnection) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_DcpConnection) GetProducer() bool {
+	if m != nil {
+		return m.Producer
+	}
+	return false
+}
+
+func (m *AgentResultsResponse_DcpConnection) GetBufferSize() uint32 {
+	if m != nil {
+		return m.BufferSize
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpConnection) GetMaxUnacked() uint64 {
+	if m != nil {
+		return m.MaxUnacked
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpConnection) GetAcked() uint64 {
+	if m != nil {
+		return m.Acked
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpConnection) GetStalls() uint32 {
+	if m != nil {
+		return m.Stalls
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpConnection) GetStallTime() int64 {
+	if m != nil {
+		return m.StallTime
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpConnection) GetMaxStall() int64 {
+	if m != nil {
+		return m.MaxStall
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_DcpConnection) GetStreams() []*AgentResultsResponse_DcpStream {
+	if m != nil {
+		return m.Streams
+	}
+	return nil
+}
+
+type AgentResultsResponse_ClustermapNotification struct {
+	Timestamp int64  `protobuf:"varint,1,opt,name=timestamp" json:"timestamp,omitempty"`
+	Bucket    string `protobuf:"bytes,2,opt,name=bucket" json:"bucket,omitempty"`
+	Epoch     int64  `protobuf:"varint,3,opt,name=epoch" json:"epoch,omitempty"`
+	Revision  int64  `protobuf:"varint,4,opt,name=revision" json:"revision,omitempty"`
+	Size      uint32 `protobuf:"varint,5,opt,name=size" json:"size,omitempty"`
+}
+
+func (m *AgentResultsResponse_ClustermapNotification) Reset() {
+	*m = AgentResultsResponse_ClustermapNotification{}
+}
+func (m *AgentResultsResponse_ClustermapNotification) String() string {
+	return proto.CompactTextString(m)
+}
+func (*AgentResultsResponse_ClustermapNotification) ProtoMessage() {}
+func (*AgentResultsResponse_ClustermapNotification) Descriptor() ([]byte, []int) {
+	return fileDescriptor0, []int{5, 7}
+}
+
+func (m *AgentResultsResponse_ClustermapNotification) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_ClustermapNotification) GetBucket() string {
+	if m != nil {
+		return m.Bucket
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_ClustermapNotification) GetEpoch() int64 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_ClustermapNotification) GetRevision() int64 {
+	if m != nil {
+		return m.Revision
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_ClustermapNotification) GetSize() uint32 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+type AgentResultsResponse_ServerPushConnection struct {
+	Client               string                                         `protobuf:"bytes,1,opt,name=client" json:"client,omitempty"`
+	Server               string                                         `protobuf:"bytes,2,opt,name=server" json:"server,omitempty"`
+	Requests             uint64                                         `protobuf:"varint,3,opt,name=requests" json:"requests,omitempty"`
+	Responses            uint64                                         `protobuf:"varint,4,opt,name=responses" json:"responses,omitempty"`
+	ResponseLatency      int64                                          `protobuf:"varint,5,opt,name=response_latency,json=responseLatency" json:"response_latency,omitempty"`
+	Notifications        []*AgentResultsResponse_ClustermapNotification `protobuf:"bytes,6,rep,name=notifications" json:"notifications,omitempty"`
+	DroppedNotifications uint32                                         `protobuf:"varint,7,opt,name=dropped_notifications,json=droppedNotifications" json:"dropped_notifications,omitempty"`
+}
+
+func (m *AgentResultsResponse_ServerPushConnection) Reset() {
+	*m = AgentResultsResponse_ServerPushConnection{}
+}
+func (m *AgentResultsResponse_ServerPushConnection) String() string {
+	return proto.CompactTextString(m)
+}
+func (*AgentResultsResponse_ServerPushConnection) ProtoMessage() {}
+func (*AgentResultsResponse_ServerPushConnection) Descriptor() ([]byte, []int) {
+	return fileDescriptor0, []int{5, 8}
+}
+
+func (m *AgentResultsResponse_ServerPushConnection) GetClient() string {
+	if m != nil {
+		return m.Client
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_ServerPushConnection) GetServer() string {
+	if m != nil {
+		return m.Server
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_ServerPushConnection) GetRequests() uint64 {
+	if m != nil {
+		return m.Requests
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_ServerPushConnection) GetResponses() uint64 {
+	if m != nil {
+		return m.Responses
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_ServerPushConnection) GetResponseLatency() int64 {
+	if m != nil {
+		return m.ResponseLatency
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_ServerPushConnection) GetNotifications() []*AgentResultsResponse_ClustermapNotification {
+	if m != nil {
+		return m.Notifications
+	}
+	return nil
+}
+
+func (m *AgentResultsResponse_ServerPushConnection) GetDroppedNotifications() uint32 {
+	if m != nil {
+		return m.DroppedNotifications
+	}
+	return 0
+}
+
+type AgentResultsResponse_NotMyVbucket struct {
+	Timestamp int64  `protobuf:"varint,1,opt,name=timestamp" json:"timestamp,omitempty"`
+	Client    string `protobuf:"bytes,2,opt,name=client" json:"client,omitempty"`
+	Server    string `protobuf:"bytes,3,opt,name=server" json:"server,omitempty"`
+	Bucket    string `protobuf:"bytes,4,opt,name=bucket" json:"bucket,omitempty"`
+	Vbucket   uint32 `protobuf:"varint,5,opt,name=vbucket" json:"vbucket,omitempty"`
+	Opcode    string `protobuf:"bytes,6,opt,name=opcode" json:"opcode,omitempty"`
+	Latency   int64  `protobuf:"varint,7,opt,name=latency" json:"latency,omitempty"`
+	Epoch     int64  `protobuf:"varint,8,opt,name=epoch" json:"epoch,omitempty"`
+	Revision  int64  `protobuf:"varint,9,opt,name=revision" json:"revision,omitempty"`
+	MapSize   uint32 `protobuf:"varint,10,opt,name=map_size,json=mapSize" json:"map_size,omitempty"`
+	Attempt   uint32 `protobuf:"varint,11,opt,name=attempt" json:"attempt,omitempty"`
 }
 
-func (m *AgentResultsResponse_CaptureInfo) Reset()         { *m = AgentResultsResponse_CaptureInfo{} }
-func (m *AgentResultsResponse_CaptureInfo) String() string { return proto.CompactTextString(m) }
-func (*AgentResultsResponse_CaptureInfo) ProtoMessage()    {}
-func (*AgentResultsResponse_CaptureInfo) Descriptor() ([]byte, []int) {
-	return fileDescriptor0, []int{5, 0}
+func (m *AgentResultsResponse_NotMyVbucket) Reset()         { *m = AgentResultsResponse_NotMyVbucket{} }
+func (m *AgentResultsResponse_NotMyVbucket) String() string { return proto.CompactTextString(m) }
+func (*AgentResultsResponse_NotMyVbucket) ProtoMessage()    {}
+func (*AgentResultsResponse_NotMyVbucket) Descriptor() ([]byte, []int) {
+	return fileDescriptor0, []int{5, 9}
 }
 
-func (m *AgentResultsResponse_CaptureInfo) GetOplatency() string {
+func (m *AgentResultsResponse_NotMyVbucket) GetTimestamp() int64 {
 	if m != nil {
-		return m.Oplatency
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_NotMyVbucket) GetClient() string {
+	if m != nil {
+		return m.Client
 	}
 	return ""
 }
 
-func (m *AgentResultsResponse_CaptureInfo) GetKey() string {
+func (m *AgentResultsResponse_NotMyVbucket) GetServer() string {
 	if m != nil {
-		return m.Key
+		return m.Server
 	}
 	return ""
 }
 
-func (m *AgentResultsResponse_CaptureInfo) GetOpaque() string {
+func (m *AgentResultsResponse_NotMyVbucket) GetBucket() string {
 	if m != nil {
-		return m.Opaque
+		return m.Bucket
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_NotMyVbucket) GetVbucket() uint32 {
+	if m != nil {
+		return m.Vbucket
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_NotMyVbucket) GetOpcode() string {
+	if m != nil {
+		return m.Opcode
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_NotMyVbucket) GetLatency() int64 {
+	if m != nil {
+		return m.Latency
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_NotMyVbucket) GetEpoch() int64 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_NotMyVbucket) GetRevision() int64 {
+	if m != nil {
+		return m.Revision
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_NotMyVbucket) GetMapSize() uint32 {
+	if m != nil {
+		return m.MapSize
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_NotMyVbucket) GetAttempt() uint32 {
+	if m != nil {
+		return m.Attempt
+	}
+	return 0
+}
+
+type AgentResultsResponse_HttpRequest struct {
+	Timestamp       int64  `protobuf:"varint,1,opt,name=timestamp" json:"timestamp,omitempty"`
+	Client          string `protobuf:"bytes,2,opt,name=client" json:"client,omitempty"`
+	Server          string `protobuf:"bytes,3,opt,name=server" json:"server,omitempty"`
+	Service         string `protobuf:"bytes,4,opt,name=service" json:"service,omitempty"`
+	Method          string `protobuf:"bytes,5,opt,name=method" json:"method,omitempty"`
+	Path            string `protobuf:"bytes,6,opt,name=path" json:"path,omitempty"`
+	Status          int32  `protobuf:"varint,7,opt,name=status" json:"status,omitempty"`
+	Latency         int64  `protobuf:"varint,8,opt,name=latency" json:"latency,omitempty"`
+	FirstByte       int64  `protobuf:"varint,9,opt,name=first_byte,json=firstByte" json:"first_byte,omitempty"`
+	RequestSize     int64  `protobuf:"varint,10,opt,name=request_size,json=requestSize" json:"request_size,omitempty"`
+	ResponseSize    int64  `protobuf:"varint,11,opt,name=response_size,json=responseSize" json:"response_size,omitempty"`
+	Statement       string `protobuf:"bytes,12,opt,name=statement" json:"statement,omitempty"`
+	ClientContextId string `protobuf:"bytes,13,opt,name=client_context_id,json=clientContextId" json:"client_context_id,omitempty"`
+}
+
+func (m *AgentResultsResponse_HttpRequest) Reset()         { *m = AgentResultsResponse_HttpRequest{} }
+func (m *AgentResultsResponse_HttpRequest) String() string { return proto.CompactTextString(m) }
+func (*AgentResultsResponse_HttpRequest) ProtoMessage()    {}
+func (*AgentResultsResponse_HttpRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor0, []int{5, 10}
+}
+
+func (m *AgentResultsResponse_HttpRequest) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_HttpRequest) GetClient() string {
+	if m != nil {
+		return m.Client
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_HttpRequest) GetServer() string {
+	if m != nil {
+		return m.Server
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_HttpRequest) GetService() string {
+	if m != nil {
+		return m.Service
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_HttpRequest) GetMethod() string {
+	if m != nil {
+		return m.Method
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_HttpRequest) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_HttpRequest) GetStatus() int32 {
+	if m != nil {
+		return m.Status
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_HttpRequest) GetLatency() int64 {
+	if m != nil {
+		return m.Latency
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_HttpRequest) GetFirstByte() int64 {
+	if m != nil {
+		return m.FirstByte
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_HttpRequest) GetRequestSize() int64 {
+	if m != nil {
+		return m.RequestSize
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_HttpRequest) GetResponseSize() int64 {
+	if m != nil {
+		return m.ResponseSize
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_HttpRequest) GetStatement() string {
+	if m != nil {
+		return m.Statement
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_HttpRequest) GetClientContextId() string {
+	if m != nil {
+		return m.ClientContextId
+	}
+	return ""
+}
+
+type AgentResultsResponse_ConfigStream struct {
+	Client      string `protobuf:"bytes,1,opt,name=client" json:"client,omitempty"`
+	Server      string `protobuf:"bytes,2,opt,name=server" json:"server,omitempty"`
+	Path        string `protobuf:"bytes,3,opt,name=path" json:"path,omitempty"`
+	Resumed     bool   `protobuf:"varint,4,opt,name=resumed" json:"resumed,omitempty"`
+	Start       int64  `protobuf:"varint,5,opt,name=start" json:"start,omitempty"`
+	FirstConfig int64  `protobuf:"varint,6,opt,name=first_config,json=firstConfig" json:"first_config,omitempty"`
+	LastConfig  int64  `protobuf:"varint,7,opt,name=last_config,json=lastConfig" json:"last_config,omitempty"`
+	Configs     uint64 `protobuf:"varint,8,opt,name=configs" json:"configs,omitempty"`
+	Bytes       int64  `protobuf:"varint,9,opt,name=bytes" json:"bytes,omitempty"`
+	MinInterval int64  `protobuf:"varint,10,opt,name=min_interval,json=minInterval" json:"min_interval,omitempty"`
+	MaxInterval int64  `protobuf:"varint,11,opt,name=max_interval,json=maxInterval" json:"max_interval,omitempty"`
+}
+
+func (m *AgentResultsResponse_ConfigStream) Reset()         { *m = AgentResultsResponse_ConfigStream{} }
+func (m *AgentResultsResponse_ConfigStream) String() string { return proto.CompactTextString(m) }
+func (*AgentResultsResponse_ConfigStream) ProtoMessage()    {}
+func (*AgentResultsResponse_ConfigStream) Descriptor() ([]byte, []int) {
+	return fileDescriptor0, []int{5, 11}
+}
+
+func (m *AgentResultsResponse_ConfigStream) GetClient() string {
+	if m != nil {
+		return m.Client
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_ConfigStream) GetServer() string {
+	if m != nil {
+		return m.Server
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_ConfigStream) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *AgentResultsResponse_ConfigStream) GetResumed() bool {
+	if m != nil {
+		return m.Resumed
+	}
+	return false
+}
+
+func (m *AgentResultsResponse_ConfigStream) GetStart() int64 {
+	if m != nil {
+		return m.Start
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_ConfigStream) GetFirstConfig() int64 {
+	if m != nil {
+		return m.FirstConfig
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_ConfigStream) GetLastConfig() int64 {
+	if m != nil {
+		return m.LastConfig
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_ConfigStream) GetConfigs() uint64 {
+	if m != nil {
+		return m.Configs
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_ConfigStream) GetBytes() int64 {
+	if m != nil {
+		return m.Bytes
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_ConfigStream) GetMinInterval() int64 {
+	if m != nil {
+		return m.MinInterval
+	}
+	return 0
+}
+
+func (m *AgentResultsResponse_ConfigStream) GetMaxInterval() int64 {
+	if m != nil {
+		return m.MaxInterval
+	}
+	return 0
+}
+
+type AgentRegisterRequest struct {
+	Address string `protobuf:"bytes,1,opt,name=address" json:"address,omitempty"`
+}
+
+func (m *AgentRegisterRequest) Reset()                    { *m = AgentRegisterRequest{} }
+func (m *AgentRegisterRequest) String() string            { return proto.CompactTextString(m) }
+func (*AgentRegisterRequest) ProtoMessage()               {}
+func (*AgentRegisterRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *AgentRegisterRequest) GetAddress() string {
+	if m != nil {
+		return m.Address
+	}
+	return ""
+}
+
+type CoordinatorRegisterResponse struct {
+	Status string `protobuf:"bytes,1,opt,name=status" json:"status,omitempty"`
+}
+
+func (m *CoordinatorRegisterResponse) Reset()                    { *m = CoordinatorRegisterResponse{} }
+func (m *CoordinatorRegisterResponse) String() string            { return proto.CompactTextString(m) }
+func (*CoordinatorRegisterResponse) ProtoMessage()               {}
+func (*CoordinatorRegisterResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *CoordinatorRegisterResponse) GetStatus() string {
+	if m != nil {
+		return m.Status
+	}
+	return ""
+}
+
+type AgentDeregisterRequest struct {
+	Address string `protobuf:"bytes,1,opt,name=address" json:"address,omitempty"`
+}
+
+func (m *AgentDeregisterRequest) Reset()                    { *m = AgentDeregisterRequest{} }
+func (m *AgentDeregisterRequest) String() string            { return proto.CompactTextString(m) }
+func (*AgentDeregisterRequest) ProtoMessage()               {}
+func (*AgentDeregisterRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *AgentDeregisterRequest) GetAddress() string {
+	if m != nil {
+		return m.Address
+	}
+	return ""
+}
+
+type CoordinatorDeregisterResponse struct {
+	Status string `protobuf:"bytes,1,opt,name=status" json:"status,omitempty"`
+}
+
+func (m *CoordinatorDeregisterResponse) Reset()                    { *m = CoordinatorDeregisterResponse{} }
+func (m *CoordinatorDeregisterResponse) String() string            { return proto.CompactTextString(m) }
+func (*CoordinatorDeregisterResponse) ProtoMessage()               {}
+func (*CoordinatorDeregisterResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *CoordinatorDeregisterResponse) GetStatus() string {
+	if m != nil {
+		return m.Status
 	}
 	return ""
 }
@@ -159,6 +1357,21 @@ func init() {
 	proto.RegisterType((*CoordinatorResultsRequest)(nil), "rpc.CoordinatorResultsRequest")
 	proto.RegisterType((*AgentResultsResponse)(nil), "rpc.AgentResultsResponse")
 	proto.RegisterType((*AgentResultsResponse_CaptureInfo)(nil), "rpc.AgentResultsResponse.CaptureInfo")
+	proto.RegisterType((*AgentResultsResponse_SubdocSpec)(nil), "rpc.AgentResultsResponse.SubdocSpec")
+	proto.RegisterType((*AgentResultsResponse_SlowOp)(nil), "rpc.AgentResultsResponse.SlowOp")
+	proto.RegisterType((*AgentResultsResponse_HotKey)(nil), "rpc.AgentResultsResponse.HotKey")
+	proto.RegisterType((*AgentResultsResponse_TlsConnection)(nil), "rpc.AgentResultsResponse.TlsConnection")
+	proto.RegisterType((*AgentResultsResponse_DcpStream)(nil), "rpc.AgentResultsResponse.DcpStream")
+	proto.RegisterType((*AgentResultsResponse_DcpConnection)(nil), "rpc.AgentResultsResponse.DcpConnection")
+	proto.RegisterType((*AgentResultsResponse_ClustermapNotification)(nil), "rpc.AgentResultsResponse.ClustermapNotification")
+	proto.RegisterType((*AgentResultsResponse_ServerPushConnection)(nil), "rpc.AgentResultsResponse.ServerPushConnection")
+	proto.RegisterType((*AgentResultsResponse_NotMyVbucket)(nil), "rpc.AgentResultsResponse.NotMyVbucket")
+	proto.RegisterType((*AgentResultsResponse_HttpRequest)(nil), "rpc.AgentResultsResponse.HttpRequest")
+	proto.RegisterType((*AgentResultsResponse_ConfigStream)(nil), "rpc.AgentResultsResponse.ConfigStream")
+	proto.RegisterType((*AgentRegisterRequest)(nil), "rpc.AgentRegisterRequest")
+	proto.RegisterType((*CoordinatorRegisterResponse)(nil), "rpc.CoordinatorRegisterResponse")
+	proto.RegisterType((*AgentDeregisterRequest)(nil), "rpc.AgentDeregisterRequest")
+	proto.RegisterType((*CoordinatorDeregisterResponse)(nil), "rpc.CoordinatorDeregisterResponse")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -299,29 +1512,225 @@ var _AgentService_serviceDesc = grpc.ServiceDesc{
 	Metadata: "AgentService.proto",
 }
 
+// Client API for CoordinatorService service
+
+type CoordinatorServiceClient interface {
+	RegisterAgent(ctx context.Context, in *AgentRegisterRequest, opts ...grpc.CallOption) (*CoordinatorRegisterResponse, error)
+	DeregisterAgent(ctx context.Context, in *AgentDeregisterRequest, opts ...grpc.CallOption) (*CoordinatorDeregisterResponse, error)
+}
+
+type coordinatorServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewCoordinatorServiceClient(cc *grpc.ClientConn) CoordinatorServiceClient {
+	return &coordinatorServiceClient{cc}
+}
+
+func (c *coordinatorServiceClient) RegisterAgent(ctx context.Context, in *AgentRegisterRequest, opts ...grpc.CallOption) (*CoordinatorRegisterResponse, error) {
+	out := new(CoordinatorRegisterResponse)
+	err := grpc.Invoke(ctx, "/rpc.CoordinatorService/RegisterAgent", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) DeregisterAgent(ctx context.Context, in *AgentDeregisterRequest, opts ...grpc.CallOption) (*CoordinatorDeregisterResponse, error) {
+	out := new(CoordinatorDeregisterResponse)
+	err := grpc.Invoke(ctx, "/rpc.CoordinatorService/DeregisterAgent", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for CoordinatorService service
+
+type CoordinatorServiceServer interface {
+	RegisterAgent(context.Context, *AgentRegisterRequest) (*CoordinatorRegisterResponse, error)
+	DeregisterAgent(context.Context, *AgentDeregisterRequest) (*CoordinatorDeregisterResponse, error)
+}
+
+func RegisterCoordinatorServiceServer(s *grpc.Server, srv CoordinatorServiceServer) {
+	s.RegisterService(&_CoordinatorService_serviceDesc, srv)
+}
+
+func _CoordinatorService_RegisterAgent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AgentRegisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).RegisterAgent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.CoordinatorService/RegisterAgent",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).RegisterAgent(ctx, req.(*AgentRegisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_DeregisterAgent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AgentDeregisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).DeregisterAgent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.CoordinatorService/DeregisterAgent",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).DeregisterAgent(ctx, req.(*AgentDeregisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _CoordinatorService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "rpc.CoordinatorService",
+	HandlerType: (*CoordinatorServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RegisterAgent",
+			Handler:    _CoordinatorService_RegisterAgent_Handler,
+		},
+		{
+			MethodName: "DeregisterAgent",
+			Handler:    _CoordinatorService_DeregisterAgent_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "AgentService.proto",
+}
+
 func init() { proto.RegisterFile("AgentService.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 334 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x94, 0x92, 0xcd, 0x4a, 0xc3, 0x40,
-	0x14, 0x85, 0x9b, 0x04, 0x0b, 0xbd, 0x55, 0x94, 0x41, 0x24, 0xad, 0x22, 0x25, 0x20, 0xd4, 0x4d,
-	0x16, 0x75, 0x23, 0xba, 0xd2, 0x22, 0x52, 0xd4, 0x4d, 0x8a, 0x0f, 0x30, 0xa6, 0xd7, 0x52, 0x0c,
-	0x33, 0xd3, 0xf9, 0x29, 0xe4, 0xc5, 0x7c, 0x35, 0xb7, 0x92, 0x64, 0x9a, 0xa4, 0xa9, 0x55, 0xdc,
-	0x65, 0x4e, 0xe6, 0x7c, 0xf7, 0xdc, 0xe4, 0x00, 0xb9, 0x9b, 0x23, 0xd3, 0x53, 0x94, 0xab, 0x45,
-	0x8c, 0xa1, 0x90, 0x5c, 0x73, 0xe2, 0x49, 0x11, 0x07, 0xa7, 0xd0, 0x1b, 0x73, 0x2e, 0x67, 0x0b,
-	0x46, 0x35, 0x97, 0x63, 0x2a, 0xb4, 0x91, 0x18, 0xe1, 0xd2, 0xa0, 0xd2, 0x41, 0x08, 0xc7, 0xb9,
-	0xaf, 0x94, 0x95, 0xe0, 0x4c, 0x21, 0x39, 0x81, 0xb6, 0xd2, 0x54, 0x1b, 0xe5, 0x3b, 0x03, 0x67,
-	0xd8, 0x89, 0xec, 0xa9, 0x01, 0x7b, 0xe4, 0x7c, 0x76, 0x9f, 0x6e, 0xc1, 0x4a, 0xf9, 0x5f, 0xb0,
-	0x08, 0x95, 0x49, 0xb4, 0x5a, 0xc3, 0x3e, 0x5d, 0x4b, 0x2b, 0xf5, 0xdf, 0x69, 0x64, 0x02, 0x10,
-	0x17, 0x5b, 0xbc, 0x50, 0xe1, 0xbb, 0x03, 0x6f, 0xd8, 0x1d, 0x5d, 0x86, 0x52, 0xc4, 0xe1, 0x4f,
-	0x98, 0x70, 0x5c, 0xde, 0x7d, 0x60, 0x5a, 0xa6, 0x51, 0xcd, 0xdc, 0x7f, 0x85, 0xae, 0x7d, 0x3d,
-	0x61, 0xef, 0x9c, 0x9c, 0x41, 0x87, 0x8b, 0x84, 0x6a, 0x64, 0x71, 0x6a, 0x87, 0x56, 0x02, 0x39,
-	0x02, 0xef, 0x03, 0x53, 0xdf, 0xcd, 0xf5, 0xec, 0x31, 0x4b, 0xc8, 0x05, 0x5d, 0x1a, 0xf4, 0xbd,
-	0x22, 0x61, 0x71, 0xea, 0xcf, 0xe0, 0xb0, 0x31, 0x75, 0x6d, 0x76, 0x2a, 0xf3, 0x2d, 0xec, 0xad,
-	0x68, 0x62, 0x30, 0x07, 0x76, 0x47, 0x17, 0x7f, 0x6e, 0x90, 0x45, 0x8c, 0x0a, 0xcf, 0x8d, 0x7b,
-	0xed, 0x8c, 0xbe, 0x1c, 0xd8, 0xaf, 0x77, 0x81, 0x3c, 0xc3, 0x81, 0xbd, 0x3a, 0x5d, 0xcc, 0x19,
-	0x4d, 0xc8, 0x79, 0xce, 0xdc, 0x59, 0x8a, 0x7e, 0xaf, 0x9a, 0xd9, 0xe8, 0x45, 0xd0, 0xca, 0x68,
-	0xf6, 0xff, 0xee, 0xa2, 0x6d, 0xb6, 0xa2, 0x4e, 0x6b, 0x14, 0x23, 0x68, 0x91, 0x27, 0x9b, 0xd5,
-	0xee, 0xb6, 0x0d, 0xdb, 0x6c, 0x45, 0x1d, 0xd6, 0xf8, 0x1c, 0x41, 0xeb, 0xad, 0x9d, 0xb7, 0xfe,
-	0xea, 0x3b, 0x00, 0x00, 0xff, 0xff, 0xc8, 0x09, 0x2c, 0x60, 0x0b, 0x03, 0x00, 0x00,
+	// 1919 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x58, 0xcd, 0x6e, 0x24, 0xb7,
+	0x11, 0xde, 0xf9, 0x9f, 0xa9, 0xd1, 0x48, 0x5a, 0x42, 0x59, 0xf4, 0xce, 0x7a, 0x1d, 0x65, 0x6c,
+	0xc7, 0xeb, 0xfc, 0x08, 0xc6, 0x1a, 0x41, 0x02, 0x1b, 0x39, 0xd8, 0x52, 0x90, 0x28, 0xde, 0x5d,
+	0x2f, 0x5a, 0x1b, 0x5f, 0x1b, 0x54, 0x77, 0x49, 0xd3, 0xd0, 0x74, 0x37, 0xb7, 0xc9, 0x96, 0x35,
+	0x3e, 0xe7, 0x9e, 0x4b, 0xde, 0x21, 0xaf, 0x10, 0x04, 0x41, 0x1e, 0x21, 0x0f, 0x90, 0x53, 0xee,
+	0x79, 0x80, 0x5c, 0x03, 0x16, 0xc9, 0x6e, 0xb6, 0x46, 0xd2, 0x66, 0x0d, 0x9f, 0x66, 0xea, 0x63,
+	0xb1, 0x48, 0xd6, 0x57, 0x55, 0xac, 0x26, 0xb0, 0xcf, 0xcf, 0x31, 0x57, 0x27, 0x58, 0x5e, 0xa6,
+	0x31, 0x1e, 0x88, 0xb2, 0x50, 0x05, 0xeb, 0x95, 0x22, 0x5e, 0x3c, 0x82, 0x87, 0x87, 0x45, 0x51,
+	0x26, 0x69, 0xce, 0x55, 0x51, 0x1e, 0x72, 0xa1, 0xaa, 0x12, 0x43, 0x7c, 0x5d, 0xa1, 0x54, 0x8b,
+	0x03, 0xd8, 0xa3, 0x79, 0x35, 0x2c, 0x45, 0x91, 0x4b, 0x64, 0x0f, 0x60, 0x28, 0x15, 0x57, 0x95,
+	0x0c, 0x3a, 0xfb, 0x9d, 0x27, 0x93, 0xd0, 0x4a, 0xd7, 0x8c, 0xfd, 0xb6, 0x28, 0x92, 0x2f, 0xd6,
+	0x1b, 0xc6, 0x6a, 0xf8, 0xad, 0x8c, 0x85, 0x28, 0xab, 0x95, 0x92, 0xce, 0xd8, 0x9f, 0xde, 0xb7,
+	0xd6, 0x6a, 0xfc, 0x6e, 0x6b, 0xec, 0x18, 0x20, 0x36, 0xa7, 0x78, 0xce, 0x45, 0xd0, 0xdd, 0xef,
+	0x3d, 0x99, 0x3e, 0xfd, 0xe8, 0xa0, 0x14, 0xf1, 0xc1, 0x4d, 0x66, 0x0e, 0x0e, 0x6b, 0xdd, 0xdf,
+	0xe4, 0xaa, 0x5c, 0x87, 0xde, 0x64, 0xf6, 0x11, 0xec, 0x0a, 0x1e, 0x5f, 0xa0, 0x92, 0x51, 0x89,
+	0x31, 0xa6, 0x97, 0x98, 0x04, 0xbd, 0xfd, 0xce, 0x93, 0x7e, 0xb8, 0x63, 0xf1, 0xd0, 0xc2, 0xec,
+	0x43, 0x70, 0x50, 0x94, 0x94, 0x85, 0x10, 0x98, 0x04, 0x7d, 0xd2, 0xdc, 0xb6, 0xf0, 0x91, 0x41,
+	0xd9, 0x67, 0x30, 0x96, 0xab, 0xe2, 0x9b, 0xa8, 0x10, 0x32, 0x18, 0xd0, 0xe6, 0xf6, 0x6f, 0xdf,
+	0xdc, 0xc9, 0xaa, 0xf8, 0xe6, 0x2b, 0x11, 0x8e, 0x24, 0xfd, 0x4a, 0x3d, 0x79, 0x59, 0xa8, 0xe8,
+	0x02, 0xd7, 0x32, 0x18, 0xbe, 0x69, 0xf2, 0xef, 0x0a, 0xf5, 0x25, 0xae, 0xc3, 0xd1, 0x92, 0x7e,
+	0x25, 0x7b, 0x0c, 0x70, 0x81, 0xeb, 0x48, 0x14, 0xab, 0x34, 0x5e, 0x07, 0x23, 0x72, 0xda, 0xe4,
+	0x02, 0xd7, 0x2f, 0x09, 0x60, 0x2f, 0x61, 0x47, 0xad, 0x64, 0x14, 0x17, 0x79, 0x8e, 0xb1, 0x4a,
+	0x8b, 0x5c, 0x06, 0x63, 0x5a, 0xe2, 0xc3, 0xdb, 0x97, 0x78, 0xb5, 0x92, 0x87, 0xb5, 0x7e, 0xb8,
+	0xad, 0x7c, 0x51, 0x6a, 0x8b, 0x49, 0x2c, 0x5a, 0x16, 0x27, 0x6f, 0xb2, 0x78, 0x14, 0x0b, 0xdf,
+	0x62, 0xe2, 0x8b, 0x92, 0x7d, 0x05, 0x53, 0x89, 0xe5, 0x25, 0x96, 0x91, 0xa8, 0xe4, 0x32, 0x00,
+	0xb2, 0x76, 0x70, 0x87, 0xff, 0x48, 0xf9, 0x65, 0x25, 0x97, 0x9e, 0x51, 0x90, 0x35, 0xca, 0x5e,
+	0xc0, 0x4e, 0x5e, 0xa8, 0x28, 0x5b, 0x47, 0x97, 0xa7, 0x15, 0xf1, 0x14, 0x4c, 0xc9, 0xe8, 0x8f,
+	0x6f, 0x37, 0xfa, 0xa2, 0x50, 0xcf, 0xd7, 0x5f, 0x1b, 0xf5, 0x70, 0x96, 0x7b, 0x92, 0x64, 0xbf,
+	0x87, 0xd9, 0x52, 0x29, 0x11, 0x95, 0x26, 0x7a, 0x65, 0xb0, 0x45, 0xd6, 0x3e, 0xb8, 0x83, 0x25,
+	0xa5, 0x84, 0x8d, 0xf5, 0x70, 0x6b, 0xd9, 0x08, 0x52, 0x47, 0x9f, 0x0e, 0x69, 0xcc, 0x30, 0x57,
+	0x8e, 0xb5, 0x19, 0xb1, 0xb6, 0x53, 0xe3, 0x96, 0xbb, 0xe7, 0xb0, 0x1d, 0x17, 0xf9, 0x59, 0x7a,
+	0x1e, 0x49, 0x55, 0x22, 0xcf, 0x64, 0xb0, 0xfd, 0xa6, 0x53, 0x1c, 0x92, 0xfe, 0x09, 0xa9, 0x87,
+	0xb3, 0xd8, 0x93, 0xe4, 0xfc, 0xdf, 0x5d, 0x98, 0xda, 0xbc, 0x38, 0xce, 0xcf, 0x0a, 0xf6, 0x0e,
+	0x4c, 0x0a, 0xb1, 0xe2, 0x0a, 0xf3, 0x78, 0x6d, 0xb3, 0xad, 0x01, 0xd8, 0x2e, 0xf4, 0x2e, 0x70,
+	0x1d, 0x74, 0x09, 0xd7, 0x7f, 0x75, 0x6a, 0x16, 0x82, 0xbf, 0xae, 0x90, 0xb2, 0x65, 0x12, 0x5a,
+	0xc9, 0xe0, 0x71, 0x91, 0x60, 0xd0, 0x77, 0xb8, 0x96, 0xbc, 0x54, 0x1e, 0xb4, 0x52, 0xf9, 0x01,
+	0x0c, 0x8d, 0x63, 0x83, 0xa1, 0xc1, 0x8d, 0xc4, 0xe6, 0x30, 0x4e, 0xb8, 0xe2, 0x6a, 0x2d, 0xd0,
+	0xc6, 0x71, 0x2d, 0xeb, 0x28, 0xbf, 0xe4, 0xab, 0x0a, 0x23, 0x99, 0x7e, 0x8b, 0xc1, 0x78, 0xbf,
+	0xf3, 0x64, 0x16, 0x4e, 0x08, 0x39, 0x49, 0xbf, 0x45, 0xf6, 0x53, 0xb8, 0x5f, 0xe5, 0x71, 0x91,
+	0x89, 0x12, 0xa5, 0xc4, 0xc4, 0x68, 0x4d, 0x48, 0x6b, 0xd7, 0x1f, 0x20, 0xe5, 0xc7, 0x00, 0x82,
+	0xab, 0x65, 0x14, 0x17, 0x55, 0xae, 0x02, 0x30, 0xb6, 0x34, 0x72, 0xa8, 0x01, 0xf6, 0x29, 0x0c,
+	0xa4, 0xc0, 0xd8, 0x85, 0xcc, 0xfb, 0x77, 0xc4, 0x61, 0x75, 0x9a, 0x14, 0xf1, 0x89, 0xc0, 0x38,
+	0x34, 0x53, 0xe6, 0x2f, 0x01, 0x1a, 0xd0, 0x73, 0x4c, 0xa7, 0xe5, 0x18, 0x06, 0x7d, 0xbd, 0x9c,
+	0xf5, 0x2d, 0xfd, 0xf7, 0x9c, 0xd5, 0xf3, 0x9d, 0x35, 0xff, 0x4f, 0x17, 0x86, 0xa6, 0x5e, 0x68,
+	0xbe, 0x54, 0x9a, 0xa1, 0x54, 0x3c, 0x13, 0x64, 0xb1, 0x17, 0x36, 0x80, 0x36, 0x10, 0xaf, 0x52,
+	0xcc, 0x95, 0x35, 0x6b, 0x25, 0x32, 0x4c, 0x99, 0x51, 0x1b, 0x26, 0xc9, 0x63, 0xb3, 0x4f, 0x1e,
+	0xd8, 0x64, 0x73, 0x70, 0x0b, 0x9b, 0xc3, 0x16, 0x9b, 0x01, 0x8c, 0x6c, 0x92, 0x11, 0x69, 0xb3,
+	0xd0, 0x89, 0x1e, 0xcf, 0xe3, 0x16, 0xcf, 0x36, 0xb2, 0x26, 0x4d, 0x64, 0xb5, 0xd9, 0x85, 0xeb,
+	0xec, 0x06, 0x30, 0x72, 0x61, 0x3a, 0xa5, 0x63, 0x3b, 0x51, 0xd7, 0x67, 0x5b, 0x39, 0x92, 0xaa,
+	0xe4, 0xba, 0x0e, 0x04, 0x5b, 0xa4, 0xb1, 0x6d, 0xe0, 0x23, 0x8b, 0xb2, 0x0f, 0x60, 0x5b, 0xa4,
+	0x02, 0x57, 0x69, 0x8e, 0x51, 0x82, 0x42, 0x2d, 0x29, 0xe7, 0x66, 0xe1, 0xcc, 0xa1, 0x47, 0x1a,
+	0x9c, 0x9f, 0xc2, 0xd0, 0xd4, 0x57, 0x6f, 0xf3, 0x9d, 0x9b, 0x36, 0xef, 0xa5, 0xc5, 0x1e, 0x0c,
+	0x4c, 0x24, 0x99, 0x3b, 0xc4, 0x08, 0xfe, 0x9e, 0xcd, 0x8d, 0xe1, 0xc4, 0xf9, 0x3f, 0x7a, 0x30,
+	0x6b, 0x55, 0x58, 0x8f, 0xba, 0xce, 0x2d, 0xd4, 0x75, 0x5b, 0xd4, 0x69, 0x97, 0x63, 0x29, 0xf5,
+	0x69, 0x0d, 0xa7, 0x4e, 0x64, 0x3f, 0x82, 0xad, 0x38, 0x15, 0x4b, 0x2c, 0x23, 0x59, 0xa5, 0xca,
+	0x25, 0xe4, 0xd4, 0x60, 0x27, 0x1a, 0x62, 0xef, 0x02, 0x24, 0x18, 0x97, 0x6b, 0x41, 0xde, 0x32,
+	0x1c, 0x7b, 0x88, 0x36, 0x5e, 0x62, 0x5c, 0x94, 0x89, 0x21, 0xba, 0x1f, 0x3a, 0x51, 0xc7, 0x1f,
+	0x5e, 0xc5, 0x4b, 0x9e, 0x9f, 0xa3, 0x24, 0xae, 0xfb, 0x61, 0x03, 0xf8, 0x07, 0x1e, 0xb7, 0x49,
+	0xfa, 0x21, 0x4c, 0x33, 0x7e, 0x15, 0xb9, 0xd1, 0x09, 0x8d, 0x42, 0xc6, 0xaf, 0x9e, 0x59, 0x85,
+	0x9f, 0x03, 0x5b, 0xf2, 0x3c, 0x91, 0x4b, 0x7e, 0x81, 0x0d, 0x91, 0x40, 0x7a, 0xf7, 0xeb, 0x91,
+	0x9a, 0x4b, 0xda, 0xa1, 0xac, 0x32, 0x4c, 0x28, 0x1c, 0xc6, 0xa1, 0x13, 0xb5, 0xc3, 0xf8, 0x0a,
+	0x4b, 0x2a, 0xd0, 0x14, 0xd3, 0x46, 0xd2, 0xf1, 0xb5, 0xe2, 0x52, 0x45, 0x24, 0xda, 0x6a, 0x3b,
+	0xd1, 0xc8, 0xe7, 0x1a, 0xd0, 0xeb, 0xbb, 0x73, 0xd8, 0x5d, 0xa6, 0x68, 0x6a, 0x6d, 0x2f, 0xbc,
+	0xef, 0x46, 0x9e, 0xb9, 0x81, 0xf9, 0x3f, 0x7b, 0x30, 0x39, 0x8a, 0x85, 0x29, 0xab, 0x5e, 0x1e,
+	0x75, 0x5a, 0x79, 0xe4, 0xe5, 0x45, 0x77, 0x23, 0x2f, 0x6e, 0x4a, 0x75, 0xed, 0x27, 0xa9, 0x78,
+	0xa9, 0x22, 0x89, 0xaf, 0xf3, 0xc2, 0x86, 0x0d, 0x10, 0x74, 0xa2, 0x11, 0xf6, 0x08, 0x26, 0x98,
+	0x27, 0x76, 0x78, 0x40, 0xc3, 0x63, 0xcc, 0x13, 0x33, 0xe8, 0xce, 0x68, 0x46, 0x0d, 0x75, 0x74,
+	0x46, 0x33, 0xfc, 0x0e, 0x4c, 0xb2, 0x4a, 0x71, 0x73, 0x5f, 0x5b, 0xf2, 0x6a, 0x40, 0x8f, 0x26,
+	0xb8, 0x42, 0xd7, 0x1f, 0xd0, 0x68, 0x0d, 0xb0, 0x7d, 0x98, 0xe2, 0x95, 0x48, 0x4b, 0xee, 0x6e,
+	0x7b, 0x3d, 0xee, 0x43, 0x3a, 0x07, 0x4e, 0xd7, 0x0a, 0x25, 0x91, 0xd6, 0x0f, 0x8d, 0x40, 0x05,
+	0xdd, 0xb1, 0x69, 0x12, 0xb7, 0x96, 0xf5, 0x8a, 0x32, 0xe7, 0x42, 0x2e, 0x0b, 0xcb, 0x56, 0x3f,
+	0x6c, 0x00, 0xf6, 0x33, 0x60, 0x3a, 0x64, 0x1c, 0x10, 0xa5, 0x0a, 0x33, 0x49, 0xc4, 0xf5, 0xc3,
+	0xdd, 0x8c, 0x5f, 0x9d, 0xd8, 0x81, 0x63, 0x8d, 0x6f, 0x68, 0x9b, 0xad, 0x6c, 0x6f, 0x68, 0x7f,
+	0x41, 0xbb, 0xda, 0x85, 0x1e, 0xe6, 0x49, 0xb0, 0x63, 0x32, 0x18, 0xf3, 0x64, 0xfe, 0xaf, 0x2e,
+	0xcc, 0x5a, 0x1d, 0xca, 0x5b, 0x67, 0x24, 0x83, 0x7e, 0xce, 0x33, 0x77, 0x31, 0xd2, 0x7f, 0x7d,
+	0x7a, 0x51, 0x16, 0x49, 0x15, 0x63, 0x49, 0x5c, 0x8e, 0xc3, 0x5a, 0xd6, 0x54, 0x9f, 0x56, 0x67,
+	0x67, 0x58, 0x9a, 0x8a, 0x37, 0xa0, 0x00, 0x01, 0x03, 0x51, 0xc9, 0xb3, 0x39, 0x53, 0xe5, 0xba,
+	0xcd, 0x4c, 0x2c, 0x9d, 0x3a, 0x67, 0xfe, 0x60, 0x10, 0xed, 0x71, 0x33, 0x64, 0xb8, 0x34, 0x82,
+	0x0d, 0xad, 0xd5, 0x4a, 0xda, 0x2b, 0xd2, 0x4a, 0x3a, 0x38, 0xe8, 0x5f, 0xa4, 0xef, 0x0b, 0x9b,
+	0x81, 0x13, 0x42, 0x5e, 0xa5, 0x19, 0xea, 0xc0, 0x22, 0x07, 0x6a, 0xc0, 0xe6, 0xdd, 0x58, 0xfb,
+	0x4d, 0xcb, 0xec, 0xd7, 0x30, 0x72, 0xed, 0x87, 0xb9, 0x11, 0xdf, 0xbb, 0xb3, 0xcf, 0xb3, 0xbd,
+	0x87, 0x9b, 0x33, 0xff, 0x73, 0x07, 0x1e, 0x1c, 0xae, 0x2a, 0xa9, 0xb0, 0xcc, 0xb8, 0x78, 0x51,
+	0xa8, 0xf4, 0x2c, 0x8d, 0xeb, 0x18, 0xb8, 0xfb, 0x42, 0xf3, 0xf2, 0xa7, 0xa9, 0xc0, 0x7b, 0x30,
+	0x40, 0x51, 0xc4, 0x4b, 0x72, 0x76, 0x2f, 0x34, 0x82, 0xf6, 0x76, 0x89, 0x97, 0x29, 0x15, 0xc5,
+	0xbe, 0x39, 0x81, 0x93, 0x35, 0x3b, 0x9e, 0x9b, 0xe9, 0xff, 0xfc, 0xaf, 0x5d, 0xd8, 0xbb, 0xa9,
+	0x8f, 0x7c, 0x6b, 0xea, 0x69, 0x61, 0xdb, 0x16, 0x9a, 0x1b, 0xa0, 0x96, 0xf5, 0x01, 0x4b, 0xeb,
+	0x1a, 0x69, 0xf3, 0xb9, 0x01, 0x74, 0x27, 0xe8, 0x84, 0xba, 0x38, 0x0e, 0x68, 0xeb, 0x3b, 0x0e,
+	0x77, 0x15, 0xf2, 0x6b, 0x98, 0xe5, 0x9e, 0xe7, 0xdc, 0x67, 0xc2, 0xc7, 0x77, 0x34, 0x82, 0x37,
+	0xba, 0x3c, 0x6c, 0x9b, 0x61, 0x9f, 0xc0, 0x0f, 0xec, 0x77, 0x4d, 0xd4, 0xb6, 0x6f, 0xae, 0xf2,
+	0x3d, 0x3b, 0xe8, 0x9b, 0x90, 0xf3, 0xbf, 0x74, 0x61, 0xcb, 0xef, 0x96, 0xbf, 0xff, 0xc6, 0xc4,
+	0xf2, 0xde, 0x6f, 0xf1, 0xee, 0x15, 0xd4, 0xc1, 0x46, 0x41, 0xb5, 0x2d, 0xcb, 0xb0, 0xd5, 0xb2,
+	0x78, 0x57, 0xd2, 0xa8, 0x7d, 0x25, 0xd5, 0x31, 0x34, 0xbe, 0x2d, 0x86, 0x26, 0xd7, 0x62, 0xe8,
+	0x21, 0x8c, 0x33, 0x2e, 0xfc, 0x06, 0x65, 0x94, 0x71, 0xe1, 0xda, 0x13, 0xae, 0x14, 0x66, 0x42,
+	0x51, 0x95, 0x9b, 0x85, 0x4e, 0x9c, 0xff, 0xb1, 0x07, 0x53, 0xef, 0x4b, 0xe0, 0x7b, 0x76, 0x54,
+	0x00, 0x23, 0x69, 0x1e, 0x04, 0xac, 0xa7, 0x9c, 0xa8, 0x67, 0x64, 0xa8, 0x96, 0x45, 0xe2, 0x7a,
+	0x38, 0x23, 0xd5, 0x8d, 0xe7, 0xf0, 0xc6, 0xc6, 0x53, 0xfb, 0x68, 0xe0, 0xf7, 0x75, 0xb7, 0xdc,
+	0xe7, 0x8f, 0x01, 0xce, 0xd2, 0x52, 0x9a, 0x3a, 0xeb, 0x8a, 0x09, 0x21, 0xba, 0xc0, 0xea, 0x1e,
+	0xc4, 0x26, 0x40, 0xe3, 0xad, 0x5e, 0x38, 0xb5, 0x18, 0x79, 0xec, 0x3d, 0x98, 0xd5, 0x91, 0x4f,
+	0x3a, 0xe6, 0x76, 0xd8, 0x72, 0x20, 0x29, 0xe9, 0x1b, 0xc2, 0x7d, 0x10, 0xd1, 0x0d, 0x31, 0x09,
+	0x1b, 0x80, 0xfd, 0x04, 0xee, 0x1b, 0xf7, 0xe8, 0x0f, 0x51, 0x85, 0x57, 0x2a, 0x4a, 0x13, 0xf7,
+	0x1d, 0x65, 0x06, 0x0e, 0x0d, 0x7e, 0x9c, 0xcc, 0xff, 0xde, 0x85, 0x2d, 0xff, 0xc3, 0xe8, 0xbb,
+	0x94, 0x77, 0xf2, 0x5b, 0xcf, 0xf3, 0x9b, 0xd7, 0x85, 0xf4, 0xdb, 0x5d, 0xc8, 0x1e, 0x0c, 0xe8,
+	0xd2, 0xb6, 0xc9, 0x6c, 0x04, 0xed, 0x16, 0xe3, 0x35, 0xf3, 0x51, 0x46, 0x1c, 0xf4, 0xc2, 0x29,
+	0x61, 0x66, 0x73, 0xba, 0xe8, 0xaf, 0x78, 0xa3, 0x61, 0x62, 0x96, 0x6e, 0x75, 0xab, 0x10, 0xc0,
+	0xc8, 0x8c, 0xb9, 0x4b, 0xda, 0x89, 0xcd, 0x05, 0x6c, 0xe8, 0x30, 0x82, 0x5e, 0x33, 0x4b, 0xf3,
+	0x28, 0xcd, 0x15, 0x96, 0x97, 0xdc, 0x95, 0xf6, 0x69, 0x96, 0xe6, 0xc7, 0x16, 0x22, 0x15, 0x7e,
+	0xd5, 0xa8, 0x4c, 0xad, 0x0a, 0xbf, 0x72, 0x2a, 0xf3, 0x04, 0x76, 0xae, 0x3d, 0xa7, 0xb8, 0x2e,
+	0xb8, 0xd3, 0x74, 0xc1, 0x9f, 0xc1, 0x80, 0x1a, 0x76, 0xf2, 0xdc, 0x9d, 0x9f, 0xc6, 0xde, 0x27,
+	0x68, 0x68, 0xe6, 0x7c, 0xda, 0xfd, 0x55, 0x67, 0xf1, 0x71, 0xfd, 0x20, 0x74, 0x9e, 0x4a, 0x85,
+	0xa5, 0xcb, 0x19, 0x9d, 0x5d, 0x49, 0x52, 0xa2, 0x74, 0x2f, 0x42, 0x4e, 0x5c, 0xfc, 0x02, 0x1e,
+	0xb5, 0x1e, 0x98, 0xdc, 0xbc, 0x37, 0xbc, 0x4b, 0x3d, 0x85, 0x07, 0xb4, 0xd0, 0x11, 0x96, 0xff,
+	0xf7, 0x52, 0xbf, 0x84, 0xc7, 0xde, 0x52, 0xfe, 0xcc, 0xbb, 0x17, 0x7b, 0xfa, 0xdf, 0x0e, 0x6c,
+	0xf9, 0x4f, 0x77, 0xec, 0x19, 0xcc, 0xac, 0x03, 0x4e, 0xd2, 0xf3, 0x9c, 0xaf, 0xd8, 0xbb, 0xe4,
+	0xa9, 0x5b, 0xdf, 0xf0, 0xe6, 0x0f, 0x1b, 0x4f, 0x5e, 0x7b, 0xc6, 0x5b, 0xdc, 0xd3, 0xd6, 0xec,
+	0x73, 0xdc, 0x6d, 0xd6, 0xda, 0x8f, 0x78, 0xbe, 0xb5, 0x6b, 0xef, 0x78, 0x8b, 0x7b, 0xec, 0x4b,
+	0xd8, 0xf2, 0x19, 0xdb, 0x34, 0xd6, 0x7e, 0xc4, 0xf3, 0x8d, 0x5d, 0x23, 0x79, 0x71, 0xef, 0xe9,
+	0xdf, 0x3a, 0xc0, 0xbc, 0xa9, 0xee, 0xfc, 0x2f, 0x60, 0xe6, 0x98, 0xa2, 0x89, 0xac, 0x65, 0xa4,
+	0xc5, 0xc7, 0x7c, 0x7f, 0x73, 0xfd, 0xb6, 0xdb, 0x17, 0xf7, 0xd8, 0x2b, 0xd8, 0x69, 0xe8, 0x30,
+	0x16, 0x1f, 0x35, 0x16, 0x37, 0x38, 0x9e, 0x2f, 0xae, 0xdb, 0xdc, 0x24, 0x73, 0x71, 0xef, 0x74,
+	0x48, 0x2f, 0xac, 0x9f, 0xfc, 0x6f, 0x00, 0xd9, 0xb1, 0x8a, 0x80, 0x77, 0x15, 0x00, 0x00,
 }
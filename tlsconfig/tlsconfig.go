@@ -0,0 +1,155 @@
+/*
+* Copyright (c) 2017 Couchbase, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"io/ioutil"
+)
+
+// Config is the tls section shared by the agent and the coordinator. Cert and Key are the identity
+// presented to the other side, CA verifies the other side. With ClientAuth a server only accepts
+// clients presenting a certificate signed by the CA.
+type Config struct {
+	Cert       string `yaml:"cert"`
+	Key        string `yaml:"key"`
+	CA         string `yaml:"ca"`
+	ClientAuth bool   `yaml:"clientauth"`
+	ServerName string `yaml:"servername"`
+}
+
+// Enabled is true as soon as any part of the tls section is set, a partial section is an error
+// rather than a silent fallback to plaintext.
+func (c *Config) Enabled() bool {
+	return c.Cert != "" || c.Key != "" || c.CA != "" || c.ClientAuth || c.ServerName != ""
+}
+
+func (c *Config) certificates() ([]tls.Certificate, error) {
+	if c.Cert == "" && c.Key == "" {
+		return nil, nil
+	}
+	if c.Cert == "" || c.Key == "" {
+		return nil, fmt.Errorf("tls needs both cert and key")
+	}
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the tls certificate %v", err)
+	}
+	return []tls.Certificate{cert}, nil
+}
+
+func (c *Config) certPool() (*x509.CertPool, error) {
+	if c.CA == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(c.CA)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the tls ca %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in the tls ca %v", c.CA)
+	}
+	return pool, nil
+}
+
+// ServerConfig returns the tls configuration of a server, nil when tls is not configured.
+func (c *Config) ServerConfig() (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	certificates, err := c.certificates()
+	if err != nil {
+		return nil, err
+	}
+	if certificates == nil {
+		return nil, fmt.Errorf("tls on a server needs a cert and key")
+	}
+	config := &tls.Config{Certificates: certificates, MinVersion: tls.VersionTLS12}
+	if c.ClientAuth {
+		if config.ClientCAs, err = c.certPool(); err != nil {
+			return nil, err
+		}
+		if config.ClientCAs == nil {
+			return nil, fmt.Errorf("tls clientauth needs a ca to verify clients with")
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ServerOption returns the credentials for a gRPC server, nil when tls is not configured.
+func (c *Config) ServerOption() (grpc.ServerOption, error) {
+	config, err := c.ServerConfig()
+	if config == nil || err != nil {
+		return nil, err
+	}
+	return grpc.Creds(credentials.NewTLS(config)), nil
+}
+
+// DialOption returns the credentials for a gRPC client, plaintext only when tls is not configured.
+// Without a CA the server is verified against the system roots.
+func (c *Config) DialOption() (grpc.DialOption, error) {
+	if !c.Enabled() {
+		return grpc.WithInsecure(), nil
+	}
+	certificates, err := c.certificates()
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: certificates, ServerName: c.ServerName, MinVersion: tls.VersionTLS12}
+	if config.RootCAs, err = c.certPool(); err != nil {
+		return nil, err
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(config)), nil
+}
+
+// Identities are the names the verified peer certificate of a connection was issued to, its DNS
+// names and common name.
+func Identities(state tls.ConnectionState) []string {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := state.VerifiedChains[0][0]
+	names := append([]string{}, cert.DNSNames...)
+	for _, name := range names {
+		if name == cert.Subject.CommonName {
+			return names
+		}
+	}
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return names
+}
+
+// TokenCredentials sends a shared token on every call. It is only sent over tls, where it is not
+// visible on the network.
+type TokenCredentials string
+
+func (t TokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t TokenCredentials) RequireTransportSecurity() bool {
+	return true
+}